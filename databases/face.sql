@@ -60,6 +60,8 @@ create table costs
     ip_address   text
 );
 
+create index costs_created_date_index on costs (created_date);
+
 create index costs_user_id_index
     on costs (user_id);
 
@@ -89,18 +91,24 @@ create table revenuecat_logs
     current_user_info           json,
     event_timestamp_ms          bigint generated always as (((other_data ->> 'event_timestamp_ms'::text))::bigint) stored,
     created_at                  timestamp default now(),
-    user_id                     text
+    user_id                     text,
+    sandbox                     boolean   default false,
+    skip_reason                 text
 );
 
 create table scans
 (
-    scan_id    text not null default gen_random_uuid()::text
+    scan_id      text not null default gen_random_uuid()::text
         constraint scans_pk
             primary key,
-    user_id    text not null,
-    image_id   text,
-    data       jsonb,
-    created_at timestamp default now()
+    user_id      text not null,
+    image_id     text,
+    data         jsonb,
+    status       text default 'completed' not null,
+    language     text,
+    ip_address   text,
+    created_at   timestamp default now(),
+    completed_at timestamp
 );
 
 create index scans_user_id_index
@@ -114,7 +122,11 @@ create table images
     user_id      text,
     created_date timestamp default now(),
     size         bigint,
-    content_type text
+    content_type text,
+    hash         text,
+    width        integer,
+    height       integer,
+    format       text
 );
 
 create index images_user_id_index on images (user_id);
@@ -128,12 +140,175 @@ create table generative_ai_tasks
     image_id     text,
     prompt       text,
     task_id      text,
-    status       text default 'pending',
+    status       text default 'queued',
     result_url   text,
     raw_response text,
     created_at   timestamp default now(),
-    completed_at timestamp
+    completed_at timestamp,
+    ip_address   text,
+    submitted_at timestamp,
+    claimed_at   timestamp
 );
 
 create index generative_ai_tasks_user_id_index on generative_ai_tasks (user_id);
-create index generative_ai_tasks_task_id_index on generative_ai_tasks (task_id);
\ No newline at end of file
+create index generative_ai_tasks_task_id_index on generative_ai_tasks (task_id);
+create table humanizations
+(
+    id                 text not null default gen_random_uuid()::text
+        constraint humanizations_pk
+            primary key,
+    user_id            text not null,
+    input_text         text not null,
+    output_text        text,
+    original_detection text,
+    final_detection    text,
+    improvement_score  double precision,
+    status             text default 'processing',
+    created_date       timestamp default now(),
+    processed_date     timestamp
+);
+
+create index humanizations_user_id_index on humanizations (user_id);
+
+create table detections
+(
+    id              text not null default gen_random_uuid()::text
+        constraint detections_pk
+            primary key,
+    user_id         text not null,
+    input_text      text not null,
+    ai_probability  double precision,
+    breakdown_json  jsonb,
+    service_results jsonb,
+    status          text default 'processing',
+    created_date    timestamp default now(),
+    processed_date  timestamp
+);
+
+create index detections_user_id_index on detections (user_id);
+
+create table jobs
+(
+    id           text not null default gen_random_uuid()::text
+        constraint jobs_pk
+            primary key,
+    kind         text not null,
+    payload      jsonb,
+    status       text    default 'queued',
+    attempts     integer default 0,
+    max_attempts integer default 5,
+    run_at       timestamp default now(),
+    locked_at    timestamp,
+    last_error   text,
+    created_at   timestamp default now(),
+    finished_at  timestamp
+);
+
+create index jobs_status_run_at_index on jobs (status, run_at);
+
+create table admins
+(
+    id           text not null default gen_random_uuid()::text
+        constraint admins_pk
+            primary key,
+    name         text not null,
+    role         text not null,
+    key_hash     text not null
+        constraint admins_key_hash_uindex
+            unique,
+    disabled     boolean   default false,
+    created_date timestamp default now()
+);
+
+create table admin_audit_logs
+(
+    id             text not null default gen_random_uuid()::text
+        constraint admin_audit_logs_pk
+            primary key,
+    admin_id       text not null,
+    action         text not null,
+    target_user_id text,
+    details        jsonb,
+    ip_address     text,
+    created_date   timestamp default now()
+);
+
+create index admin_audit_logs_target_user_id_index on admin_audit_logs (target_user_id);
+
+create table coin_ledger
+(
+    id            text not null default gen_random_uuid()::text
+        constraint coin_ledger_pk
+            primary key,
+    user_id       text    not null,
+    amount        integer not null,
+    balance_after integer,
+    reason        text    not null,
+    reference_id  text,
+    created_date  timestamp default now(),
+    constraint coin_ledger_user_id_reason_reference_id_uindex
+        unique (user_id, reason, reference_id)
+);
+
+create index coin_ledger_user_id_created_date_id_index on coin_ledger (user_id, created_date, id);
+
+create table usage_events
+(
+    id           bigserial
+        constraint usage_events_pk
+            primary key,
+    user_id      text not null,
+    feature      text not null,
+    reference_id text,
+    created_date timestamptz default now()
+);
+
+create index usage_events_user_id_feature_created_date_index on usage_events (user_id, feature, created_date);
+
+create index usage_events_reference_id_index on usage_events (reference_id);
+
+create table user_quotas
+(
+    user_id      text not null,
+    feature      text not null,
+    hourly_limit integer,
+    daily_limit  integer,
+    constraint user_quotas_pk
+        primary key (user_id, feature)
+);
+
+create table cost_alerts
+(
+    user_id      text not null,
+    day          date not null,
+    cost         double precision,
+    created_date timestamp default now(),
+    constraint cost_alerts_pk
+        primary key (user_id, day)
+);
+
+create table rate_limit_buckets
+(
+    key        text not null
+        constraint rate_limit_buckets_pk
+            primary key,
+    tokens     double precision not null,
+    updated_at timestamptz default now() not null
+);
+
+create index rate_limit_buckets_updated_at_index on rate_limit_buckets (updated_at);
+
+create table scan_shares
+(
+    token        text not null
+        constraint scan_shares_pk
+            primary key,
+    scan_id      text not null
+        constraint scan_shares_scan_id_fk
+            references scans
+            on delete cascade,
+    user_id      text not null,
+    created_date timestamp default now()
+);
+
+create unique index scan_shares_scan_id_uindex on scan_shares (scan_id);
@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"sapps/lib/util"
 	"sapps/pkg/sapps/app"
+	"sapps/pkg/sapps/constant"
 	"sapps/pkg/sapps/script"
 	"syscall"
 
@@ -20,6 +21,9 @@ var (
 func main() {
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if err := constant.Validate(); err != nil {
+		log.Fatalln(err)
+	}
 	script.Scripts()
 	err := app.NewHTTWebPApp().Listen(":" + WEBPORT)
 	if err != nil {
@@ -2,6 +2,7 @@ package connection
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"sync"
@@ -12,6 +13,10 @@ import (
 var mmUserInit sync.Once
 var mmUserDb *PostgresMainDB
 
+var sandboxInit sync.Once
+var sandboxDb *PostgresMainDB
+var sandboxErr error
+
 type PostgresMainDB struct {
 	*pgxpool.Pool
 }
@@ -24,16 +29,41 @@ func InjectMainDB() *PostgresMainDB {
 	return mmUserDb
 }
 
+// InjectSandboxDB connects to the database RevenueCat sandbox events are
+// processed against. It has the same schema as the main database.
+func InjectSandboxDB() (*PostgresMainDB, error) {
+	sandboxInit.Do(func() {
+		sandboxDb, sandboxErr = newPostgres(os.Getenv("PGX_SANDBOX"))
+	})
+	return sandboxDb, sandboxErr
+}
+
 func setupMainUser() *PostgresMainDB {
-	ctx := context.Background()
-	config, err := pgxpool.ParseConfig(os.Getenv("PGX_MAIN"))
+	return setupPostgres(os.Getenv("PGX_MAIN"))
+}
+
+func setupPostgres(connString string) *PostgresMainDB {
+	db, err := newPostgres(connString)
 	if err != nil {
 		panic(err)
 	}
+	return db
+}
+
+func newPostgres(connString string) (*PostgresMainDB, error) {
+	// An empty string would connect with the libpq defaults
+	if connString == "" {
+		return nil, errors.New("postgres connection string is empty")
+	}
+	ctx := context.Background()
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
 	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	log.Println("DB CONNECTED")
-	return &PostgresMainDB{pool}
+	return &PostgresMainDB{pool}, nil
 }
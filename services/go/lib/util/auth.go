@@ -1,6 +1,9 @@
 package util
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 
@@ -32,3 +35,19 @@ func VerifyToken(tokenString string) (jwt.MapClaims, error) {
 
 	return nil, fmt.Errorf("invalid token")
 }
+
+func SignHMAC(secret []byte, message string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyHMAC(secret []byte, message string, signature string) bool {
+	return hmac.Equal([]byte(SignHMAC(secret, message)), []byte(signature))
+}
+
+// HashKey is how API keys are stored, so a leaked table does not leak keys.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -27,6 +27,10 @@ func RoundUp(val float64, precision int) float64 {
 	return math.Ceil(val*(math.Pow10(precision))) / math.Pow10(precision)
 }
 
+func Round(val float64, precision int) float64 {
+	return math.Round(val*(math.Pow10(precision))) / math.Pow10(precision)
+}
+
 func Contains[T comparable](elems []T, v T) bool {
 	for _, s := range elems {
 		if v == s {
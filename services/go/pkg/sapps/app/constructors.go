@@ -2,7 +2,15 @@ package app
 
 import (
 	"sapps/lib/connection"
+	"sapps/pkg/sapps/detector"
+	"sapps/pkg/sapps/facecheck"
+	"sapps/pkg/sapps/imagestore"
+	"sapps/pkg/sapps/imageupload"
+	"sapps/pkg/sapps/imagevariant"
 	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/quota"
+	"sapps/pkg/sapps/ratelimit"
+	"sapps/pkg/sapps/service"
 )
 
 func provideDBConnections() []interface{} {
@@ -19,10 +27,24 @@ func provideDBHandlers() []interface{} {
 	}
 }
 
+func provideServices() []interface{} {
+	return []interface{}{
+		detector.InjectAggregator,
+		quota.InjectLimits,
+		ratelimit.InjectStore,
+		facecheck.InjectChecker,
+		imagestore.InjectStore,
+		imageupload.InjectProcessor,
+		imagevariant.InjectRenderer,
+		service.InjectImageResolver,
+	}
+}
+
 func httpAppConstructors() []interface{} {
 	constructorsList := [][]interface{}{
 		provideDBConnections(),
 		provideDBHandlers(),
+		provideServices(),
 	}
 	constructors := []interface{}{}
 	for i := range constructorsList {
@@ -2,6 +2,7 @@ package app
 
 import (
 	"fmt"
+	"sapps/pkg/sapps/constant"
 	"sapps/pkg/sapps/middleware"
 	"log"
 	"net/http"
@@ -60,6 +61,8 @@ func (b *BackendApp) setupHTTPWebRoutes() {
 	authMiddleware := middleware.HandleWrapper(mustInvoke[middleware.GetAuthMiddleware]())
 	verifyAuthMiddleware := middleware.HandleWrapper(mustInvoke[middleware.VerifyAuthMiddleware]())
 	b.setupDigHTTPRoutes(authMiddleware, verifyAuthMiddleware)
+	adminAuthMiddleware := middleware.HandleWrapper(mustInvoke[middleware.AdminAuthMiddleware]())
+	b.setupDigAdminHTTPRoutes(adminAuthMiddleware)
 
 	b.Use(func(c *fiber.Ctx) error {
 		return c.SendStatus(404)
@@ -76,6 +79,10 @@ func NewHTTWebPApp() *BackendApp {
 			BodyLimit:             30 * 1024 * 1024,
 			Concurrency:           1024 * 8,
 			DisableStartupMessage: false,
+			// c.IP() reads PROXY_HEADER only on requests from TRUSTED_PROXIES
+			EnableTrustedProxyCheck: true,
+			TrustedProxies:          constant.TRUSTED_PROXIES,
+			ProxyHeader:             constant.PROXY_HEADER,
 		}),
 		HttpClient: &http.Client{},
 	}
@@ -18,7 +18,11 @@ func TestSetupHTTP(t *testing.T) {
 		provider := providers[d]
 		typeProvider := reflect.TypeOf(provider)
 		providerFunction := reflect.MakeFunc(typeProvider, func(args []reflect.Value) []reflect.Value {
-			return []reflect.Value{reflect.Zero(typeProvider.Out(0))}
+			results := make([]reflect.Value, typeProvider.NumOut())
+			for i := range results {
+				results[i] = reflect.Zero(typeProvider.Out(i))
+			}
+			return results
 		})
 		err := c.Provide(providerFunction.Interface())
 		if err != nil {
@@ -30,4 +34,5 @@ func TestSetupHTTP(t *testing.T) {
 	}
 	b.setupDigWithoutAuthHTTPRoutes()
 	b.setupDigHTTPRoutes()
+	b.setupDigAdminHTTPRoutes()
 }
@@ -2,28 +2,75 @@ package app
 
 import (
 	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/model"
+	"sapps/pkg/sapps/quota"
+	"sapps/pkg/sapps/ratelimit"
 	route "sapps/pkg/sapps/route"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+var (
+	loginRateLimit        = ratelimit.PerMinute("login", 10, 5, ratelimit.KeyIP, ratelimit.KeyDevice)
+	uploadImageRateLimit  = ratelimit.PerMinute("upload_image", 20, 10, ratelimit.KeyUser, ratelimit.KeyIP)
+	scanRateLimit         = ratelimit.PerMinute("scan", 10, 5, ratelimit.KeyUser)
+	generationRateLimit   = ratelimit.PerMinute("generation", 10, 5, ratelimit.KeyUser)
+	humanizationRateLimit = ratelimit.PerMinute("humanization", 30, 10, ratelimit.KeyUser)
+	detectionRateLimit    = ratelimit.PerMinute("detection", 30, 10, ratelimit.KeyUser)
+	shareRateLimit        = ratelimit.PerMinute("share", 60, 30, ratelimit.KeyIP)
+)
+
 func (b *BackendApp) setupDigWithoutAuthHTTPRoutes() {
+	rateLimits := mustInvoke[middleware.RateLimitMiddleware]()
 	b.Post("/webhook/revenuecat/face", middleware.HandleWrapper(mustInvoke[route.PostRevenuecatWebhook]()))
 	b.Post("/webhook/kie/callback", middleware.HandleWrapper(mustInvoke[route.PostGenerativeAICallback]()))
 
 	b.Get("/cdn/img/:id", middleware.HandleWrapper(mustInvoke[route.GetCDNImage]()))
-	b.Post("/login/firebase", middleware.HandleWrapper(mustInvoke[route.PostLoginFirebase]()))
+	b.Get("/share/:token", middleware.HandleWrapper(rateLimits.For(shareRateLimit)), middleware.HandleWrapper(mustInvoke[route.GetShare]()))
+	b.Get("/share/:token/card.png", middleware.HandleWrapper(rateLimits.For(shareRateLimit)), middleware.HandleWrapper(mustInvoke[route.GetShareCard]()))
+	b.Post("/login/firebase", middleware.HandleWrapper(rateLimits.For(loginRateLimit)), middleware.HandleWrapper(mustInvoke[route.PostLoginFirebase]()))
 }
 
 func (b *BackendApp) setupDigHTTPRoutes(middlewares ...fiber.Handler) {
+	quotas := mustInvoke[middleware.QuotaMiddleware]()
+	rateLimits := mustInvoke[middleware.RateLimitMiddleware]()
 	b.Get("/users/account", append(middlewares, middleware.HandleWrapper(mustInvoke[route.GetAccount]()))...)
 	b.Patch("/users/account", append(middlewares, middleware.HandleWrapper(mustInvoke[route.PatchAccount]()))...)
-	b.Post("/upload-image", append(middlewares, middleware.HandleWrapper(mustInvoke[route.PostUploadImage]()))...)
-	b.Post("/scans", append(middlewares, middleware.HandleWrapper(mustInvoke[route.PostScan]()))...)
+	b.Get("/users/coins/history", append(middlewares, middleware.HandleWrapper(mustInvoke[route.GetCoinHistory]()))...)
+	b.Post("/upload-image", append(middlewares, middleware.HandleWrapper(rateLimits.For(uploadImageRateLimit)), middleware.HandleWrapper(mustInvoke[route.PostUploadImage]()))...)
+	b.Post("/scans", append(middlewares, middleware.HandleWrapper(rateLimits.For(scanRateLimit)), middleware.HandleWrapper(quotas.For(quota.FeatureScan)), middleware.HandleWrapper(mustInvoke[route.PostScan]()))...)
 	b.Get("/scans", append(middlewares, middleware.HandleWrapper(mustInvoke[route.GetScans]()))...)
+	b.Get("/scans/progress", append(middlewares, middleware.HandleWrapper(mustInvoke[route.GetScansProgress]()))...)
+	b.Get("/scans/compare", append(middlewares, middleware.HandleWrapper(mustInvoke[route.GetScansCompare]()))...)
 	b.Get("/scans/:id", append(middlewares, middleware.HandleWrapper(mustInvoke[route.GetScan]()))...)
-	b.Post("/generative-ai", append(middlewares, middleware.HandleWrapper(mustInvoke[route.PostGenerativeAI]()))...)
+	b.Delete("/scans/:id", append(middlewares, middleware.HandleWrapper(mustInvoke[route.DeleteScan]()))...)
+	b.Post("/scans/:id/share", append(middlewares, middleware.HandleWrapper(mustInvoke[route.PostScanShare]()))...)
+	b.Post("/generative-ai", append(middlewares, middleware.HandleWrapper(rateLimits.For(generationRateLimit)), middleware.HandleWrapper(quotas.For(quota.FeatureGeneration)), middleware.HandleWrapper(mustInvoke[route.PostGenerativeAI]()))...)
 	b.Get("/generative-ai/:id", append(middlewares, middleware.HandleWrapper(mustInvoke[route.GetGenerativeAI]()))...)
 	b.Get("/generations", append(middlewares, middleware.HandleWrapper(mustInvoke[route.GetGenerativeAIList]()))...)
 	b.Delete("/generations/:id", append(middlewares, middleware.HandleWrapper(mustInvoke[route.DeleteGenerativeAI]()))...)
+	b.Post("/humanizations", append(middlewares, middleware.HandleWrapper(rateLimits.For(humanizationRateLimit)), middleware.HandleWrapper(mustInvoke[route.PostHumanization]()))...)
+	b.Get("/humanizations", append(middlewares, middleware.HandleWrapper(mustInvoke[route.GetHumanizations]()))...)
+	b.Post("/detections", append(middlewares, middleware.HandleWrapper(rateLimits.For(detectionRateLimit)), middleware.HandleWrapper(mustInvoke[route.PostDetection]()))...)
+}
+
+func (b *BackendApp) setupDigAdminHTTPRoutes(middlewares ...fiber.Handler) {
+	viewer := append(middlewares, middleware.HandleWrapper(&middleware.AdminRoleMiddleware{Role: model.AdminRoleViewer}))
+	support := append(middlewares, middleware.HandleWrapper(&middleware.AdminRoleMiddleware{Role: model.AdminRoleSupport}))
+	owner := append(middlewares, middleware.HandleWrapper(&middleware.AdminRoleMiddleware{Role: model.AdminRoleOwner}))
+
+	b.Get("/admin/analytics/revenue", append(viewer, middleware.HandleWrapper(mustInvoke[route.GetAdminRevenue]()))...)
+	b.Get("/admin/analytics/costs", append(viewer, middleware.HandleWrapper(mustInvoke[route.GetAdminCosts]()))...)
+	b.Get("/admin/users", append(viewer, middleware.HandleWrapper(mustInvoke[route.GetAdminUser]()))...)
+	b.Get("/admin/users/:id/scans", append(viewer, middleware.HandleWrapper(mustInvoke[route.GetAdminUserScans]()))...)
+	b.Get("/admin/users/:id/generations", append(viewer, middleware.HandleWrapper(mustInvoke[route.GetAdminUserGenerations]()))...)
+	b.Get("/admin/users/:id/revenuecat", append(viewer, middleware.HandleWrapper(mustInvoke[route.GetAdminUserRevenueCat]()))...)
+	b.Post("/admin/users/:id/premium", append(support, middleware.HandleWrapper(mustInvoke[route.PostAdminUserPremium]()))...)
+	b.Delete("/admin/users/:id/premium", append(support, middleware.HandleWrapper(mustInvoke[route.DeleteAdminUserPremium]()))...)
+	b.Post("/admin/users/:id/coins/reset", append(support, middleware.HandleWrapper(mustInvoke[route.PostAdminUserCoinsReset]()))...)
+	b.Patch("/admin/users/:id/debug", append(support, middleware.HandleWrapper(mustInvoke[route.PatchAdminUserDebug]()))...)
+	b.Put("/admin/users/:id/quotas/:feature", append(support, middleware.HandleWrapper(mustInvoke[route.PutAdminUserQuota]()))...)
+	b.Delete("/admin/users/:id/quotas/:feature", append(support, middleware.HandleWrapper(mustInvoke[route.DeleteAdminUserQuota]()))...)
+	b.Post("/admin/admins", append(owner, middleware.HandleWrapper(mustInvoke[route.PostAdmin]()))...)
+	b.Get("/admin/audit-logs", append(owner, middleware.HandleWrapper(mustInvoke[route.GetAdminAuditLogs]()))...)
 }
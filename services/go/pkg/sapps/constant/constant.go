@@ -1,9 +1,12 @@
 package constant
 
 import (
+	"fmt"
 	"math/rand"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -11,8 +14,217 @@ var (
 	API_URL      = "https://sapps.cactusordering.com"
 	KIA_API_KEYS = strings.Split(os.Getenv("KIA_API_KEYS"), ",")
 	WD_PATH      = os.Getenv("WD_PATH")
+
+	GPTZERO_API_KEY         = os.Getenv("GPTZERO_API_KEY")
+	SAPLING_API_KEY         = os.Getenv("SAPLING_API_KEY")
+	ZEROGPT_API_KEY         = os.Getenv("ZEROGPT_API_KEY")
+	COPYLEAKS_API_KEY       = os.Getenv("COPYLEAKS_API_KEY")
+	WRITER_API_KEY          = os.Getenv("WRITER_API_KEY")
+	WRITER_ORGANIZATION_ID  = os.Getenv("WRITER_ORGANIZATION_ID")
+	GRAMMARLY_CLIENT_ID     = os.Getenv("GRAMMARLY_CLIENT_ID")
+	GRAMMARLY_CLIENT_SECRET = os.Getenv("GRAMMARLY_CLIENT_SECRET")
+	// QuillBot's detector is only reachable through a partner endpoint
+	QUILLBOT_API_URL = os.Getenv("QUILLBOT_API_URL")
+	QUILLBOT_API_KEY = os.Getenv("QUILLBOT_API_KEY")
+
+	// Submitted kie tasks without a callback are polled after this long
+	KIE_RECONCILE_AFTER = durationEnv("KIE_RECONCILE_AFTER", 10*time.Minute)
+	// and marked timed_out when still unfinished after this long
+	KIE_TASK_TIMEOUT = durationEnv("KIE_TASK_TIMEOUT", 2*time.Hour)
+	// Signs the per task token in the kie callback URL
+	KIE_CALLBACK_SECRET = secretEnv("KIE_CALLBACK_SECRET")
+	// Hosts kie results may be downloaded from, a leading dot matches subdomains
+	KIE_RESULT_HOSTS = listEnv("KIE_RESULT_HOSTS", []string{".aiquickdraw.com", ".redpandaai.co"})
+
+	// Value RevenueCat sends in the Authorization header of webhooks
+	REVENUECAT_WEBHOOK_AUTH = os.Getenv("REVENUECAT_WEBHOOK_AUTH")
+	// RevenueCat events older than this are rejected as replays
+	REVENUECAT_MAX_EVENT_AGE = durationEnv("REVENUECAT_MAX_EVENT_AGE", 12*time.Hour)
+	// How SANDBOX RevenueCat events are processed, one of the SandboxMode values
+	REVENUECAT_SANDBOX_MODE = stringEnv("REVENUECAT_SANDBOX_MODE", SandboxModeDebug)
+	// Database of SandboxModeDatabase, required in that mode
+	PGX_SANDBOX = os.Getenv("PGX_SANDBOX")
+
+	// Shared key operators send in the admin-key header
+	ADMIN_API_KEY = os.Getenv("ADMIN_API_KEY")
+
+	// USD per million OpenAI tokens, defaults are the gpt-5 prices
+	OPENAI_INPUT_COST_PER_MTOK  = floatEnv("OPENAI_INPUT_COST_PER_MTOK", 1.25)
+	OPENAI_OUTPUT_COST_PER_MTOK = floatEnv("OPENAI_OUTPUT_COST_PER_MTOK", 10)
+	// USD per kie credit
+	KIE_CREDIT_COST = floatEnv("KIE_CREDIT_COST", 0.005)
+
+	// Uses allowed per hour and day by feature and tier, see quota.Parse
+	QUOTA_LIMITS = stringEnv("QUOTA_LIMITS", "scan:free=5/h,20/d;scan:premium=30/h,150/d;generation:free=5/h,20/d;generation:premium=20/h,100/d")
+	// A user whose AI cost in a day reaches this many USD raises an alert
+	COST_ALERT_THRESHOLD = floatEnv("COST_ALERT_THRESHOLD", 5)
+	// Slack compatible webhook cost alerts are posted to, optional
+	COST_ALERT_WEBHOOK_URL = os.Getenv("COST_ALERT_WEBHOOK_URL")
+	// memory limits each instance on its own, postgres shares limits between instances
+	RATE_LIMIT_BACKEND = stringEnv("RATE_LIMIT_BACKEND", "memory")
+	// Proxies whose PROXY_HEADER is trusted as the client IP, the Cloudflare
+	// ranges by default. Requests from other addresses are keyed by their own IP
+	TRUSTED_PROXIES = listEnv("TRUSTED_PROXIES", cloudflareRanges)
+	PROXY_HEADER    = stringEnv("PROXY_HEADER", "CF-Connecting-IP")
+
+	// vision uses Google Cloud Vision, none skips the face check before scans
+	// and has to be chosen explicitly
+	FACE_DETECTOR = stringEnv("FACE_DETECTOR", "vision")
+	// Service account for Cloud Vision, the Firebase one by default
+	VISION_CREDENTIALS_PATH = stringEnv("VISION_CREDENTIALS_PATH", os.Getenv("FCM_CREDENTIALS_PATH"))
+	// Scanned images need at least this many pixels on their shorter side
+	IMAGE_MIN_SIDE = intEnv("IMAGE_MIN_SIDE", 256)
+	// Scanned images below this variance of the Laplacian are too blurry
+	IMAGE_MIN_SHARPNESS = floatEnv("IMAGE_MIN_SHARPNESS", 40)
+	// Uploads with more pixels are rejected before they are decoded
+	IMAGE_MAX_PIXELS = intEnv("IMAGE_MAX_PIXELS", 50_000_000)
+	// Uploads are downscaled to at most this many pixels on their longer side
+	IMAGE_MAX_SIDE = intEnv("IMAGE_MAX_SIDE", 2048)
+	// heif-convert binary from libheif, HEIC uploads are rejected when unset
+	HEIF_CONVERT_PATH = os.Getenv("HEIF_CONVERT_PATH")
+
+	// local keeps images in WD_PATH/cdn/img, s3 in a bucket every instance
+	// shares, memory is for tests only
+	IMAGE_STORE          = stringEnv("IMAGE_STORE", "local")
+	S3_ENDPOINT          = os.Getenv("S3_ENDPOINT")
+	S3_REGION            = stringEnv("S3_REGION", "us-east-1")
+	S3_BUCKET            = os.Getenv("S3_BUCKET")
+	S3_ACCESS_KEY_ID     = os.Getenv("S3_ACCESS_KEY_ID")
+	S3_SECRET_ACCESS_KEY = os.Getenv("S3_SECRET_ACCESS_KEY")
+
+	// Signs the /cdn/img URLs handed to owners, OpenAI and kie
+	CDN_URL_SECRET = secretEnv("CDN_URL_SECRET")
+	// Signed /cdn/img URLs stop working after this long
+	CDN_URL_TTL = durationEnv("CDN_URL_TTL", time.Hour)
+	// Resized and converted /cdn/img variants are cached here, on each instance
+	IMAGE_VARIANT_CACHE_PATH = stringEnv("IMAGE_VARIANT_CACHE_PATH", WD_PATH+"/cache/img")
+	// Cached variants not requested for this long are removed
+	IMAGE_VARIANT_CACHE_TTL = durationEnv("IMAGE_VARIANT_CACHE_TTL", 7*24*time.Hour)
+	// cwebp and avifenc binaries, WebP and AVIF are only served when set
+	CWEBP_PATH   = os.Getenv("CWEBP_PATH")
+	AVIFENC_PATH = os.Getenv("AVIFENC_PATH")
+)
+
+const (
+	// Sandbox events grant entitlements only to users flagged debug
+	SandboxModeDebug = "debug"
+	// Sandbox events are logged without changing entitlements
+	SandboxModeIgnore = "ignore"
+	// Sandbox events are processed against the PGX_SANDBOX database
+	SandboxModeDatabase = "database"
 )
 
+func stringEnv(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// secretEnv returns the HMAC key in name, Validate refuses to start without
+// it since anyone could sign with an empty or derived key.
+func secretEnv(name string) []byte {
+	return []byte(os.Getenv(name))
+}
+
+// cloudflareRanges are the addresses Cloudflare connects from, see
+// https://www.cloudflare.com/ips/
+var cloudflareRanges = []string{
+	"173.245.48.0/20",
+	"103.21.244.0/22",
+	"103.22.200.0/22",
+	"103.31.4.0/22",
+	"141.101.64.0/18",
+	"108.162.192.0/18",
+	"190.93.240.0/20",
+	"188.114.96.0/20",
+	"197.234.240.0/22",
+	"198.41.128.0/17",
+	"162.158.0.0/15",
+	"104.16.0.0/13",
+	"104.24.0.0/14",
+	"172.64.0.0/13",
+	"131.0.72.0/22",
+	"2400:cb00::/32",
+	"2606:4700::/32",
+	"2803:f800::/32",
+	"2405:b500::/32",
+	"2405:8100::/32",
+	"2a06:98c0::/29",
+	"2c0f:f248::/32",
+}
+
+// Validate reports the configuration the API cannot safely start without.
+func Validate() error {
+	var missing []string
+	for _, secret := range []struct {
+		name  string
+		value []byte
+	}{
+		{"KIE_CALLBACK_SECRET", KIE_CALLBACK_SECRET},
+		{"CDN_URL_SECRET", CDN_URL_SECRET},
+	} {
+		if len(secret.value) == 0 {
+			missing = append(missing, secret.name)
+		}
+	}
+	if REVENUECAT_SANDBOX_MODE == SandboxModeDatabase && PGX_SANDBOX == "" {
+		missing = append(missing, "PGX_SANDBOX")
+	}
+	// Without trusted proxies every request arrives from the proxy, all
+	// clients would share one rate limit bucket
+	if PROXY_HEADER != "" && len(TRUSTED_PROXIES) == 0 {
+		missing = append(missing, "TRUSTED_PROXIES")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+	switch REVENUECAT_SANDBOX_MODE {
+	case SandboxModeDebug, SandboxModeIgnore, SandboxModeDatabase:
+	default:
+		return fmt.Errorf("unknown REVENUECAT_SANDBOX_MODE %q", REVENUECAT_SANDBOX_MODE)
+	}
+	return nil
+}
+
+func listEnv(name string, defaultValue []string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func intEnv(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func floatEnv(name string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func durationEnv(name string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
 func GetKiaAPIKey() string {
 	return KIA_API_KEYS[rand.Intn(len(KIA_API_KEYS))]
 }
@@ -0,0 +1,222 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sapps/lib/util"
+	"sapps/pkg/sapps/constant"
+	"sapps/pkg/sapps/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProviderTimeout = 20 * time.Second
+	// Sentences at or above this probability are counted as AI written
+	aiSentenceThreshold = 0.5
+)
+
+var ErrNoDetectors = errors.New("no detection providers configured")
+
+// Aggregator fans a text out to every configured Detector and merges their
+// answers into a single model.DetectionResponse.
+type Aggregator struct {
+	detectors []Detector
+	timeout   time.Duration
+}
+
+func NewAggregator(timeout time.Duration, detectors ...Detector) *Aggregator {
+	return &Aggregator{
+		detectors: detectors,
+		timeout:   timeout,
+	}
+}
+
+// InjectAggregator enables every provider that has credentials in the
+// environment.
+func InjectAggregator() *Aggregator {
+	var detectors []Detector
+	if constant.GPTZERO_API_KEY != "" {
+		detectors = append(detectors, NewGPTZero(constant.GPTZERO_API_KEY))
+	}
+	if constant.SAPLING_API_KEY != "" {
+		detectors = append(detectors, NewSapling(constant.SAPLING_API_KEY))
+	}
+	if constant.ZEROGPT_API_KEY != "" {
+		detectors = append(detectors, NewZeroGPT(constant.ZEROGPT_API_KEY))
+	}
+	if constant.COPYLEAKS_API_KEY != "" {
+		detectors = append(detectors, NewCopyleaks(constant.COPYLEAKS_API_KEY))
+	}
+	if constant.WRITER_API_KEY != "" && constant.WRITER_ORGANIZATION_ID != "" {
+		detectors = append(detectors, NewWriter(constant.WRITER_API_KEY, constant.WRITER_ORGANIZATION_ID))
+	}
+	if constant.GRAMMARLY_CLIENT_ID != "" && constant.GRAMMARLY_CLIENT_SECRET != "" {
+		detectors = append(detectors, NewGrammarly(constant.GRAMMARLY_CLIENT_ID, constant.GRAMMARLY_CLIENT_SECRET))
+	}
+	if constant.QUILLBOT_API_URL != "" && constant.QUILLBOT_API_KEY != "" {
+		detectors = append(detectors, NewQuillBot(constant.QUILLBOT_API_URL, constant.QUILLBOT_API_KEY))
+	}
+	return NewAggregator(defaultProviderTimeout, detectors...)
+}
+
+func (a *Aggregator) Enabled() bool {
+	return a != nil && len(a.detectors) > 0
+}
+
+type providerResult struct {
+	name   string
+	result *Result
+	err    error
+}
+
+// Detect runs all providers concurrently, each bounded by the aggregator
+// timeout. Failing providers are logged, left out of the merge and reported
+// with an error in ServiceResults; an error is returned only when no provider
+// answered.
+func (a *Aggregator) Detect(ctx context.Context, text string) (*model.DetectionResponse, error) {
+	if !a.Enabled() {
+		return nil, ErrNoDetectors
+	}
+
+	results := make([]providerResult, len(a.detectors))
+	var wg sync.WaitGroup
+	for i, d := range a.detectors {
+		wg.Add(1)
+		go func(i int, d Detector) {
+			defer wg.Done()
+			providerCtx, cancel := context.WithTimeout(ctx, a.timeout)
+			defer cancel()
+			result, err := d.Detect(providerCtx, text)
+			results[i] = providerResult{name: d.Name(), result: result, err: err}
+		}(i, d)
+	}
+	wg.Wait()
+
+	var errs []error
+	succeeded := []providerResult{}
+	var serviceResults model.ServiceResults
+	for _, r := range results {
+		if r.err != nil || r.result == nil {
+			err := r.err
+			if err == nil {
+				err = errors.New("empty result")
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, err))
+			setServiceResult(&serviceResults, r.name, &model.ServiceResult{Error: serviceError(err)})
+			continue
+		}
+		succeeded = append(succeeded, r)
+		probability := r.result.AIProbability
+		setServiceResult(&serviceResults, r.name, &model.ServiceResult{AIProbability: &probability})
+	}
+	for _, err := range errs {
+		util.LogErr(err)
+	}
+	if len(succeeded) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	response := merge(succeeded)
+	response.ServiceResults = serviceResults
+	return &response, nil
+}
+
+// serviceError is the error clients see for a failed provider, the provider's
+// own message stays in the logs.
+func serviceError(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "failed"
+}
+
+func merge(results []providerResult) model.DetectionResponse {
+	var response model.DetectionResponse
+	var total float64
+	for _, r := range results {
+		total += r.result.AIProbability
+	}
+	response.AIProbability = total / float64(len(results))
+
+	// Confidence is the share of providers that agree with the overall verdict
+	isAI := response.AIProbability >= aiSentenceThreshold
+	agreeing := 0
+	for _, r := range results {
+		if (r.result.AIProbability >= aiSentenceThreshold) == isAI {
+			agreeing++
+		}
+	}
+	response.Breakdown.Confidence = float64(agreeing) / float64(len(results))
+
+	response.Breakdown.SentenceAnalysis = mergeSentences(results)
+	for _, sentence := range response.Breakdown.SentenceAnalysis {
+		if sentence.AIProbability >= aiSentenceThreshold {
+			response.Breakdown.AISentences++
+		} else {
+			response.Breakdown.HumanSentences++
+		}
+	}
+	return response
+}
+
+// mergeSentences averages the sentence level probabilities of providers that
+// return them, keyed by whitespace normalized text and kept in the order they
+// first appear.
+func mergeSentences(results []providerResult) []model.SentenceAnalysis {
+	type entry struct {
+		text  string
+		total float64
+		count int
+	}
+	entries := map[string]*entry{}
+	order := []string{}
+	for _, r := range results {
+		for _, sentence := range r.result.Sentences {
+			text := strings.Join(strings.Fields(sentence.Text), " ")
+			if text == "" {
+				continue
+			}
+			e, ok := entries[text]
+			if !ok {
+				e = &entry{text: text}
+				entries[text] = e
+				order = append(order, text)
+			}
+			e.total += sentence.AIProbability
+			e.count++
+		}
+	}
+
+	sentences := []model.SentenceAnalysis{}
+	for _, text := range order {
+		e := entries[text]
+		sentences = append(sentences, model.SentenceAnalysis{
+			Text:          e.text,
+			AIProbability: math.Round(e.total/float64(e.count)*1000) / 1000,
+		})
+	}
+	return sentences
+}
+
+func setServiceResult(serviceResults *model.ServiceResults, name string, result *model.ServiceResult) {
+	switch name {
+	case GPTZeroName:
+		serviceResults.GPTZero = result
+	case WriterName:
+		serviceResults.Writer = result
+	case QuillBotName:
+		serviceResults.QuillBot = result
+	case CopyleaksName:
+		serviceResults.Copyleaks = result
+	case SaplingName:
+		serviceResults.Sapling = result
+	case GrammarlyName:
+		serviceResults.Grammarly = result
+	case ZeroGPTName:
+		serviceResults.ZeroGPT = result
+	}
+}
@@ -0,0 +1,82 @@
+package detector
+
+import (
+	"context"
+	"net/http"
+	"sapps/pkg/sapps/model"
+
+	"github.com/google/uuid"
+)
+
+const copyleaksAIClassification = 2
+
+type Copyleaks struct {
+	BaseURL string
+	// Bearer token obtained from the Copyleaks login endpoint
+	APIKey string
+	Client *http.Client
+}
+
+func NewCopyleaks(apiKey string) *Copyleaks {
+	return &Copyleaks{
+		BaseURL: "https://api.copyleaks.com",
+		APIKey:  apiKey,
+	}
+}
+
+type copyleaksResponse struct {
+	Summary struct {
+		Human float64 `json:"human"`
+		AI    float64 `json:"ai"`
+	} `json:"summary"`
+	Results []struct {
+		Classification int     `json:"classification"`
+		Probability    float64 `json:"probability"`
+		Matches        []struct {
+			Text struct {
+				Chars struct {
+					Starts  []int `json:"starts"`
+					Lengths []int `json:"lengths"`
+				} `json:"chars"`
+			} `json:"text"`
+		} `json:"matches"`
+	} `json:"results"`
+}
+
+func (d *Copyleaks) Name() string {
+	return CopyleaksName
+}
+
+func (d *Copyleaks) Detect(ctx context.Context, text string) (*Result, error) {
+	var resp copyleaksResponse
+	err := postJSON(ctx, d.Client, d.BaseURL+"/v2/writer-detector/"+uuid.New().String()+"/check",
+		map[string]string{"Authorization": "Bearer " + d.APIKey},
+		map[string]any{"text": text, "sandbox": false},
+		&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{AIProbability: clamp(resp.Summary.AI)}
+	runes := []rune(text)
+	for _, section := range resp.Results {
+		probability := section.Probability
+		if section.Classification != copyleaksAIClassification {
+			probability = 1 - probability
+		}
+		for _, match := range section.Matches {
+			chars := match.Text.Chars
+			for i := 0; i < len(chars.Starts) && i < len(chars.Lengths); i++ {
+				start, end := chars.Starts[i], chars.Starts[i]+chars.Lengths[i]
+				if start < 0 || end > len(runes) || start >= end {
+					continue
+				}
+				result.Sentences = append(result.Sentences, model.SentenceAnalysis{
+					Text:          string(runes[start:end]),
+					AIProbability: clamp(probability),
+				})
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package detector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sapps/pkg/sapps/model"
+	"time"
+)
+
+// Service names, matching the keys of model.ServiceResults.
+const (
+	GPTZeroName   = "GPTZero"
+	WriterName    = "Writer"
+	QuillBotName  = "QuillBot"
+	CopyleaksName = "Copyleaks"
+	SaplingName   = "Sapling"
+	GrammarlyName = "Grammarly"
+	ZeroGPTName   = "ZeroGPT"
+)
+
+// Result is the normalized output of a single provider. Probabilities are in
+// the range 0.0 (human) to 1.0 (AI).
+type Result struct {
+	AIProbability float64
+	Sentences     []model.SentenceAnalysis
+}
+
+type Detector interface {
+	Name() string
+	Detect(ctx context.Context, text string) (*Result, error)
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 60 * time.Second}
+
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body any, out any) error {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return doJSON(client, httpReq, headers, out)
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, out any) error {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(client, httpReq, headers, out)
+}
+
+// doJSON sends the request and decodes a 200 response into out, unless out
+// is nil.
+func doJSON(client *http.Client, httpReq *http.Request, headers map[string]string, out any) error {
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(respBody) > 200 {
+			respBody = respBody[:200]
+		}
+		return fmt.Errorf("status %d: %s", resp.StatusCode, respBody)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+func clamp(probability float64) float64 {
+	if probability < 0 {
+		return 0
+	}
+	if probability > 1 {
+		return 1
+	}
+	return probability
+}
@@ -0,0 +1,345 @@
+package detector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sapps/pkg/sapps/model"
+	"testing"
+	"time"
+)
+
+func stubServer(t *testing.T, path string, check func(r *http.Request, body map[string]any), response string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			t.Errorf("unexpected path %s, want %s", r.URL.Path, path)
+		}
+		body := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		if check != nil {
+			check(r, body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAdapters(t *testing.T) {
+	text := "First sentence. Second sentence."
+	tests := []struct {
+		name          string
+		path          string
+		response      string
+		newDetector   func(baseURL string) Detector
+		check         func(t *testing.T, r *http.Request, body map[string]any)
+		wantAI        float64
+		wantSentences int
+	}{
+		{
+			name:     GPTZeroName,
+			path:     "/v2/predict/text",
+			response: `{"documents":[{"completely_generated_prob":0.9,"sentences":[{"sentence":"First sentence.","generated_prob":0.8},{"sentence":"Second sentence.","generated_prob":1}]}]}`,
+			newDetector: func(baseURL string) Detector {
+				d := NewGPTZero("key")
+				d.BaseURL = baseURL
+				return d
+			},
+			check: func(t *testing.T, r *http.Request, body map[string]any) {
+				if r.Header.Get("x-api-key") != "key" || body["document"] != text {
+					t.Errorf("unexpected request %v %v", r.Header, body)
+				}
+			},
+			wantAI:        0.9,
+			wantSentences: 2,
+		},
+		{
+			name:     SaplingName,
+			path:     "/api/v1/aidetect",
+			response: `{"score":0.7,"sentence_scores":[{"sentence":"First sentence.","score":0.6}]}`,
+			newDetector: func(baseURL string) Detector {
+				d := NewSapling("key")
+				d.BaseURL = baseURL
+				return d
+			},
+			check: func(t *testing.T, r *http.Request, body map[string]any) {
+				if body["key"] != "key" || body["text"] != text {
+					t.Errorf("unexpected body %v", body)
+				}
+			},
+			wantAI:        0.7,
+			wantSentences: 1,
+		},
+		{
+			name:     ZeroGPTName,
+			path:     "/api/detect/detectText",
+			response: `{"success":true,"data":{"fakePercentage":45,"h":["Second sentence."]}}`,
+			newDetector: func(baseURL string) Detector {
+				d := NewZeroGPT("key")
+				d.BaseURL = baseURL
+				return d
+			},
+			check: func(t *testing.T, r *http.Request, body map[string]any) {
+				if r.Header.Get("ApiKey") != "key" || body["input_text"] != text {
+					t.Errorf("unexpected request %v %v", r.Header, body)
+				}
+			},
+			wantAI:        0.45,
+			wantSentences: 1,
+		},
+		{
+			name:     WriterName,
+			path:     "/content/organization/org/detect",
+			response: `[{"label":"real","score":0.2},{"label":"fake","score":0.8}]`,
+			newDetector: func(baseURL string) Detector {
+				d := NewWriter("key", "org")
+				d.BaseURL = baseURL
+				return d
+			},
+			check: func(t *testing.T, r *http.Request, body map[string]any) {
+				if r.Header.Get("Authorization") != "Bearer key" || body["input"] != text {
+					t.Errorf("unexpected request %v %v", r.Header, body)
+				}
+			},
+			wantAI: 0.8,
+		},
+		{
+			name:     QuillBotName,
+			path:     "/ai-detector",
+			response: `{"ai_probability":0.65,"sentences":[{"text":"First sentence.","ai_probability":0.9},{"text":"Second sentence.","ai_probability":0.4}]}`,
+			newDetector: func(baseURL string) Detector {
+				return NewQuillBot(baseURL, "key")
+			},
+			check: func(t *testing.T, r *http.Request, body map[string]any) {
+				if r.Header.Get("Authorization") != "Bearer key" || body["text"] != text {
+					t.Errorf("unexpected request %v %v", r.Header, body)
+				}
+			},
+			wantAI:        0.65,
+			wantSentences: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := stubServer(t, tt.path, func(r *http.Request, body map[string]any) {
+				tt.check(t, r, body)
+			}, tt.response)
+			d := tt.newDetector(server.URL)
+			if d.Name() != tt.name {
+				t.Fatalf("name = %s, want %s", d.Name(), tt.name)
+			}
+			result, err := d.Detect(context.Background(), text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.AIProbability != tt.wantAI {
+				t.Errorf("ai probability = %v, want %v", result.AIProbability, tt.wantAI)
+			}
+			if len(result.Sentences) != tt.wantSentences {
+				t.Errorf("sentences = %d, want %d", len(result.Sentences), tt.wantSentences)
+			}
+		})
+	}
+}
+
+func TestGrammarly(t *testing.T) {
+	text := "First sentence. Second sentence."
+	var uploaded string
+	polls := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v4/api/oauth2/token":
+			if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_id") != "id" || r.FormValue("client_secret") != "secret" {
+				t.Errorf("unexpected token request %v", r.Form)
+			}
+			_, _ = w.Write([]byte(`{"access_token":"token"}`))
+		case r.Method == "POST" && r.URL.Path == grammarlyDetectionPath:
+			if r.Header.Get("Authorization") != "Bearer token" {
+				t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+			}
+			_, _ = w.Write([]byte(`{"score_request_id":"req-1","file_upload_url":"` + server.URL + `/upload/req-1"}`))
+		case r.Method == "PUT" && r.URL.Path == "/upload/req-1":
+			body, _ := io.ReadAll(r.Body)
+			uploaded = string(body)
+		case r.Method == "GET" && r.URL.Path == grammarlyDetectionPath+"/req-1":
+			polls++
+			if polls == 1 {
+				_, _ = w.Write([]byte(`{"status":"PENDING"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"status":"COMPLETED","score":{"ai_generated_percentage":72}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	d := NewGrammarly("id", "secret")
+	d.BaseURL = server.URL
+	d.AuthURL = server.URL
+	d.PollInterval = time.Millisecond
+	result, err := d.Detect(context.Background(), text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AIProbability != 0.72 {
+		t.Errorf("ai probability = %v, want 0.72", result.AIProbability)
+	}
+	if uploaded != text || polls != 2 {
+		t.Errorf("uploaded %q after %d polls", uploaded, polls)
+	}
+}
+
+func TestCopyleaksSentenceOffsets(t *testing.T) {
+	text := "Human bit. AI bit."
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"summary":{"human":0.4,"ai":0.6},"results":[
+			{"classification":1,"probability":0.9,"matches":[{"text":{"chars":{"starts":[0],"lengths":[10]}}}]},
+			{"classification":2,"probability":0.8,"matches":[{"text":{"chars":{"starts":[11,50],"lengths":[7,3]}}}]}
+		]}`))
+	}))
+	defer server.Close()
+
+	d := NewCopyleaks("key")
+	d.BaseURL = server.URL
+	result, err := d.Detect(context.Background(), text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AIProbability != 0.6 {
+		t.Errorf("ai probability = %v, want 0.6", result.AIProbability)
+	}
+	if len(result.Sentences) != 2 {
+		t.Fatalf("sentences = %d, want 2", len(result.Sentences))
+	}
+	if result.Sentences[0].Text != "Human bit." || result.Sentences[0].AIProbability > 0.11 {
+		t.Errorf("unexpected human sentence %+v", result.Sentences[0])
+	}
+	if result.Sentences[1].Text != "AI bit." || result.Sentences[1].AIProbability != 0.8 {
+		t.Errorf("unexpected ai sentence %+v", result.Sentences[1])
+	}
+}
+
+func TestAdapterHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	d := NewSapling("key")
+	d.BaseURL = server.URL
+	if _, err := d.Detect(context.Background(), "text"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+type fakeDetector struct {
+	name   string
+	result *Result
+	err    error
+	delay  time.Duration
+}
+
+func (d *fakeDetector) Name() string {
+	return d.name
+}
+
+func (d *fakeDetector) Detect(ctx context.Context, text string) (*Result, error) {
+	select {
+	case <-time.After(d.delay):
+		return d.result, d.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestAggregatorMerge(t *testing.T) {
+	aggregator := NewAggregator(50*time.Millisecond,
+		&fakeDetector{name: GPTZeroName, result: &Result{AIProbability: 0.9, Sentences: sentences("One.", 0.8, "Two  sentence.", 0.2)}},
+		&fakeDetector{name: SaplingName, result: &Result{AIProbability: 0.7, Sentences: sentences("One.", 1, "Two sentence.", 0.4, "Three.", 0.9)}},
+		&fakeDetector{name: ZeroGPTName, result: &Result{AIProbability: 0.2}},
+		&fakeDetector{name: CopyleaksName, err: errors.New("boom")},
+		&fakeDetector{name: WriterName, result: &Result{AIProbability: 0}, delay: time.Second},
+	)
+
+	response, err := aggregator.Detect(context.Background(), "One. Two sentence. Three.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.AIProbability; got < 0.599 || got > 0.601 {
+		t.Errorf("ai probability = %v, want 0.6", got)
+	}
+	results := response.ServiceResults
+	for name, want := range map[string]float64{"GPTZero": 0.9, "Sapling": 0.7, "ZeroGPT": 0.2} {
+		got := map[string]*model.ServiceResult{"GPTZero": results.GPTZero, "Sapling": results.Sapling, "ZeroGPT": results.ZeroGPT}[name]
+		if got == nil || got.AIProbability == nil || *got.AIProbability != want || got.Error != "" {
+			t.Errorf("%s = %+v, want %v", name, got, want)
+		}
+	}
+	if results.Copyleaks == nil || results.Copyleaks.AIProbability != nil || results.Copyleaks.Error != "failed" {
+		t.Errorf("Copyleaks = %+v, want a failed entry", results.Copyleaks)
+	}
+	if results.Writer == nil || results.Writer.AIProbability != nil || results.Writer.Error != "timeout" {
+		t.Errorf("Writer = %+v, want a timeout entry", results.Writer)
+	}
+	if results.QuillBot != nil || results.Grammarly != nil {
+		t.Errorf("unconfigured providers must be omitted %+v", results)
+	}
+
+	breakdown := response.Breakdown
+	if len(breakdown.SentenceAnalysis) != 3 {
+		t.Fatalf("sentences = %+v, want 3", breakdown.SentenceAnalysis)
+	}
+	want := []struct {
+		text        string
+		probability float64
+	}{{"One.", 0.9}, {"Two sentence.", 0.3}, {"Three.", 0.9}}
+	for i, w := range want {
+		got := breakdown.SentenceAnalysis[i]
+		if got.Text != w.text || got.AIProbability != w.probability {
+			t.Errorf("sentence %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if breakdown.AISentences != 2 || breakdown.HumanSentences != 1 {
+		t.Errorf("ai/human sentences = %d/%d, want 2/1", breakdown.AISentences, breakdown.HumanSentences)
+	}
+	if got := breakdown.Confidence; got < 0.666 || got > 0.667 {
+		t.Errorf("confidence = %v, want 2/3", got)
+	}
+}
+
+func TestAggregatorAllFail(t *testing.T) {
+	aggregator := NewAggregator(10*time.Millisecond,
+		&fakeDetector{name: GPTZeroName, err: errors.New("boom")},
+		&fakeDetector{name: SaplingName, delay: time.Second},
+	)
+	if _, err := aggregator.Detect(context.Background(), "text"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := NewAggregator(time.Second).Detect(context.Background(), "text"); !errors.Is(err, ErrNoDetectors) {
+		t.Fatalf("err = %v, want ErrNoDetectors", err)
+	}
+}
+
+func sentences(pairs ...any) []model.SentenceAnalysis {
+	var result []model.SentenceAnalysis
+	for i := 0; i+1 < len(pairs); i += 2 {
+		probability, ok := pairs[i+1].(float64)
+		if !ok {
+			probability = float64(pairs[i+1].(int))
+		}
+		result = append(result, model.SentenceAnalysis{Text: pairs[i].(string), AIProbability: probability})
+	}
+	return result
+}
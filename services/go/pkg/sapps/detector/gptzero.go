@@ -0,0 +1,59 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sapps/pkg/sapps/model"
+)
+
+type GPTZero struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+}
+
+func NewGPTZero(apiKey string) *GPTZero {
+	return &GPTZero{
+		BaseURL: "https://api.gptzero.me",
+		APIKey:  apiKey,
+	}
+}
+
+type gptZeroResponse struct {
+	Documents []struct {
+		CompletelyGeneratedProb float64 `json:"completely_generated_prob"`
+		Sentences               []struct {
+			Sentence      string  `json:"sentence"`
+			GeneratedProb float64 `json:"generated_prob"`
+		} `json:"sentences"`
+	} `json:"documents"`
+}
+
+func (d *GPTZero) Name() string {
+	return GPTZeroName
+}
+
+func (d *GPTZero) Detect(ctx context.Context, text string) (*Result, error) {
+	var resp gptZeroResponse
+	err := postJSON(ctx, d.Client, d.BaseURL+"/v2/predict/text",
+		map[string]string{"x-api-key": d.APIKey},
+		map[string]string{"document": text},
+		&resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Documents) == 0 {
+		return nil, errors.New("gptzero: empty documents")
+	}
+
+	document := resp.Documents[0]
+	result := &Result{AIProbability: clamp(document.CompletelyGeneratedProb)}
+	for _, sentence := range document.Sentences {
+		result.Sentences = append(result.Sentences, model.SentenceAnalysis{
+			Text:          sentence.Sentence,
+			AIProbability: clamp(sentence.GeneratedProb),
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,132 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	grammarlyScope         = "ai-detection-api:read ai-detection-api:write"
+	grammarlyStatusDone    = "COMPLETED"
+	grammarlyStatusFailed  = "FAILED"
+	grammarlyPollInterval  = time.Second
+	grammarlyUploadedFile  = "text.txt"
+	grammarlyDetectionPath = "/ecosystem/api/v1/ai-detection"
+)
+
+// Grammarly scores asynchronously: a score request is created, the text is
+// uploaded to the URL it returns and the score is polled until it is done.
+// The aggregator timeout bounds the polling.
+type Grammarly struct {
+	BaseURL      string
+	AuthURL      string
+	ClientID     string
+	ClientSecret string
+	PollInterval time.Duration
+	Client       *http.Client
+}
+
+func NewGrammarly(clientID string, clientSecret string) *Grammarly {
+	return &Grammarly{
+		BaseURL:      "https://api.grammarly.com",
+		AuthURL:      "https://auth.grammarly.com",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		PollInterval: grammarlyPollInterval,
+	}
+}
+
+type grammarlyScoreRequest struct {
+	ScoreRequestID string `json:"score_request_id"`
+	FileUploadURL  string `json:"file_upload_url"`
+}
+
+type grammarlyScore struct {
+	Status string `json:"status"`
+	Score  *struct {
+		AIGeneratedPercentage float64 `json:"ai_generated_percentage"`
+	} `json:"score"`
+}
+
+func (d *Grammarly) Name() string {
+	return GrammarlyName
+}
+
+func (d *Grammarly) Detect(ctx context.Context, text string) (*Result, error) {
+	token, err := d.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	auth := map[string]string{"Authorization": "Bearer " + token}
+
+	var scoreRequest grammarlyScoreRequest
+	err = postJSON(ctx, d.Client, d.BaseURL+grammarlyDetectionPath, auth,
+		map[string]string{"filename": grammarlyUploadedFile}, &scoreRequest)
+	if err != nil {
+		return nil, err
+	}
+	if scoreRequest.ScoreRequestID == "" || scoreRequest.FileUploadURL == "" {
+		return nil, errors.New("grammarly: no score request")
+	}
+
+	upload, err := http.NewRequestWithContext(ctx, "PUT", scoreRequest.FileUploadURL, strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	// The upload URL is presigned and carries its own authorization
+	if err := doJSON(d.Client, upload, map[string]string{"Content-Type": "text/plain"}, nil); err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		var score grammarlyScore
+		err := getJSON(ctx, d.Client, d.BaseURL+grammarlyDetectionPath+"/"+url.PathEscape(scoreRequest.ScoreRequestID), auth, &score)
+		if err != nil {
+			return nil, err
+		}
+		switch score.Status {
+		case grammarlyStatusDone:
+			if score.Score == nil {
+				return nil, errors.New("grammarly: completed without a score")
+			}
+			return &Result{AIProbability: clamp(score.Score.AIGeneratedPercentage / 100)}, nil
+		case grammarlyStatusFailed:
+			return nil, errors.New("grammarly: scoring failed")
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// token requests an access token with the client credentials grant.
+func (d *Grammarly) token(ctx context.Context) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", d.ClientID)
+	form.Set("client_secret", d.ClientSecret)
+	form.Set("scope", grammarlyScope)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", d.AuthURL+"/v4/api/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = doJSON(d.Client, httpReq, map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.AccessToken == "" {
+		return "", errors.New("grammarly: no access token")
+	}
+	return resp.AccessToken, nil
+}
@@ -0,0 +1,54 @@
+package detector
+
+import (
+	"context"
+	"net/http"
+	"sapps/pkg/sapps/model"
+)
+
+// QuillBot has no public API, its AI detector is offered to partners. BaseURL
+// is the endpoint of that agreement, so there is no default.
+type QuillBot struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+}
+
+func NewQuillBot(baseURL string, apiKey string) *QuillBot {
+	return &QuillBot{
+		BaseURL: baseURL,
+		APIKey:  apiKey,
+	}
+}
+
+type quillBotResponse struct {
+	AIProbability float64 `json:"ai_probability"`
+	Sentences     []struct {
+		Text          string  `json:"text"`
+		AIProbability float64 `json:"ai_probability"`
+	} `json:"sentences"`
+}
+
+func (d *QuillBot) Name() string {
+	return QuillBotName
+}
+
+func (d *QuillBot) Detect(ctx context.Context, text string) (*Result, error) {
+	var resp quillBotResponse
+	err := postJSON(ctx, d.Client, d.BaseURL+"/ai-detector",
+		map[string]string{"Authorization": "Bearer " + d.APIKey},
+		map[string]string{"text": text},
+		&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{AIProbability: clamp(resp.AIProbability)}
+	for _, sentence := range resp.Sentences {
+		result.Sentences = append(result.Sentences, model.SentenceAnalysis{
+			Text:          sentence.Text,
+			AIProbability: clamp(sentence.AIProbability),
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,57 @@
+package detector
+
+import (
+	"context"
+	"net/http"
+	"sapps/pkg/sapps/model"
+)
+
+type Sapling struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+}
+
+func NewSapling(apiKey string) *Sapling {
+	return &Sapling{
+		BaseURL: "https://api.sapling.ai",
+		APIKey:  apiKey,
+	}
+}
+
+type saplingRequest struct {
+	Key        string `json:"key"`
+	Text       string `json:"text"`
+	SentScores bool   `json:"sent_scores"`
+}
+
+type saplingResponse struct {
+	Score          float64 `json:"score"`
+	SentenceScores []struct {
+		Sentence string  `json:"sentence"`
+		Score    float64 `json:"score"`
+	} `json:"sentence_scores"`
+}
+
+func (d *Sapling) Name() string {
+	return SaplingName
+}
+
+func (d *Sapling) Detect(ctx context.Context, text string) (*Result, error) {
+	var resp saplingResponse
+	err := postJSON(ctx, d.Client, d.BaseURL+"/api/v1/aidetect", nil,
+		saplingRequest{Key: d.APIKey, Text: text, SentScores: true},
+		&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{AIProbability: clamp(resp.Score)}
+	for _, sentence := range resp.SentenceScores {
+		result.Sentences = append(result.Sentences, model.SentenceAnalysis{
+			Text:          sentence.Sentence,
+			AIProbability: clamp(sentence.Score),
+		})
+	}
+	return result, nil
+}
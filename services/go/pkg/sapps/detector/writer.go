@@ -0,0 +1,51 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type Writer struct {
+	BaseURL        string
+	APIKey         string
+	OrganizationID string
+	Client         *http.Client
+}
+
+func NewWriter(apiKey string, organizationID string) *Writer {
+	return &Writer{
+		BaseURL:        "https://enterprise-api.writer.com",
+		APIKey:         apiKey,
+		OrganizationID: organizationID,
+	}
+}
+
+type writerLabel struct {
+	Label string  `json:"label"`
+	Score float64 `json:"score"`
+}
+
+func (d *Writer) Name() string {
+	return WriterName
+}
+
+func (d *Writer) Detect(ctx context.Context, text string) (*Result, error) {
+	var resp []writerLabel
+	err := postJSON(ctx, d.Client, d.BaseURL+"/content/organization/"+d.OrganizationID+"/detect",
+		map[string]string{"Authorization": "Bearer " + d.APIKey},
+		map[string]string{"input": text},
+		&resp)
+	if err != nil {
+		return nil, err
+	}
+	for _, label := range resp {
+		switch label.Label {
+		case "fake":
+			return &Result{AIProbability: clamp(label.Score)}, nil
+		case "real":
+			return &Result{AIProbability: clamp(1 - label.Score)}, nil
+		}
+	}
+	return nil, errors.New("writer: no detection label")
+}
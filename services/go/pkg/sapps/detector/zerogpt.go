@@ -0,0 +1,58 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sapps/pkg/sapps/model"
+)
+
+type ZeroGPT struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+}
+
+func NewZeroGPT(apiKey string) *ZeroGPT {
+	return &ZeroGPT{
+		BaseURL: "https://api.zerogpt.com",
+		APIKey:  apiKey,
+	}
+}
+
+type zeroGPTResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Data    struct {
+		FakePercentage float64 `json:"fakePercentage"`
+		// Sentences flagged as AI generated
+		H []string `json:"h"`
+	} `json:"data"`
+}
+
+func (d *ZeroGPT) Name() string {
+	return ZeroGPTName
+}
+
+func (d *ZeroGPT) Detect(ctx context.Context, text string) (*Result, error) {
+	var resp zeroGPTResponse
+	err := postJSON(ctx, d.Client, d.BaseURL+"/api/detect/detectText",
+		map[string]string{"ApiKey": d.APIKey},
+		map[string]string{"input_text": text},
+		&resp)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, errors.New("zerogpt: " + resp.Message)
+	}
+
+	result := &Result{AIProbability: clamp(resp.Data.FakePercentage / 100)}
+	for _, sentence := range resp.Data.H {
+		result.Sentences = append(result.Sentences, model.SentenceAnalysis{
+			Text:          sentence,
+			AIProbability: 1,
+		})
+	}
+	return result, nil
+}
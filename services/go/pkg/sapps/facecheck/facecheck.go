@@ -0,0 +1,157 @@
+package facecheck
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"sapps/pkg/sapps/constant"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	DetectorNone   = "none"
+	DetectorVision = "vision"
+)
+
+// Images are scaled down to this size before measuring sharpness, so the
+// threshold does not depend on the resolution of the upload.
+const sharpnessSide = 512
+
+// Rejection is returned for images that are not worth scanning, Code is sent
+// to the client.
+type Rejection struct {
+	Code    string
+	Message string
+}
+
+func (r *Rejection) Error() string {
+	return r.Message
+}
+
+var (
+	ErrTooSmall      = &Rejection{Code: "IMAGE_TOO_SMALL", Message: "image resolution is too small"}
+	ErrBlurry        = &Rejection{Code: "IMAGE_BLURRY", Message: "image is too blurry"}
+	ErrNoFace        = &Rejection{Code: "NO_FACE", Message: "no face found in the image"}
+	ErrMultipleFaces = &Rejection{Code: "MULTIPLE_FACES", Message: "more than one face found in the image"}
+)
+
+// FaceDetector counts the faces in an image.
+type FaceDetector interface {
+	Name() string
+	CountFaces(ctx context.Context, img image.Image) (int, error)
+}
+
+// Checker rejects images before they are sent to a paid model. The local
+// resolution and blur checks run first, the face detector only for images
+// that passed them.
+type Checker struct {
+	faces        FaceDetector
+	minSide      int
+	minSharpness float64
+}
+
+// NewChecker returns a checker, faces may be nil to skip face detection.
+func NewChecker(faces FaceDetector, minSide int, minSharpness float64) *Checker {
+	return &Checker{
+		faces:        faces,
+		minSide:      minSide,
+		minSharpness: minSharpness,
+	}
+}
+
+// InjectChecker configures the checker from the environment. Scans are paid
+// for, so the API does not start with a face detector it cannot use.
+func InjectChecker() *Checker {
+	var faces FaceDetector
+	switch constant.FACE_DETECTOR {
+	case DetectorVision:
+		vision, err := NewVisionFaceDetector(context.Background(), constant.VISION_CREDENTIALS_PATH)
+		if err != nil {
+			log.Fatalln(fmt.Errorf("face detection: %w", err))
+		}
+		faces = vision
+	case DetectorNone:
+		log.Println("FACE DETECTION DISABLED")
+	default:
+		log.Fatalf("unknown FACE_DETECTOR %q", constant.FACE_DETECTOR)
+	}
+	return NewChecker(faces, constant.IMAGE_MIN_SIDE, constant.IMAGE_MIN_SHARPNESS)
+}
+
+// Check returns a *Rejection for images that should not be scanned. Other
+// errors come from the face detector, the image must not be scanned then
+// either.
+func (c *Checker) Check(ctx context.Context, img image.Image) error {
+	bounds := img.Bounds()
+	if min(bounds.Dx(), bounds.Dy()) < c.minSide {
+		return ErrTooSmall
+	}
+	if Sharpness(img) < c.minSharpness {
+		return ErrBlurry
+	}
+	if c.faces == nil {
+		return nil
+	}
+	count, err := c.faces.CountFaces(ctx, img)
+	if err != nil {
+		return fmt.Errorf("%s face detection failed: %w", c.faces.Name(), err)
+	}
+	switch {
+	case count == 0:
+		return ErrNoFace
+	case count > 1:
+		return ErrMultipleFaces
+	}
+	return nil
+}
+
+// Sharpness is the variance of the Laplacian of the grayscale image, low
+// values mean few edges and a blurry image.
+func Sharpness(img image.Image) float64 {
+	gray := scaleGray(img, sharpnessSide)
+	width, height := gray.Bounds().Dx(), gray.Bounds().Dy()
+	if width < 3 || height < 3 {
+		return 0
+	}
+	at := func(x, y int) float64 {
+		return float64(gray.Pix[y*gray.Stride+x])
+	}
+	var sum, sumSquares float64
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			laplacian := at(x-1, y) + at(x+1, y) + at(x, y-1) + at(x, y+1) - 4*at(x, y)
+			sum += laplacian
+			sumSquares += laplacian * laplacian
+		}
+	}
+	n := float64((width - 2) * (height - 2))
+	mean := sum / n
+	return sumSquares/n - mean*mean
+}
+
+// scaleGray converts img to grayscale, scaled down so its longer side is at
+// most side.
+func scaleGray(img image.Image, side int) *image.Gray {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if longer := max(width, height); longer > side {
+		width, height = max(width*side/longer, 1), max(height*side/longer, 1)
+	}
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(gray, gray.Bounds(), img, bounds, draw.Src, nil)
+	return gray
+}
+
+// scaleDown returns img scaled down so its longer side is at most side.
+func scaleDown(img image.Image, side int) image.Image {
+	bounds := img.Bounds()
+	longer := max(bounds.Dx(), bounds.Dy())
+	if longer <= side {
+		return img
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, max(bounds.Dx()*side/longer, 1), max(bounds.Dy()*side/longer, 1)))
+	draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
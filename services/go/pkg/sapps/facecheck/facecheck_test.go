@@ -0,0 +1,81 @@
+package facecheck
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+type fakeDetector struct {
+	faces int
+	err   error
+}
+
+func (d *fakeDetector) Name() string {
+	return "fake"
+}
+
+func (d *fakeDetector) CountFaces(ctx context.Context, img image.Image) (int, error) {
+	return d.faces, d.err
+}
+
+// checkerboard returns a sharp image with squares of the given size.
+func checkerboard(width, height, square int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			if (x/square+y/square)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+func flat(width, height int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+	return img
+}
+
+func TestChecker(t *testing.T) {
+	sharp := checkerboard(800, 600, 8)
+	detectorErr := errors.New("unavailable")
+	for name, tc := range map[string]struct {
+		img      image.Image
+		detector FaceDetector
+		want     error
+	}{
+		"ok":              {sharp, &fakeDetector{faces: 1}, nil},
+		"no detector":     {sharp, nil, nil},
+		"too small":       {checkerboard(200, 600, 8), &fakeDetector{faces: 1}, ErrTooSmall},
+		"blurry":          {flat(800, 600), &fakeDetector{faces: 1}, ErrBlurry},
+		"no face":         {sharp, &fakeDetector{faces: 0}, ErrNoFace},
+		"multiple faces":  {sharp, &fakeDetector{faces: 2}, ErrMultipleFaces},
+		"detector failed": {sharp, &fakeDetector{err: detectorErr}, detectorErr},
+	} {
+		err := NewChecker(tc.detector, 256, 40).Check(context.Background(), tc.img)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, tc.want)
+		}
+		var rejection *Rejection
+		if name == "detector failed" && errors.As(err, &rejection) {
+			t.Errorf("detector failure is a rejection")
+		}
+	}
+}
+
+func TestSharpness(t *testing.T) {
+	if sharpness := Sharpness(flat(600, 600)); sharpness != 0 {
+		t.Fatalf("flat image sharpness = %v", sharpness)
+	}
+	// More edges make a sharper image
+	fine, coarse := Sharpness(checkerboard(600, 600, 4)), Sharpness(checkerboard(600, 600, 60))
+	if fine <= coarse {
+		t.Fatalf("fine checkerboard %v is not sharper than coarse %v", fine, coarse)
+	}
+}
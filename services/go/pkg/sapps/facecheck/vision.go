@@ -0,0 +1,69 @@
+package facecheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/jpeg"
+
+	"google.golang.org/api/option"
+	vision "google.golang.org/api/vision/v1"
+)
+
+const (
+	// Faces are still found reliably at this size and the request stays small
+	visionImageSide = 1024
+	// Detections below this confidence are not counted as faces
+	visionMinConfidence = 0.5
+)
+
+// VisionFaceDetector counts faces with Google Cloud Vision.
+type VisionFaceDetector struct {
+	service *vision.Service
+}
+
+func NewVisionFaceDetector(ctx context.Context, credentialsFile string) (*VisionFaceDetector, error) {
+	if credentialsFile == "" {
+		return nil, errors.New("VISION_CREDENTIALS_PATH is not set")
+	}
+	service, err := vision.NewService(ctx, option.WithCredentialsFile(credentialsFile))
+	if err != nil {
+		return nil, err
+	}
+	return &VisionFaceDetector{service: service}, nil
+}
+
+func (d *VisionFaceDetector) Name() string {
+	return "vision"
+}
+
+func (d *VisionFaceDetector) CountFaces(ctx context.Context, img image.Image) (int, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(img, visionImageSide), &jpeg.Options{Quality: 90}); err != nil {
+		return 0, err
+	}
+	resp, err := d.service.Images.Annotate(&vision.BatchAnnotateImagesRequest{
+		Requests: []*vision.AnnotateImageRequest{{
+			Image:    &vision.Image{Content: base64.StdEncoding.EncodeToString(buf.Bytes())},
+			Features: []*vision.Feature{{Type: "FACE_DETECTION", MaxResults: 10}},
+		}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Responses) == 0 {
+		return 0, errors.New("empty vision response")
+	}
+	if status := resp.Responses[0].Error; status != nil {
+		return 0, errors.New(status.Message)
+	}
+	count := 0
+	for _, face := range resp.Responses[0].FaceAnnotations {
+		if face.DetectionConfidence >= visionMinConfidence {
+			count++
+		}
+	}
+	return count, nil
+}
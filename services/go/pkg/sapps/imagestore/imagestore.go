@@ -0,0 +1,105 @@
+package imagestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/jpeg"
+	"log"
+	"regexp"
+	"sapps/pkg/sapps/constant"
+	"sync"
+)
+
+const (
+	BackendLocal  = "local"
+	BackendS3     = "s3"
+	BackendMemory = "memory"
+)
+
+var (
+	ErrNotFound   = errors.New("image not found")
+	ErrInvalidKey = errors.New("invalid image key")
+)
+
+// keyPattern allows a single file name, keys never reach outside the store.
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[a-z0-9]+)?$`)
+
+type Object struct {
+	Data        []byte
+	ContentType string
+	// Hash is the hex sha256 of Data
+	Hash string
+}
+
+// Store keeps the images served under /cdn/img, keyed by file name such as
+// <image id>.jpg.
+type Store interface {
+	// Put stores data under key, replacing what was there, and returns its
+	// content hash.
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	// Get returns ErrNotFound for missing keys.
+	Get(ctx context.Context, key string) (*Object, error)
+	// Delete succeeds for missing keys.
+	Delete(ctx context.Context, key string) error
+}
+
+type storeHolder struct {
+	init sync.Once
+	s    Store
+}
+
+var singletonStore storeHolder
+
+// InjectStore returns the store selected by IMAGE_STORE, the same one for the
+// API and the scripts. Only the S3 store is shared between instances.
+func InjectStore() Store {
+	singletonStore.init.Do(func() {
+		singletonStore.s = newStore()
+	})
+	return singletonStore.s
+}
+
+func newStore() Store {
+	switch constant.IMAGE_STORE {
+	case BackendS3:
+		store, err := NewS3Store(S3Config{
+			Endpoint:        constant.S3_ENDPOINT,
+			Region:          constant.S3_REGION,
+			Bucket:          constant.S3_BUCKET,
+			AccessKeyID:     constant.S3_ACCESS_KEY_ID,
+			SecretAccessKey: constant.S3_SECRET_ACCESS_KEY,
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return store
+	case BackendMemory:
+		return NewMemoryStore()
+	}
+	return NewLocalStore(constant.WD_PATH + "/cdn/img")
+}
+
+func ValidKey(key string) bool {
+	return keyPattern.MatchString(key)
+}
+
+// Hash returns the content hash of data.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// PutJPEG encodes img as a JPEG and stores it under key, returning the
+// content hash and the encoded size.
+func PutJPEG(ctx context.Context, store Store, key string, img image.Image, quality int) (string, int, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return "", 0, err
+	}
+	hash, err := store.Put(ctx, key, buf.Bytes(), "image/jpeg")
+	return hash, buf.Len(), err
+}
@@ -0,0 +1,129 @@
+package imagestore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testStore checks the behavior every Store shares.
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+	data := []byte("image data")
+
+	if _, err := store.Get(ctx, "missing.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get missing: %v", err)
+	}
+	hash, err := store.Put(ctx, "image.jpg", data, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != Hash(data) {
+		t.Fatalf("hash = %s", hash)
+	}
+	object, err := store.Get(ctx, "image.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(object.Data) != string(data) || object.ContentType != "image/jpeg" || object.Hash != hash {
+		t.Fatalf("got %+v", object)
+	}
+	if err := store.Delete(ctx, "image.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "image.jpg"); err != nil {
+		t.Fatalf("delete missing: %v", err)
+	}
+	if _, err := store.Get(ctx, "image.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get deleted: %v", err)
+	}
+	if _, err := store.Put(ctx, "../image.jpg", data, "image/jpeg"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("put outside the store: %v", err)
+	}
+	if _, err := store.Get(ctx, "../image.jpg"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("get outside the store: %v", err)
+	}
+	if err := store.Delete(ctx, "../image.jpg"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("delete outside the store: %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestLocalStore(t *testing.T) {
+	testStore(t, NewLocalStore(t.TempDir()))
+}
+
+// fakeS3 stores objects of signed requests in memory.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if Hash(body) != r.Header.Get("X-Amz-Content-Sha256") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = body
+		f.headers[r.URL.Path] = r.Header.Clone()
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", f.headers[r.URL.Path].Get("Content-Type"))
+		w.Header().Set(s3HashHeader, f.headers[r.URL.Path].Get(s3HashHeader))
+		w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}, headers: map[string]http.Header{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := NewS3Store(S3Config{Endpoint: server.URL, Bucket: "images", AccessKeyID: "key", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	if _, err := store.Put(context.Background(), "image.jpg", []byte("x"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["/images/image.jpg"]; !ok {
+		t.Fatalf("objects are not addressed path style: %v", fake.objects)
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	// Example from the AWS signature version 4 documentation
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	if got := hex.EncodeToString(key); got != "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d" {
+		t.Fatalf("signing key = %s", got)
+	}
+}
@@ -0,0 +1,71 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// LocalStore keeps images as files in a directory.
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir}
+}
+
+// Put writes to a temporary file first, readers never see a partial image.
+func (s *LocalStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	tempFile, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return "", err
+	}
+	if err := tempFile.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tempFile.Name(), filepath.Join(s.dir, key)); err != nil {
+		return "", err
+	}
+	return Hash(data), nil
+}
+
+// Get infers the content type from the extension, files carry no metadata.
+func (s *LocalStore) Get(ctx context.Context, key string) (*Object, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &Object{Data: data, ContentType: contentType, Hash: Hash(data)}, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(filepath.Join(s.dir, key))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package imagestore
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// MemoryStore keeps images in process, for tests.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]Object
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: map[string]Object{}}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	object := Object{Data: slices.Clone(data), ContentType: contentType, Hash: Hash(data)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = object
+	return object.Hash, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Object, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	object.Data = slices.Clone(object.Data)
+	return &object, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
@@ -0,0 +1,116 @@
+package imageupload
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// heicBrands are the ftyp major brands of HEIF images iPhones and Android
+// cameras write.
+var heicBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"hevc": true, "hevx": true, "mif1": true,
+}
+
+// heicSize returns the largest image spatial extent property of the file. A
+// photo split into tiles also lists the extent of every tile, the largest is
+// the whole image.
+func heicSize(data []byte) (int, int, error) {
+	meta := findBox(data, "meta")
+	if len(meta) < 4 {
+		return 0, 0, ErrInvalidImage
+	}
+	// meta is a full box, version and flags come first
+	ipco := findBox(findBox(meta[4:], "iprp"), "ipco")
+	width, height := 0, 0
+	eachBox(ipco, func(boxType string, payload []byte) {
+		if boxType != "ispe" || len(payload) < 12 {
+			return
+		}
+		w := int(binary.BigEndian.Uint32(payload[4:]))
+		h := int(binary.BigEndian.Uint32(payload[8:]))
+		if w*h > width*height {
+			width, height = w, h
+		}
+	})
+	if width == 0 || height == 0 {
+		return 0, 0, ErrInvalidImage
+	}
+	return width, height, nil
+}
+
+func findBox(data []byte, boxType string) []byte {
+	var found []byte
+	eachBox(data, func(t string, payload []byte) {
+		if t == boxType && found == nil {
+			found = payload
+		}
+	})
+	return found
+}
+
+// eachBox calls fn with the type and payload of the ISO BMFF boxes in data.
+func eachBox(data []byte, fn func(boxType string, payload []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			// Extends to the end of the data
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return
+		}
+		fn(boxType, data[header:size])
+		data = data[size:]
+	}
+}
+
+// HeifConvertDecoder decodes HEIC with heif-convert from libheif, there is no
+// HEVC decoder for Go without cgo.
+type HeifConvertDecoder struct {
+	path string
+}
+
+func NewHeifConvertDecoder(path string) *HeifConvertDecoder {
+	return &HeifConvertDecoder{path: path}
+}
+
+func (d *HeifConvertDecoder) Decode(ctx context.Context, data []byte) (image.Image, error) {
+	dir, err := os.MkdirTemp("", "imageupload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.heic")
+	out := filepath.Join(dir, "out.png")
+	if err := os.WriteFile(in, data, 0o600); err != nil {
+		return nil, err
+	}
+	output, err := exec.CommandContext(ctx, d.path, in, out).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%w: heif-convert: %v: %s", ErrInvalidImage, err, bytes.TrimSpace(output))
+	}
+	file, err := os.Open(out)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
@@ -0,0 +1,156 @@
+package imageupload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"sapps/pkg/sapps/constant"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+	FormatHEIC = "heic"
+)
+
+var (
+	ErrInvalidImage      = errors.New("invalid image")
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooManyPixels     = errors.New("image has too many pixels")
+)
+
+// Upload is an uploaded image ready to be stored. Image holds only pixels,
+// encoding it drops every piece of metadata the upload carried.
+type Upload struct {
+	Image  image.Image
+	Format string
+}
+
+var contentTypes = map[string]string{
+	FormatJPEG: "image/jpeg",
+	FormatPNG:  "image/png",
+	FormatWebP: "image/webp",
+	FormatHEIC: "image/heic",
+}
+
+// ContentType is the content type of the uploaded file.
+func (u *Upload) ContentType() string {
+	return contentTypes[u.Format]
+}
+
+// Processor turns untrusted uploads into upright images of a bounded size.
+type Processor struct {
+	maxPixels int
+	maxSide   int
+	// heic is nil when HEIC uploads are not supported
+	heic Decoder
+}
+
+// Decoder decodes an image format Go has no decoder for.
+type Decoder interface {
+	Decode(ctx context.Context, data []byte) (image.Image, error)
+}
+
+func NewProcessor(maxPixels int, maxSide int, heic Decoder) *Processor {
+	return &Processor{maxPixels: maxPixels, maxSide: maxSide, heic: heic}
+}
+
+// InjectProcessor supports HEIC when heif-convert is configured.
+func InjectProcessor() *Processor {
+	var heic Decoder
+	if constant.HEIF_CONVERT_PATH != "" {
+		heic = NewHeifConvertDecoder(constant.HEIF_CONVERT_PATH)
+	}
+	return NewProcessor(constant.IMAGE_MAX_PIXELS, constant.IMAGE_MAX_SIDE, heic)
+}
+
+// Process checks the pixel dimensions in the header before decoding, so a
+// small file claiming a huge image is never decoded, then downscales the
+// image to the maximum side and applies its EXIF orientation.
+func (p *Processor) Process(ctx context.Context, data []byte) (*Upload, error) {
+	format := Sniff(data)
+	var width, height int
+	switch format {
+	case FormatJPEG, FormatPNG, FormatWebP:
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrInvalidImage
+		}
+		width, height = config.Width, config.Height
+	case FormatHEIC:
+		if p.heic == nil {
+			return nil, ErrUnsupportedFormat
+		}
+		var err error
+		if width, height, err = heicSize(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if width <= 0 || height <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if width*height > p.maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooManyPixels, width, height)
+	}
+
+	var img image.Image
+	var err error
+	if format == FormatHEIC {
+		// heif-convert applies the HEIF rotation itself, EXIF in HEIC files is
+		// not authoritative
+		img, err = p.heic.Decode(ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		return &Upload{Image: downscale(img, p.maxSide), Format: format}, nil
+	}
+	img, _, err = image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	// Both are symmetric in width and height, scaling first rotates fewer pixels
+	img = orient(downscale(img, p.maxSide), Orientation(format, data))
+	return &Upload{Image: img, Format: format}, nil
+}
+
+// Sniff returns the format of data from its magic bytes, "" when unknown.
+func Sniff(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return FormatJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return FormatWebP
+	case len(data) >= 12 && string(data[4:8]) == "ftyp" && heicBrands[string(data[8:12])]:
+		return FormatHEIC
+	}
+	return ""
+}
+
+// downscale scales img down so its longer side is at most maxSide.
+func downscale(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if maxSide <= 0 || max(w, h) <= maxSide {
+		return img
+	}
+	if w >= h {
+		w, h = maxSide, max(h*maxSide/w, 1)
+	} else {
+		w, h = max(w*maxSide/h, 1), maxSide
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
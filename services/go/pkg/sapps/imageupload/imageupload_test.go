@@ -0,0 +1,202 @@
+package imageupload
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+var (
+	red  = color.RGBA{R: 255, A: 255}
+	blue = color.RGBA{B: 255, A: 255}
+)
+
+// exifJPEG encodes img as a JPEG with an Exif segment holding orientation.
+func exifJPEG(t *testing.T, img image.Image, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = append(tiff, 0, 3, 0, 0, 0, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+// halves is red on its left and blue on its right half.
+func halves(w int, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	return img
+}
+
+func isColor(c color.Color, want color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	near := func(v uint32, w uint8) bool { return int(v>>8)-int(w) < 40 && int(w)-int(v>>8) < 40 }
+	return near(r, want.R) && near(g, want.G) && near(b, want.B)
+}
+
+func TestProcessOrientation(t *testing.T) {
+	processor := NewProcessor(1_000_000, 1000, nil)
+	tests := []struct {
+		orientation int
+		size        image.Point
+		// colors at the top left and bottom right of the result
+		topLeft, bottomRight color.RGBA
+	}{
+		{1, image.Pt(80, 40), red, blue},
+		{3, image.Pt(80, 40), blue, red},
+		{6, image.Pt(40, 80), red, blue},
+		{8, image.Pt(40, 80), blue, red},
+	}
+	for _, test := range tests {
+		upload, err := processor.Process(context.Background(), exifJPEG(t, halves(80, 40), test.orientation))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bounds := upload.Image.Bounds()
+		if bounds.Size() != test.size || upload.Format != FormatJPEG {
+			t.Fatalf("orientation %d: got %v %s", test.orientation, bounds.Size(), upload.Format)
+		}
+		if !isColor(upload.Image.At(bounds.Min.X+2, bounds.Min.Y+2), test.topLeft) ||
+			!isColor(upload.Image.At(bounds.Max.X-3, bounds.Max.Y-3), test.bottomRight) {
+			t.Errorf("orientation %d was not applied", test.orientation)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 0 1 2
+	// 3 4 5
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	want := map[int][]uint8{
+		2: {2, 1, 0, 5, 4, 3},
+		3: {5, 4, 3, 2, 1, 0},
+		4: {3, 4, 5, 0, 1, 2},
+		5: {0, 3, 1, 4, 2, 5},
+		6: {3, 0, 4, 1, 5, 2},
+		7: {5, 2, 4, 1, 3, 0},
+		8: {2, 5, 1, 4, 0, 3},
+	}
+	for orientation, pixels := range want {
+		oriented := orient(img, orientation)
+		bounds := oriented.Bounds()
+		var got []uint8
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				got = append(got, color.GrayModel.Convert(oriented.At(x, y)).(color.Gray).Y)
+			}
+		}
+		if !bytes.Equal(got, pixels) {
+			t.Errorf("orientation %d: got %v, want %v", orientation, got, pixels)
+		}
+	}
+}
+
+func TestProcessDownscales(t *testing.T) {
+	upload, err := NewProcessor(1_000_000, 100, nil).Process(context.Background(), exifJPEG(t, halves(400, 100), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := upload.Image.Bounds().Size(); size != image.Pt(100, 25) {
+		t.Fatalf("got %v", size)
+	}
+}
+
+// pngHeader is a PNG with only a header, claiming the given size.
+func pngHeader(width uint32, height uint32) []byte {
+	ihdr := binary.BigEndian.AppendUint32([]byte("IHDR"), width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+	data := binary.BigEndian.AppendUint32([]byte("\x89PNG\r\n\x1a\n"), 13)
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestProcessRejects(t *testing.T) {
+	processor := NewProcessor(1_000_000, 1000, nil)
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"decompression bomb", pngHeader(100_000, 100_000), ErrTooManyPixels},
+		{"truncated", pngHeader(10, 10), ErrInvalidImage},
+		{"unknown", []byte("GIF89a"), ErrUnsupportedFormat},
+		{"heic without decoder", heicFile(4032, 3024), ErrUnsupportedFormat},
+	}
+	for _, test := range tests {
+		if _, err := processor.Process(context.Background(), test.data); !errors.Is(err, test.err) {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
+
+// box is an ISO BMFF box.
+func box(boxType string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(data))), append([]byte(boxType), data...)...)
+}
+
+func ispe(width uint32, height uint32) []byte {
+	payload := binary.BigEndian.AppendUint32(make([]byte, 4), width)
+	return box("ispe", binary.BigEndian.AppendUint32(payload, height))
+}
+
+// heicFile is the header of a photo split into 512x512 tiles.
+func heicFile(width uint32, height uint32) []byte {
+	return append(
+		box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic")),
+		box("meta", make([]byte, 4), box("hdlr", make([]byte, 24)), box("iprp", box("ipco", ispe(512, 512), ispe(width, height))))...,
+	)
+}
+
+type fakeDecoder struct {
+	calls int
+}
+
+func (d *fakeDecoder) Decode(ctx context.Context, data []byte) (image.Image, error) {
+	d.calls++
+	return image.NewRGBA(image.Rect(0, 0, 403, 302)), nil
+}
+
+func TestProcessHEIC(t *testing.T) {
+	decoder := &fakeDecoder{}
+	upload, err := NewProcessor(50_000_000, 200, decoder).Process(context.Background(), heicFile(4032, 3024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Format != FormatHEIC || upload.ContentType() != "image/heic" || upload.Image.Bounds().Size() != image.Pt(200, 149) {
+		t.Fatalf("got %s %v", upload.Format, upload.Image.Bounds().Size())
+	}
+	if _, err := NewProcessor(10_000_000, 2048, decoder).Process(context.Background(), heicFile(4032, 3024)); !errors.Is(err, ErrTooManyPixels) {
+		t.Fatalf("large heic: %v", err)
+	}
+	if decoder.calls != 1 {
+		t.Fatalf("decoded %d times", decoder.calls)
+	}
+}
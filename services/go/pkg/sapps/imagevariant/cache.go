@@ -0,0 +1,74 @@
+package imagevariant
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DiskCache keeps rendered variants as files in a directory, each instance
+// has its own.
+type DiskCache struct {
+	dir string
+}
+
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{dir: dir}
+}
+
+// Get returns the cached variant and whether it was found. A hit touches the
+// file so Prune keeps variants that are still requested.
+func (c *DiskCache) Get(name string) ([]byte, bool) {
+	path := filepath.Join(c.dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// Put writes to a temporary file first, readers never see a partial variant.
+func (c *DiskCache) Put(name string, data []byte) error {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(c.dir, ".variant-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), filepath.Join(c.dir, name))
+}
+
+// Prune removes variants not requested within maxAge and returns how many.
+func (c *DiskCache) Prune(maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
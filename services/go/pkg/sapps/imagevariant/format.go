@@ -0,0 +1,135 @@
+package imagevariant
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatAVIF = "avif"
+	FormatWebP = "webp"
+	FormatJPEG = "jpeg"
+)
+
+// preference is the order formats are offered in, smallest files first.
+var preference = []string{FormatAVIF, FormatWebP, FormatJPEG}
+
+type Encoder interface {
+	ContentType() string
+	Encode(ctx context.Context, img image.Image, quality int) ([]byte, error)
+}
+
+type JPEGEncoder struct{}
+
+func (JPEGEncoder) ContentType() string {
+	return "image/jpeg"
+}
+
+func (JPEGEncoder) Encode(ctx context.Context, img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CommandEncoder encodes with an external tool, there is no WebP or AVIF
+// encoder for Go without cgo. The image is handed over as a PNG file.
+type CommandEncoder struct {
+	path        string
+	contentType string
+	args        func(in string, out string, quality int) []string
+}
+
+// NewCWebPEncoder encodes WebP with the cwebp binary at path.
+func NewCWebPEncoder(path string) *CommandEncoder {
+	return &CommandEncoder{
+		path:        path,
+		contentType: "image/webp",
+		args: func(in string, out string, quality int) []string {
+			return []string{"-quiet", "-q", strconv.Itoa(quality), "-o", out, "--", in}
+		},
+	}
+}
+
+// NewAVIFEncEncoder encodes AVIF with the avifenc binary at path.
+func NewAVIFEncEncoder(path string) *CommandEncoder {
+	return &CommandEncoder{
+		path:        path,
+		contentType: "image/avif",
+		args: func(in string, out string, quality int) []string {
+			return []string{"-q", strconv.Itoa(quality), "--", in, out}
+		},
+	}
+}
+
+func (e *CommandEncoder) ContentType() string {
+	return e.contentType
+}
+
+func (e *CommandEncoder) Encode(ctx context.Context, img image.Image, quality int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "imagevariant-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.png")
+	out := filepath.Join(dir, "out")
+	var buf bytes.Buffer
+	// Fastest compression, the file only lives until the encoder read it
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(in, buf.Bytes(), 0o600); err != nil {
+		return nil, err
+	}
+	output, err := exec.CommandContext(ctx, e.path, e.args(in, out, quality)...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", filepath.Base(e.path), err, bytes.TrimSpace(output))
+	}
+	return os.ReadFile(out)
+}
+
+// Negotiate returns the preferred of formats the Accept header names with a
+// non zero quality, JPEG otherwise. Wildcards do not count, browsers send
+// */* without being able to show every format.
+func Negotiate(accept string, formats []string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		accepted[mediaType] = acceptQuality(params) > 0
+	}
+	for _, format := range preference {
+		if format != FormatJPEG && accepted["image/"+format] && slices.Contains(formats, format) {
+			return format
+		}
+	}
+	return FormatJPEG
+}
+
+func acceptQuality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.TrimSpace(name) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return 0
+			}
+			return q
+		}
+	}
+	return 1
+}
@@ -0,0 +1,187 @@
+package imagevariant
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	opts, err := ParseOptions("320", "", "", "")
+	if err != nil || opts != (Options{Width: 320, Fit: FitContain}) {
+		t.Fatalf("got %+v, %v", opts, err)
+	}
+	if opts, _ := ParseOptions("", "", "", ""); !opts.Original() {
+		t.Fatal("no options is not the original")
+	}
+	invalid := [][4]string{
+		{"0", "", "", ""},
+		{"4096", "", "", ""},
+		{"", "x", "", ""},
+		{"", "", "101", ""},
+		{"", "", "", "stretch"},
+	}
+	for _, test := range invalid {
+		if _, err := ParseOptions(test[0], test[1], test[2], test[3]); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%q: %v", test, err)
+		}
+	}
+}
+
+func TestOptionsValues(t *testing.T) {
+	for _, opts := range []Options{
+		{Fit: FitContain},
+		{Width: 320, Height: 320, Fit: FitCover},
+		{Height: 100, Quality: 60, Fit: FitContain},
+	} {
+		values := opts.Values()
+		parsed, err := ParseOptions(values.Get("width"), values.Get("height"), values.Get("quality"), values.Get("fit"))
+		if err != nil || parsed != opts {
+			t.Errorf("%+v round tripped to %+v, %v", opts, parsed, err)
+		}
+	}
+	if values := (Options{Fit: FitContain}).Values(); len(values) != 0 {
+		t.Errorf("original has values %v", values)
+	}
+}
+
+func TestResize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	tests := []struct {
+		name string
+		opts Options
+		want image.Point
+	}{
+		{"width", Options{Width: 200, Fit: FitContain}, image.Pt(200, 100)},
+		{"height", Options{Height: 100, Fit: FitContain}, image.Pt(200, 100)},
+		{"contain", Options{Width: 200, Height: 200, Fit: FitContain}, image.Pt(200, 100)},
+		{"cover", Options{Width: 200, Height: 200, Fit: FitCover}, image.Pt(200, 200)},
+		{"never scales up", Options{Width: 1600, Fit: FitContain}, image.Pt(800, 400)},
+		{"cover never scales up", Options{Width: 1000, Height: 1000, Fit: FitCover}, image.Pt(400, 400)},
+		{"quality only", Options{Quality: 50, Fit: FitContain}, image.Pt(800, 400)},
+	}
+	for _, test := range tests {
+		if got := Resize(img, test.opts).Bounds().Size(); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	all := []string{FormatAVIF, FormatWebP, FormatJPEG}
+	tests := []struct {
+		accept  string
+		formats []string
+		want    string
+	}{
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", all, FormatAVIF},
+		{"image/avif;q=0,image/webp", all, FormatWebP},
+		{"image/avif,image/webp", []string{FormatWebP, FormatJPEG}, FormatWebP},
+		{"image/avif", []string{FormatJPEG}, FormatJPEG},
+		{"*/*", all, FormatJPEG},
+		{"", all, FormatJPEG},
+	}
+	for _, test := range tests {
+		if got := Negotiate(test.accept, test.formats); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestRenderer(t *testing.T) {
+	var original bytes.Buffer
+	if err := jpeg.Encode(&original, image.NewRGBA(image.Rect(0, 0, 800, 400)), nil); err != nil {
+		t.Fatal(err)
+	}
+	loads := 0
+	load := func(ctx context.Context) ([]byte, error) {
+		loads++
+		return original.Bytes(), nil
+	}
+	dir := t.TempDir()
+	renderer := NewRenderer(NewDiskCache(dir), nil)
+	opts := Options{Width: 100, Fit: FitContain}
+
+	variant, err := renderer.Render(context.Background(), "image.jpg", opts, FormatJPEG, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(variant.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Size() != image.Pt(100, 50) || variant.ContentType != "image/jpeg" {
+		t.Fatalf("got %v %s", img.Bounds().Size(), variant.ContentType)
+	}
+	cached, err := renderer.Render(context.Background(), "image.jpg", opts, FormatJPEG, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 1 || !bytes.Equal(cached.Data, variant.Data) || cached.ETag != variant.ETag {
+		t.Fatalf("variant was not cached, %d loads", loads)
+	}
+	other, err := renderer.Render(context.Background(), "image.jpg", Options{Width: 200, Fit: FitContain}, FormatJPEG, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ETag == variant.ETag {
+		t.Fatal("variants share an etag")
+	}
+	if _, err := renderer.Render(context.Background(), "image.jpg", opts, FormatWebP, load); err == nil {
+		t.Fatal("rendered a format without an encoder")
+	}
+
+	// Only variants not requested within the age are pruned
+	entries, _ := os.ReadDir(dir)
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, entries[0].Name()), old, old)
+	removed, err := renderer.Prune(time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("pruned %d, %v", removed, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("%d variants left", len(entries))
+	}
+}
+
+func TestRendererRendersOnce(t *testing.T) {
+	var original bytes.Buffer
+	if err := jpeg.Encode(&original, image.NewRGBA(image.Rect(0, 0, 800, 400)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) ([]byte, error) {
+		loads.Add(1)
+		<-release
+		return original.Bytes(), nil
+	}
+	renderer := NewRenderer(NewDiskCache(t.TempDir()), nil)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := renderer.Render(context.Background(), "image.jpg", Options{Width: 100, Fit: FitContain}, FormatJPEG, load)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// Requests arriving after the render finished hit the cache instead
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("%d loads, want 1", n)
+	}
+}
@@ -0,0 +1,142 @@
+package imagevariant
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"sapps/lib/util"
+	"sapps/pkg/sapps/constant"
+	"sync"
+	"time"
+)
+
+type Variant struct {
+	Data        []byte
+	ContentType string
+	// ETag identifies the variant, image keys are never reused for other
+	// content so the key and options are enough
+	ETag string
+}
+
+// Renderer renders and caches variants of stored images.
+type Renderer struct {
+	cache    *DiskCache
+	encoders map[string]Encoder
+
+	mu sync.Mutex
+	// renders in progress by variant name, requests for a variant being
+	// rendered wait for it instead of rendering it again
+	renders map[string]*render
+}
+
+type render struct {
+	done    chan struct{}
+	variant *Variant
+	err     error
+}
+
+// NewRenderer always encodes JPEG, encoders adds other formats.
+func NewRenderer(cache *DiskCache, encoders map[string]Encoder) *Renderer {
+	all := map[string]Encoder{FormatJPEG: JPEGEncoder{}}
+	for format, encoder := range encoders {
+		all[format] = encoder
+	}
+	return &Renderer{cache: cache, encoders: all, renders: map[string]*render{}}
+}
+
+// InjectRenderer offers WebP and AVIF when their encoders are configured.
+func InjectRenderer() *Renderer {
+	encoders := map[string]Encoder{}
+	if constant.CWEBP_PATH != "" {
+		encoders[FormatWebP] = NewCWebPEncoder(constant.CWEBP_PATH)
+	}
+	if constant.AVIFENC_PATH != "" {
+		encoders[FormatAVIF] = NewAVIFEncEncoder(constant.AVIFENC_PATH)
+	}
+	return NewRenderer(NewDiskCache(constant.IMAGE_VARIANT_CACHE_PATH), encoders)
+}
+
+// Negotiate returns the format to render for the Accept header.
+func (r *Renderer) Negotiate(accept string) string {
+	formats := make([]string, 0, len(r.encoders))
+	for format := range r.encoders {
+		formats = append(formats, format)
+	}
+	return Negotiate(accept, formats)
+}
+
+// Render returns the variant of the image stored under key, calling load for
+// the stored image only when the variant is not cached. Concurrent requests
+// for a variant that is not cached render it once.
+func (r *Renderer) Render(ctx context.Context, key string, opts Options, format string, load func(ctx context.Context) ([]byte, error)) (*Variant, error) {
+	encoder, ok := r.encoders[format]
+	if !ok {
+		return nil, fmt.Errorf("no encoder for %s", format)
+	}
+	name := variantName(key, opts, format)
+	if data, ok := r.cache.Get(name + "." + format); ok {
+		return &Variant{Data: data, ContentType: encoder.ContentType(), ETag: `"` + name + `"`}, nil
+	}
+
+	r.mu.Lock()
+	if pending, ok := r.renders[name]; ok {
+		r.mu.Unlock()
+		select {
+		case <-pending.done:
+			return pending.variant, pending.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	pending := &render{done: make(chan struct{})}
+	r.renders[name] = pending
+	r.mu.Unlock()
+
+	// The render is shared, a client going away must not fail the others
+	pending.variant, pending.err = r.render(context.WithoutCancel(ctx), key, name, opts, format, encoder, load)
+	r.mu.Lock()
+	delete(r.renders, name)
+	r.mu.Unlock()
+	close(pending.done)
+	return pending.variant, pending.err
+}
+
+func (r *Renderer) render(ctx context.Context, key string, name string, opts Options, format string, encoder Encoder, load func(ctx context.Context) ([]byte, error)) (*Variant, error) {
+	variant := &Variant{ContentType: encoder.ContentType(), ETag: `"` + name + `"`}
+	// A render that finished between the cache miss and the lock cached it
+	if data, ok := r.cache.Get(name + "." + format); ok {
+		variant.Data = data
+		return variant, nil
+	}
+
+	original, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	variant.Data, err = encoder.Encode(ctx, Resize(img, opts), opts.quality())
+	if err != nil {
+		return nil, err
+	}
+	if err := r.cache.Put(name+"."+format, variant.Data); err != nil {
+		// Serving the variant does not depend on caching it
+		util.LogErr(err)
+	}
+	return variant, nil
+}
+
+// Prune removes cached variants not requested within maxAge.
+func (r *Renderer) Prune(maxAge time.Duration) (int, error) {
+	return r.cache.Prune(maxAge)
+}
+
+func variantName(key string, opts Options, format string) string {
+	sum := sha256.Sum256([]byte(key + ":" + opts.String() + ":" + format))
+	return hex.EncodeToString(sum[:16])
+}
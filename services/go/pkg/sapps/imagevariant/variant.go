@@ -0,0 +1,145 @@
+package imagevariant
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// FitContain scales the image to fit inside width x height
+	FitContain = "contain"
+	// FitCover scales and center crops the image to exactly width x height
+	FitCover = "cover"
+
+	MaxSide        = 2048
+	DefaultQuality = 80
+)
+
+var ErrInvalidOptions = errors.New("invalid image options")
+
+// Options describe a variant of a stored image. A zero Width or Height is
+// derived from the other one and the aspect ratio of the image.
+type Options struct {
+	Width   int
+	Height  int
+	Quality int
+	Fit     string
+}
+
+// ParseOptions parses the width, height, quality and fit query parameters,
+// any of which may be empty.
+func ParseOptions(width string, height string, quality string, fit string) (Options, error) {
+	var opts Options
+	var err error
+	if opts.Width, err = parseInt(width, 0, 1, MaxSide); err != nil {
+		return Options{}, fmt.Errorf("%w: width must be between 1 and %d", ErrInvalidOptions, MaxSide)
+	}
+	if opts.Height, err = parseInt(height, 0, 1, MaxSide); err != nil {
+		return Options{}, fmt.Errorf("%w: height must be between 1 and %d", ErrInvalidOptions, MaxSide)
+	}
+	if opts.Quality, err = parseInt(quality, 0, 1, 100); err != nil {
+		return Options{}, fmt.Errorf("%w: quality must be between 1 and 100", ErrInvalidOptions)
+	}
+	switch fit {
+	case "":
+		opts.Fit = FitContain
+	case FitContain, FitCover:
+		opts.Fit = fit
+	default:
+		return Options{}, fmt.Errorf("%w: fit must be %s or %s", ErrInvalidOptions, FitContain, FitCover)
+	}
+	return opts, nil
+}
+
+// Original reports whether the options leave the stored image as it is.
+func (o Options) Original() bool {
+	return o.Width == 0 && o.Height == 0 && o.Quality == 0
+}
+
+// Values is the inverse of ParseOptions, the query parameters of o without
+// the defaults.
+func (o Options) Values() url.Values {
+	values := url.Values{}
+	if o.Width != 0 {
+		values.Set("width", strconv.Itoa(o.Width))
+	}
+	if o.Height != 0 {
+		values.Set("height", strconv.Itoa(o.Height))
+	}
+	if o.Quality != 0 {
+		values.Set("quality", strconv.Itoa(o.Quality))
+	}
+	if o.Fit != "" && o.Fit != FitContain {
+		values.Set("fit", o.Fit)
+	}
+	return values
+}
+
+func (o Options) quality() int {
+	if o.Quality == 0 {
+		return DefaultQuality
+	}
+	return o.Quality
+}
+
+func (o Options) String() string {
+	return fmt.Sprintf("%dx%d-q%d-%s", o.Width, o.Height, o.quality(), o.Fit)
+}
+
+// Resize returns img scaled to the options. Images are never scaled up, a
+// smaller image keeps its size, or for cover the largest crop of it.
+func Resize(img image.Image, o Options) image.Image {
+	bounds := img.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	w, h := o.Width, o.Height
+	src := bounds
+	switch {
+	case sw == 0 || sh == 0 || (w == 0 && h == 0):
+		return img
+	case w == 0:
+		w = max(sw*h/sh, 1)
+	case h == 0:
+		h = max(sh*w/sw, 1)
+	case o.Fit == FitCover:
+		if sw*h > sh*w {
+			cw := max(sh*w/h, 1)
+			x := bounds.Min.X + (sw-cw)/2
+			src = image.Rect(x, bounds.Min.Y, x+cw, bounds.Max.Y)
+		} else {
+			ch := max(sw*h/w, 1)
+			y := bounds.Min.Y + (sh-ch)/2
+			src = image.Rect(bounds.Min.X, y, bounds.Max.X, y+ch)
+		}
+	default:
+		if sw*h > sh*w {
+			h = max(sh*w/sw, 1)
+		} else {
+			w = max(sw*h/sh, 1)
+		}
+	}
+	if w > src.Dx() || h > src.Dy() {
+		w, h = src.Dx(), src.Dy()
+	}
+	if src == bounds && w == sw && h == sh {
+		return img
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, src, draw.Src, nil)
+	return scaled
+}
+
+func parseInt(value string, empty int, min int, max int) (int, error) {
+	if value == "" {
+		return empty, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, ErrInvalidOptions
+	}
+	return n, nil
+}
@@ -0,0 +1,203 @@
+package maindb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// Coins every user gets at the start of each weekly period
+	WeeklyCoins = 2
+	// Coins a non premium user spends per scan and per generation
+	ScanCoinCost       = 1
+	GenerationCoinCost = 1
+)
+
+const (
+	CoinReasonWeeklyReset      = "weekly_reset"
+	CoinReasonAdminReset       = "admin_reset"
+	CoinReasonScan             = "scan"
+	CoinReasonScanRefund       = "scan_refund"
+	CoinReasonGeneration       = "generation"
+	CoinReasonGenerationRefund = "generation_refund"
+)
+
+var ErrInsufficientCoins = errors.New("insufficient coins")
+
+// Execer is satisfied by the pool and by pgx.Tx.
+type Execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+// Querier is satisfied by the pool and by pgx.Tx.
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type CoinLedgerEntry struct {
+	ID           string    `json:"id"`
+	Amount       int       `json:"amount"`
+	BalanceAfter int       `json:"balance_after"`
+	Reason       string    `json:"reason"`
+	ReferenceID  *string   `json:"reference_id"`
+	CreatedDate  time.Time `json:"created_date"`
+}
+
+// AddCoins changes the user's balance by amount and records it in the ledger.
+// Debits that would make the balance negative fail with ErrInsufficientCoins,
+// after which tx must be rolled back. Entries are unique per user, reason and
+// reference, adding the same one twice is a no-op.
+func AddCoins(ctx context.Context, tx pgx.Tx, userID string, amount int, reason string, referenceID string) error {
+	var entryID string
+	err := tx.QueryRow(ctx, `
+		INSERT INTO coin_ledger (user_id, amount, reason, reference_id) VALUES ($1, $2, $3, NULLIF($4, ''))
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`, userID, amount, reason, referenceID).Scan(&entryID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	var balance int
+	err = tx.QueryRow(ctx, `
+		UPDATE users SET coin = COALESCE(coin, 0) + $2 WHERE id = $1 AND COALESCE(coin, 0) + $2 >= 0 RETURNING coin
+	`, userID, amount).Scan(&balance)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrInsufficientCoins
+		}
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE coin_ledger SET balance_after = $1 WHERE id = $2`, balance, entryID)
+	return err
+}
+
+// RefundCoins gives back what was debited for reason and referenceID, if
+// anything, under refundReason. Refunding twice has no effect.
+func RefundCoins(ctx context.Context, tx pgx.Tx, reason string, referenceID string, refundReason string) error {
+	var userID string
+	var amount int
+	err := tx.QueryRow(ctx, `
+		SELECT user_id, -amount FROM coin_ledger WHERE reason = $1 AND reference_id = $2 AND amount < 0
+	`, reason, referenceID).Scan(&userID, &amount)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	return AddCoins(ctx, tx, userID, amount, refundReason, referenceID)
+}
+
+// ResetWeeklyCoins starts a new weekly period with WeeklyCoins when the
+// current one has ended. It returns the balance afterwards and reports whether
+// the reset happened, so concurrent requests reset the balance only once.
+func ResetWeeklyCoins(ctx context.Context, db Querier, userID string) (int, bool, error) {
+	var balance int
+	err := db.QueryRow(ctx, `
+		WITH old AS (
+			SELECT id, COALESCE(coin, 0) AS coin FROM users
+			WHERE id = $1 AND (coin_reset_date IS NULL OR coin_reset_date < NOW())
+			FOR UPDATE
+		), reset AS (
+			UPDATE users u SET coin = $2, coin_reset_date = NOW() + INTERVAL '7 day'
+			FROM old WHERE u.id = old.id
+			RETURNING u.id, old.coin AS old_coin, u.coin_reset_date
+		)
+		INSERT INTO coin_ledger (user_id, amount, balance_after, reason, reference_id)
+		SELECT id, $2 - old_coin, $2, $3, to_char(coin_reset_date, 'YYYY-MM-DD"T"HH24:MI:SS') FROM reset
+		RETURNING balance_after
+	`, userID, WeeklyCoins, CoinReasonWeeklyReset).Scan(&balance)
+	if err == nil {
+		return balance, true, nil
+	}
+	if err != pgx.ErrNoRows {
+		return 0, false, err
+	}
+	// Another request reset the period first, its balance is read fresh
+	err = db.QueryRow(ctx, `SELECT COALESCE(coin, 0) FROM users WHERE id = $1`, userID).Scan(&balance)
+	return balance, false, err
+}
+
+// SpendCoins debits amount in its own transaction.
+func (q *MainDB) SpendCoins(ctx context.Context, userID string, amount int, reason string, referenceID string) error {
+	tx, err := q.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := AddCoins(ctx, tx, userID, -amount, reason, referenceID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RefundCoins gives back a debit in its own transaction, see RefundCoins.
+func (q *MainDB) RefundCoins(ctx context.Context, reason string, referenceID string, refundReason string) error {
+	tx, err := q.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := RefundCoins(ctx, tx, reason, referenceID, refundReason); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// CoinCursor is the position of a ledger entry in the history, entries with
+// the same created_date are ordered by id.
+type CoinCursor struct {
+	CreatedDate time.Time
+	ID          string
+}
+
+// String encodes the cursor for the before parameter of the history.
+func (c CoinCursor) String() string {
+	return strconv.FormatInt(c.CreatedDate.UnixMicro(), 10) + "_" + c.ID
+}
+
+// ParseCoinCursor decodes a cursor written by CoinCursor.String.
+func ParseCoinCursor(value string) (CoinCursor, error) {
+	micros, id, ok := strings.Cut(value, "_")
+	n, err := strconv.ParseInt(micros, 10, 64)
+	if !ok || err != nil || id == "" {
+		return CoinCursor{}, fmt.Errorf("invalid coin cursor %q", value)
+	}
+	return CoinCursor{CreatedDate: time.UnixMicro(n).UTC(), ID: id}, nil
+}
+
+// CoinHistory returns the newest entries of the user first, starting after
+// before when it is set.
+func (q *MainDB) CoinHistory(ctx context.Context, userID string, before *CoinCursor, limit int) ([]CoinLedgerEntry, error) {
+	var beforeDate *time.Time
+	var beforeID string
+	if before != nil {
+		beforeDate, beforeID = &before.CreatedDate, before.ID
+	}
+	rows, err := q.Query(ctx, `
+		SELECT id, amount, COALESCE(balance_after, 0), reason, reference_id, created_date
+		FROM coin_ledger
+		WHERE user_id = $1 AND ($2::timestamp IS NULL OR (created_date, id) < ($2, $3))
+		ORDER BY created_date DESC, id DESC
+		LIMIT $4
+	`, userID, beforeDate, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (CoinLedgerEntry, error) {
+		var entry CoinLedgerEntry
+		err := row.Scan(&entry.ID, &entry.Amount, &entry.BalanceAfter, &entry.Reason, &entry.ReferenceID, &entry.CreatedDate)
+		return entry, err
+	})
+}
@@ -0,0 +1,124 @@
+package maindb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/lib/db/main/maindbtest"
+)
+
+func TestCoinLedger(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	if _, err := db.Exec(ctx, `INSERT INTO users (id) VALUES ('user-1')`); err != nil {
+		t.Fatal(err)
+	}
+	balance := func() int {
+		t.Helper()
+		var coin int
+		if err := db.QueryRow(ctx, `SELECT COALESCE(coin, 0) FROM users WHERE id = 'user-1'`).Scan(&coin); err != nil {
+			t.Fatal(err)
+		}
+		return coin
+	}
+
+	coins, reset, err := maindb.ResetWeeklyCoins(ctx, db, "user-1")
+	if err != nil || !reset || coins != maindb.WeeklyCoins {
+		t.Fatalf("reset = %d, %v, %v", coins, reset, err)
+	}
+	if err := db.SpendCoins(ctx, "user-1", 1, maindb.CoinReasonScan, "scan-1"); err != nil {
+		t.Fatal(err)
+	}
+	// The balance of the current period is kept
+	if coins, reset, _ := maindb.ResetWeeklyCoins(ctx, db, "user-1"); reset || coins != maindb.WeeklyCoins-1 {
+		t.Fatalf("second reset in the same week = %d, %v", coins, reset)
+	}
+	if err := db.RefundCoins(ctx, maindb.CoinReasonScan, "scan-1", maindb.CoinReasonScanRefund); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(); got != maindb.WeeklyCoins {
+		t.Fatalf("balance = %d after reset", got)
+	}
+
+	for _, id := range []string{"gen-1", "gen-2"} {
+		if err := db.SpendCoins(ctx, "user-1", 1, maindb.CoinReasonGeneration, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.SpendCoins(ctx, "user-1", 1, maindb.CoinReasonGeneration, "gen-3")
+	if !errors.Is(err, maindb.ErrInsufficientCoins) {
+		t.Fatalf("spending without coins: %v", err)
+	}
+	if got := balance(); got != 0 {
+		t.Fatalf("balance = %d after spending", got)
+	}
+
+	for range 2 {
+		if err := db.RefundCoins(ctx, maindb.CoinReasonGeneration, "gen-1", maindb.CoinReasonGenerationRefund); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Nothing was debited for gen-3
+	if err := db.RefundCoins(ctx, maindb.CoinReasonGeneration, "gen-3", maindb.CoinReasonGenerationRefund); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(); got != 1 {
+		t.Fatalf("balance = %d after refunds", got)
+	}
+
+	history, err := db.CoinHistory(ctx, "user-1", nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 6 {
+		t.Fatalf("history has %d entries, want 6", len(history))
+	}
+	var sum int
+	for _, entry := range history {
+		sum += entry.Amount
+	}
+	if sum != 1 {
+		t.Fatalf("ledger sums to %d, want the balance 1", sum)
+	}
+
+	// Pages of one entry walk the whole history once
+	seen := map[string]bool{}
+	var before *maindb.CoinCursor
+	for {
+		page, err := db.CoinHistory(ctx, "user-1", before, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		if seen[page[0].ID] {
+			t.Fatalf("entry %s returned twice", page[0].ID)
+		}
+		seen[page[0].ID] = true
+		cursor, err := maindb.ParseCoinCursor(maindb.CoinCursor{CreatedDate: page[0].CreatedDate, ID: page[0].ID}.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		before = &cursor
+	}
+	if len(seen) != len(history) {
+		t.Fatalf("paged through %d entries, want %d", len(seen), len(history))
+	}
+}
+
+func TestParseCoinCursor(t *testing.T) {
+	cursor := maindb.CoinCursor{CreatedDate: time.Date(2026, 1, 2, 3, 4, 5, 6000, time.UTC), ID: "0b6f4b8e-5a4c-4c1e-9c1e-1f1a2b3c4d5e"}
+	parsed, err := maindb.ParseCoinCursor(cursor.String())
+	if err != nil || parsed != cursor {
+		t.Fatalf("parsed %+v, %v", parsed, err)
+	}
+	for _, value := range []string{"", "1767322800", "abc_id", "1767322800_"} {
+		if _, err := maindb.ParseCoinCursor(value); err == nil {
+			t.Errorf("ParseCoinCursor(%q) succeeded", value)
+		}
+	}
+}
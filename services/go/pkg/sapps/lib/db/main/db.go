@@ -15,8 +15,18 @@ func InjectMainDB(db *connection.PostgresMainDB) *MainDB {
 	}
 }
 
+const (
+	CostReasonScan       = "scan"
+	CostReasonGeneration = "generation"
+)
+
 func (q *MainDB) InsertCost(ctx context.Context, userID string, cost float64, reason string, taskID string, ipAddress string) error {
+	return InsertCost(ctx, q, userID, cost, reason, taskID, ipAddress)
+}
+
+// InsertCost records a cost with db, which may be a transaction.
+func InsertCost(ctx context.Context, db Execer, userID string, cost float64, reason string, taskID string, ipAddress string) error {
 	query := `INSERT INTO costs (user_id, price, reason, task_id, ip_address) VALUES ($1, $2, $3, $4, $5);`
-	_, err := q.Exec(ctx, query, userID, cost, reason, taskID, ipAddress)
+	_, err := db.Exec(ctx, query, userID, cost, reason, taskID, ipAddress)
 	return err
 }
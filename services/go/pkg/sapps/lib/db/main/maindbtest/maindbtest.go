@@ -0,0 +1,80 @@
+// Package maindbtest provides a throwaway main database for tests.
+package maindbtest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"sapps/lib/connection"
+	maindb "sapps/pkg/sapps/lib/db/main"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// New creates a fresh schema from databases/face.sql on the Postgres server
+// in PGX_TEST and drops it when the test ends. The test is skipped when
+// PGX_TEST is not set.
+func New(t *testing.T) *maindb.MainDB {
+	t.Helper()
+	dsn := os.Getenv("PGX_TEST")
+	if dsn == "" {
+		t.Skip("PGX_TEST is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pool.Close()
+		if _, err := admin.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Error(err)
+		}
+		admin.Close(ctx)
+	})
+
+	if _, err := pool.Exec(ctx, schemaSQL(t)); err != nil {
+		t.Fatal(err)
+	}
+	return maindb.InjectMainDB(&connection.PostgresMainDB{Pool: pool})
+}
+
+func schemaSQL(t *testing.T) string {
+	_, file, _, _ := runtime.Caller(0)
+	path := filepath.Join(filepath.Dir(file), "../../../../../../../../databases/face.sql")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The database itself is provided by PGX_TEST
+	return strings.Replace(string(data), "create database face;", "", 1)
+}
+
+// Truncate empties the given tables between test cases.
+func Truncate(t *testing.T, db *maindb.MainDB, tables ...string) {
+	t.Helper()
+	if _, err := db.Exec(context.Background(), "TRUNCATE "+strings.Join(tables, ", ")); err != nil {
+		t.Fatal(err)
+	}
+}
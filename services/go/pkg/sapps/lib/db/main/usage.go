@@ -0,0 +1,17 @@
+package maindb
+
+import "context"
+
+// LinkUsage ties the quota use recorded in usage_events to the scan or task
+// it paid for, so the use can be taken back with ReleaseUsage.
+func LinkUsage(ctx context.Context, db Execer, eventID int64, referenceID string) error {
+	_, err := db.Exec(ctx, `UPDATE usage_events SET reference_id = $1 WHERE id = $2`, referenceID, eventID)
+	return err
+}
+
+// ReleaseUsage takes back the quota use linked to referenceID, if any, for
+// work that failed after its request was accepted.
+func ReleaseUsage(ctx context.Context, db Execer, referenceID string) error {
+	_, err := db.Exec(ctx, `DELETE FROM usage_events WHERE reference_id = $1`, referenceID)
+	return err
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"sapps/lib/connection"
+	"sapps/lib/util"
+	"sapps/pkg/sapps/constant"
+	"sapps/pkg/sapps/model"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/dig"
+)
+
+// RootAdminID is the admin authenticated with ADMIN_API_KEY, used to create
+// the first admins.
+const RootAdminID = "root"
+
+// AdminAuthMiddleware authenticates operators by the key in the admin-key
+// header. Admin keys are unrelated to user tokens.
+type AdminAuthMiddleware struct {
+	dig.In
+	PostgresMainDB *connection.PostgresMainDB
+}
+
+func (r *AdminAuthMiddleware) Handler(c *RequestContext) error {
+	adminKey := c.Get("admin-key")
+	if adminKey == "" {
+		return c.Error(StatusUnauthorized, "unauthorized")
+	}
+	if constant.ADMIN_API_KEY != "" && subtle.ConstantTimeCompare([]byte(adminKey), []byte(constant.ADMIN_API_KEY)) == 1 {
+		c.SetAdmin(&model.Admin{ID: RootAdminID, Name: RootAdminID, Role: model.AdminRoleOwner})
+		return c.Next()
+	}
+
+	var admin model.Admin
+	err := r.PostgresMainDB.QueryRow(c.UserContext(), `
+		SELECT id, name, role FROM admins WHERE key_hash = $1 AND NOT COALESCE(disabled, false)
+	`, util.HashKey(adminKey)).Scan(&admin.ID, &admin.Name, &admin.Role)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return c.Error(StatusUnauthorized, "unauthorized")
+		}
+		c.LogErr(err)
+		return c.Error(StatusInternalServerError, err.Error())
+	}
+	c.SetAdmin(&admin)
+	return c.Next()
+}
+
+// AdminRoleMiddleware rejects admins whose role does not include Role.
+type AdminRoleMiddleware struct {
+	Role string
+}
+
+func (r *AdminRoleMiddleware) Handler(c *RequestContext) error {
+	admin := c.Admin()
+	if admin == nil {
+		return c.Error(StatusUnauthorized, "unauthorized")
+	}
+	if !admin.HasRole(r.Role) {
+		return c.Error(StatusForbidden, "requires the "+r.Role+" role")
+	}
+	return c.Next()
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"sapps/lib/util"
+	"sapps/pkg/sapps/constant"
+	"sapps/pkg/sapps/lib/db/main/maindbtest"
+	"sapps/pkg/sapps/model"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// adminApp serves the admin of the request at /admin behind the auth and role
+// middlewares.
+func adminApp(auth *AdminAuthMiddleware, role string) *fiber.App {
+	app := fiber.New()
+	app.Get("/admin", HandleWrapper(auth), HandleWrapper(&AdminRoleMiddleware{Role: role}), HandleWrapper(handleFunc(func(c *RequestContext) error {
+		return c.JSON(c.Admin())
+	})))
+	return app
+}
+
+func getAdmin(t *testing.T, app *fiber.App, key string) (int, *model.Admin) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodGet, "/admin", nil)
+	if key != "" {
+		req.Header.Set("admin-key", key)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		return resp.StatusCode, nil
+	}
+	var admin model.Admin
+	if err := json.NewDecoder(resp.Body).Decode(&admin); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, &admin
+}
+
+func TestAdminRoleMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		admin *model.Admin
+		role  string
+		want  int
+	}{
+		{nil, model.AdminRoleViewer, fiber.StatusUnauthorized},
+		{&model.Admin{Role: model.AdminRoleViewer}, model.AdminRoleSupport, fiber.StatusForbidden},
+		{&model.Admin{Role: model.AdminRoleSupport}, model.AdminRoleSupport, fiber.StatusOK},
+		{&model.Admin{Role: model.AdminRoleOwner}, model.AdminRoleViewer, fiber.StatusOK},
+	} {
+		app := fiber.New()
+		app.Get("/admin", func(c *fiber.Ctx) error {
+			if tc.admin != nil {
+				NewRequestContext(c).SetAdmin(tc.admin)
+			}
+			return c.Next()
+		}, HandleWrapper(&AdminRoleMiddleware{Role: tc.role}), func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusOK)
+		})
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/admin", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("%+v on a %s route = %d, want %d", tc.admin, tc.role, resp.StatusCode, tc.want)
+		}
+	}
+}
+
+func TestAdminAuthRootKey(t *testing.T) {
+	key := constant.ADMIN_API_KEY
+	constant.ADMIN_API_KEY = "root-key"
+	t.Cleanup(func() { constant.ADMIN_API_KEY = key })
+	// The root key is checked before the database
+	app := adminApp(&AdminAuthMiddleware{}, model.AdminRoleOwner)
+
+	if code, _ := getAdmin(t, app, ""); code != fiber.StatusUnauthorized {
+		t.Fatalf("without a key = %d", code)
+	}
+	code, admin := getAdmin(t, app, "root-key")
+	if code != fiber.StatusOK || admin.ID != RootAdminID || admin.Role != model.AdminRoleOwner {
+		t.Fatalf("root key = %d, %+v", code, admin)
+	}
+}
+
+func TestAdminAuthKeys(t *testing.T) {
+	db := maindbtest.New(t)
+	_, err := db.Exec(context.Background(), `
+		INSERT INTO admins (id, name, role, key_hash, disabled) VALUES
+			('admin-1', 'Support', $1, $2, false),
+			('admin-2', 'Former', $3, $4, true)
+	`, model.AdminRoleSupport, util.HashKey("support-key"), model.AdminRoleOwner, util.HashKey("disabled-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &AdminAuthMiddleware{PostgresMainDB: db.PostgresMainDB}
+
+	code, admin := getAdmin(t, adminApp(auth, model.AdminRoleSupport), "support-key")
+	if code != fiber.StatusOK || admin.ID != "admin-1" || admin.Role != model.AdminRoleSupport {
+		t.Fatalf("support key = %d, %+v", code, admin)
+	}
+	for key, want := range map[string]int{
+		"support-key":  fiber.StatusForbidden,
+		"disabled-key": fiber.StatusUnauthorized,
+		"unknown-key":  fiber.StatusUnauthorized,
+	} {
+		if code, _ := getAdmin(t, adminApp(auth, model.AdminRoleOwner), key); code != want {
+			t.Errorf("%s on an owner route = %d, want %d", key, code, want)
+		}
+	}
+}
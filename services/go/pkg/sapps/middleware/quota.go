@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"sapps/lib/connection"
+	"sapps/pkg/sapps/quota"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/dig"
+)
+
+var StatusQuotaExceeded = NewStatus(fiber.StatusTooManyRequests, "QUOTA_EXCEEDED")
+
+type QuotaExceededStatus struct {
+	Status
+	Feature string `json:"feature"`
+	Period  string `json:"period"`
+	Limit   int    `json:"limit"`
+	ResetAt int64  `json:"reset_at"`
+}
+
+// QuotaMiddleware counts uses of expensive features in Postgres and rejects
+// users over the limit of their tier or their own limit in user_quotas. It
+// runs after VerifyAuthMiddleware.
+type QuotaMiddleware struct {
+	dig.In
+	PostgresMainDB *connection.PostgresMainDB
+	Limits         quota.Limits
+}
+
+// For returns the handler enforcing the quota of feature.
+func (r *QuotaMiddleware) For(feature string) Handle {
+	return &quotaHandler{QuotaMiddleware: r, feature: feature}
+}
+
+type quotaHandler struct {
+	*QuotaMiddleware
+	feature string
+}
+
+func (r *quotaHandler) Handler(c *RequestContext) error {
+	eventID, exceeded, err := r.use(c.UserContext(), c.UserID(), r.Limits.For(r.feature, c.User().PremiumType))
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(StatusInternalServerError, err.Error())
+	}
+	if exceeded != nil {
+		status := StatusQuotaExceeded
+		status.Message = "quota exceeded, try again later"
+		retryAfter := int(math.Ceil(time.Until(exceeded.ResetAt).Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(retryAfter, 1)))
+		return c.Status(status.Code).JSON(struct {
+			Error QuotaExceededStatus `json:"error"`
+		}{
+			Error: QuotaExceededStatus{
+				Status:  status,
+				Feature: r.feature,
+				Period:  exceeded.Period,
+				Limit:   exceeded.Limit,
+				ResetAt: exceeded.ResetAt.Unix(),
+			},
+		})
+	}
+	if eventID != nil {
+		c.SetUsageEventID(*eventID)
+	}
+	if err := c.Next(); err != nil || c.Response().StatusCode() >= fiber.StatusBadRequest || c.usageReleased() {
+		// Only successful requests count, the use is taken back once the
+		// handler has failed or released it
+		if eventID != nil {
+			r.release(c, *eventID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *quotaHandler) release(c *RequestContext, eventID int64) {
+	_, err := r.PostgresMainDB.Exec(context.WithoutCancel(c.UserContext()), `DELETE FROM usage_events WHERE id = $1`, eventID)
+	if err != nil {
+		c.LogErr(err)
+	}
+}
+
+// use records one use of the feature unless it exceeds the limit, which the
+// user's own row in user_quotas replaces. It returns the usage_events id of
+// the use, nil when the limit is unlimited. The use is recorded before the
+// request is handled so concurrent requests see it.
+func (r *quotaHandler) use(ctx context.Context, userID string, limit quota.Limit) (*int64, *quota.Exceeded, error) {
+	now := time.Now()
+	tx, err := r.PostgresMainDB.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Concurrent requests of the user wait here, so they cannot all pass a
+	// check that only one of them fits
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || ':' || $2))`, userID, r.feature); err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(hourly_limit, 0), COALESCE(daily_limit, 0) FROM user_quotas WHERE user_id = $1 AND feature = $2
+	`, userID, r.feature).Scan(&limit.Hourly, &limit.Daily)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, nil, err
+	}
+	if limit.Unlimited() {
+		return nil, nil, nil
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT created_date FROM usage_events WHERE user_id = $1 AND feature = $2 AND created_date > $3
+	`, userID, r.feature, now.Add(-24*time.Hour))
+	if err != nil {
+		return nil, nil, err
+	}
+	usage, err := pgx.CollectRows(rows, pgx.RowTo[time.Time])
+	if err != nil {
+		return nil, nil, err
+	}
+	if exceeded := quota.Check(usage, limit, now); exceeded != nil {
+		return nil, exceeded, nil
+	}
+
+	var eventID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO usage_events (user_id, feature, created_date) VALUES ($1, $2, $3) RETURNING id
+	`, userID, r.feature, now).Scan(&eventID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &eventID, nil, tx.Commit(ctx)
+}
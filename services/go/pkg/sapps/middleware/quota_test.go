@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"sapps/pkg/sapps/lib/db/main/maindbtest"
+	"sapps/pkg/sapps/model"
+	"sapps/pkg/sapps/quota"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestQuotaCountsSuccessfulRequests(t *testing.T) {
+	db := maindbtest.New(t)
+	limits, err := quota.Parse("scan:free=1/h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	middleware := &QuotaMiddleware{PostgresMainDB: db.PostgresMainDB, Limits: limits}
+
+	app := fiber.New()
+	app.Post("/scans", func(c *fiber.Ctx) error {
+		ctx := NewRequestContext(c)
+		ctx.SetUserID("user-1")
+		ctx.SetUser(&model.User{})
+		return c.Next()
+	}, HandleWrapper(middleware.For(quota.FeatureScan)), HandleWrapper(handleFunc(func(c *RequestContext) error {
+		if c.UsageEventID() == nil {
+			return c.Error(StatusInternalServerError, "use was not recorded")
+		}
+		switch c.Query("fail") {
+		case "status":
+			return c.Error(StatusBadRequest, "invalid request body")
+		case "error":
+			return fiber.ErrInternalServerError
+		case "release":
+			// Answered with an existing result
+			c.ReleaseUsage()
+		}
+		return c.SendStatus(fiber.StatusOK)
+	})))
+
+	for _, step := range []struct {
+		query string
+		code  int
+	}{
+		{"?fail=status", fiber.StatusBadRequest},
+		{"?fail=error", fiber.StatusInternalServerError},
+		{"?fail=release", fiber.StatusOK},
+		{"", fiber.StatusOK},
+		{"", fiber.StatusTooManyRequests},
+	} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/scans"+step.query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != step.code {
+			t.Fatalf("POST /scans%s = %d, want %d", step.query, resp.StatusCode, step.code)
+		}
+	}
+}
+
+type handleFunc func(c *RequestContext) error
+
+func (f handleFunc) Handler(c *RequestContext) error {
+	return f(c)
+}
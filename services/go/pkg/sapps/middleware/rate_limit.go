@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"math"
+	"sapps/pkg/sapps/ratelimit"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/dig"
+)
+
+var StatusRateLimited = NewStatus(fiber.StatusTooManyRequests, "RATE_LIMITED")
+
+type RateLimitMiddleware struct {
+	dig.In
+	Store ratelimit.Store
+}
+
+// For returns the handler enforcing policy. Keys that are missing from the
+// request, such as the user on routes without auth, are not limited.
+func (r *RateLimitMiddleware) For(policy ratelimit.Policy) Handle {
+	return &rateLimitHandler{RateLimitMiddleware: r, policy: policy}
+}
+
+type rateLimitHandler struct {
+	*RateLimitMiddleware
+	policy ratelimit.Policy
+}
+
+func (r *rateLimitHandler) Handler(c *RequestContext) error {
+	var keys []string
+	for _, keyKind := range r.policy.Keys {
+		if value := rateLimitKey(c, keyKind); value != "" {
+			keys = append(keys, r.policy.Name+":"+keyKind+":"+value)
+		}
+	}
+	if len(keys) == 0 {
+		return c.Next()
+	}
+	// Tokens are taken from all buckets or none, a request rejected by one
+	// key does not use up the others
+	allowed, retryAfter, err := r.Store.Take(c.UserContext(), keys, r.policy.Rate, r.policy.Burst)
+	if err != nil {
+		// Rate limiting is best effort, a store failure does not take the
+		// route down with it
+		c.LogErr(err)
+		return c.Next()
+	}
+	if !allowed {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(int(math.Ceil(retryAfter.Seconds())), 1)))
+		return c.Error(StatusRateLimited, "too many requests, try again later")
+	}
+	return c.Next()
+}
+
+// rateLimitKey returns the value of keyKind for the request. c.IP() is the
+// proxy header only for requests from trusted proxies, a client cannot pick
+// its own IP bucket.
+func rateLimitKey(c *RequestContext, keyKind string) string {
+	switch keyKind {
+	case ratelimit.KeyUser:
+		return c.UserID()
+	case ratelimit.KeyIP:
+		return c.IP()
+	case ratelimit.KeyDevice:
+		return c.DeviceID()
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"sapps/pkg/sapps/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRateLimitIgnoresUntrustedProxyHeader(t *testing.T) {
+	middleware := &RateLimitMiddleware{Store: ratelimit.NewMemoryStore()}
+	app := fiber.New(fiber.Config{
+		EnableTrustedProxyCheck: true,
+		ProxyHeader:             "CF-Connecting-IP",
+	})
+	app.Post("/login", HandleWrapper(middleware.For(ratelimit.PerMinute("login", 1, 1, ratelimit.KeyIP))), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	// Every request claims another IP, without a trusted proxy they all
+	// share the bucket of the connection
+	for i, want := range []int{fiber.StatusOK, fiber.StatusTooManyRequests} {
+		req := httptest.NewRequest(fiber.MethodPost, "/login", nil)
+		req.Header.Set("CF-Connecting-IP", "203.0.113."+strconv.Itoa(i+1))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Fatalf("request %d = %d, want %d", i, resp.StatusCode, want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"encoding/json"
 	"sapps/pkg/sapps/model"
 	"strconv"
 
@@ -40,6 +41,21 @@ func (c *RequestContext) UserID() string {
 	return userID
 }
 
+// DeviceID is the device-id header, or the device_id of a JSON body such as
+// the one of /login/firebase.
+func (c *RequestContext) DeviceID() string {
+	if deviceID := c.Get("device-id"); deviceID != "" {
+		return deviceID
+	}
+	var body struct {
+		DeviceID string `json:"device_id"`
+	}
+	if json.Unmarshal(c.Body(), &body) != nil {
+		return ""
+	}
+	return body.DeviceID
+}
+
 func (c *RequestContext) Language() *string {
 	language := c.Get("language")
 	if language == "" {
@@ -95,3 +111,41 @@ func (c *RequestContext) User() *model.User {
 func (c *RequestContext) SetUser(user *model.User) {
 	_ = c.Locals("user", user)
 }
+
+func (c *RequestContext) Admin() *model.Admin {
+	admin, ok := c.Locals("admin").(*model.Admin)
+	if !ok {
+		return nil
+	}
+	return admin
+}
+
+func (c *RequestContext) SetAdmin(admin *model.Admin) {
+	_ = c.Locals("admin", admin)
+}
+
+// UsageEventID is the usage_events id of the quota use the request counts
+// as, nil when the feature is not limited for the user.
+func (c *RequestContext) UsageEventID() *int64 {
+	eventID, ok := c.Locals("usage_event_id").(int64)
+	if !ok {
+		return nil
+	}
+	return &eventID
+}
+
+func (c *RequestContext) SetUsageEventID(eventID int64) {
+	_ = c.Locals("usage_event_id", eventID)
+}
+
+// ReleaseUsage makes the quota middleware take back the use of a request
+// that succeeded without doing the limited work, such as one answered with
+// an existing result.
+func (c *RequestContext) ReleaseUsage() {
+	_ = c.Locals("release_usage", true)
+}
+
+func (c *RequestContext) usageReleased() bool {
+	released, _ := c.Locals("release_usage").(bool)
+	return released
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 	"sapps/lib/connection"
+	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/model"
 	"time"
 
@@ -48,14 +49,6 @@ WHERE u.id = $1 AND u.session = $2`, userID, c.Session()).Scan(
 	run := func(id string, ip string, country string, ctx context.Context) {
 		_, err := r.PostgresMainDB.Exec(ctx, `UPDATE users 
 SET last_online = NOW(), 
-coin = case
-WHEN coin_reset_date is null or coin_reset_date < NOW() THEN 2
-ELSE coin
-END,
-coin_reset_date = case
-WHEN coin_reset_date is null or coin_reset_date < NOW() THEN NOW() + INTERVAL '7 day'
-ELSE coin_reset_date
-END,
 language = case when $2::text is null then language else $2 end,
 ip_address = case when $3::text is null or $3::text = '' then ip_address else $3 end,
 country = case when $4::text is null or $4::text = '' or $4::text = 'XX' or $4::text = 'T1' then country else $4 end,
@@ -67,8 +60,12 @@ WHERE id = $1`, id, language, ip, country, buildNumber, store)
 		}
 	}
 	if coinResetDate == nil || time.Now().After(*coinResetDate) {
+		if balance, _, err := maindb.ResetWeeklyCoins(ctx, r.PostgresMainDB, userID); err != nil {
+			c.LogErr(err)
+		} else {
+			user.Coin = balance
+		}
 		run(userID, c.Get("CF-Connecting-IP"), c.Get("CF-IPCountry"), ctx)
-		user.Coin = 2
 	} else {
 		go run(userID, c.Get("CF-Connecting-IP"), c.Get("CF-IPCountry"), context.Background())
 	}
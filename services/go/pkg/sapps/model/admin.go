@@ -0,0 +1,32 @@
+package model
+
+const (
+	// Read only access to users and analytics
+	AdminRoleViewer = "viewer"
+	// Can also change premium, coins and debug of users
+	AdminRoleSupport = "support"
+	// Can also manage admins and read the audit log
+	AdminRoleOwner = "owner"
+)
+
+var adminRoleRanks = map[string]int{
+	AdminRoleViewer:  1,
+	AdminRoleSupport: 2,
+	AdminRoleOwner:   3,
+}
+
+type Admin struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+func ValidAdminRole(role string) bool {
+	_, ok := adminRoleRanks[role]
+	return ok
+}
+
+// HasRole reports whether the admin's role includes the permissions of role.
+func (a *Admin) HasRole(role string) bool {
+	return adminRoleRanks[a.Role] >= adminRoleRanks[role] && adminRoleRanks[role] > 0
+}
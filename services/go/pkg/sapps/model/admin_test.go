@@ -0,0 +1,26 @@
+package model
+
+import "testing"
+
+func TestAdminHasRole(t *testing.T) {
+	for _, tc := range []struct {
+		admin string
+		role  string
+		want  bool
+	}{
+		{AdminRoleViewer, AdminRoleViewer, true},
+		{AdminRoleViewer, AdminRoleSupport, false},
+		{AdminRoleSupport, AdminRoleViewer, true},
+		{AdminRoleSupport, AdminRoleOwner, false},
+		{AdminRoleOwner, AdminRoleSupport, true},
+		{"", AdminRoleViewer, false},
+		{"superuser", AdminRoleViewer, false},
+		// An unknown required role is granted to nobody
+		{AdminRoleOwner, "superuser", false},
+	} {
+		admin := &Admin{Role: tc.admin}
+		if got := admin.HasRole(tc.role); got != tc.want {
+			t.Errorf("%q.HasRole(%q) = %v, want %v", tc.admin, tc.role, got, tc.want)
+		}
+	}
+}
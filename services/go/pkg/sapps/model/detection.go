@@ -14,14 +14,43 @@ type DetectionBreakdown struct {
 	SentenceAnalysis []SentenceAnalysis `json:"sentence_analysis"`
 }
 
+// ServiceResult is the answer of one provider. AIProbability is null and
+// Error says why when the provider failed.
+type ServiceResult struct {
+	AIProbability *float64 `json:"ai_probability"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// ServiceResults has an entry for every configured provider, providers that
+// are not configured are omitted.
 type ServiceResults struct {
-	GPTZero   float64 `json:"GPTZero"`
-	Writer    float64 `json:"Writer"`
-	QuillBot  float64 `json:"QuillBot"`
-	Copyleaks float64 `json:"Copyleaks"`
-	Sapling   float64 `json:"Sapling"`
-	Grammarly float64 `json:"Grammarly"`
-	ZeroGPT   float64 `json:"ZeroGPT"`
+	GPTZero   *ServiceResult `json:"GPTZero,omitempty"`
+	Writer    *ServiceResult `json:"Writer,omitempty"`
+	QuillBot  *ServiceResult `json:"QuillBot,omitempty"`
+	Copyleaks *ServiceResult `json:"Copyleaks,omitempty"`
+	Sapling   *ServiceResult `json:"Sapling,omitempty"`
+	Grammarly *ServiceResult `json:"Grammarly,omitempty"`
+	ZeroGPT   *ServiceResult `json:"ZeroGPT,omitempty"`
+}
+
+// Each calls fn with every configured provider in the order of the fields.
+func (r ServiceResults) Each(fn func(service string, result *ServiceResult)) {
+	for _, entry := range []struct {
+		service string
+		result  *ServiceResult
+	}{
+		{"GPTZero", r.GPTZero},
+		{"Writer", r.Writer},
+		{"QuillBot", r.QuillBot},
+		{"Copyleaks", r.Copyleaks},
+		{"Sapling", r.Sapling},
+		{"Grammarly", r.Grammarly},
+		{"ZeroGPT", r.ZeroGPT},
+	} {
+		if entry.result != nil {
+			fn(entry.service, entry.result)
+		}
+	}
 }
 
 type DetectionResponse struct {
@@ -0,0 +1,25 @@
+package model
+
+// ScanData is stored in scans.data. Scans made before feedback was added
+// only have the ratings.
+type ScanData struct {
+	FaceOverallRating int `json:"face_overall_rating"`
+	FemininityRating  int `json:"femininity_rating"`
+	MasculinityRating int `json:"masculinity_rating"`
+	FaceRating        int `json:"face_rating"`
+	EyesRating        int `json:"eyes_rating"`
+	JawlineRating     int `json:"jawline_rating"`
+	SkinRating        int `json:"skin_rating"`
+
+	Feedback []ScanFeedback `json:"feedback,omitempty"`
+	Tips     []string       `json:"tips,omitempty"`
+	// Language the feedback and tips are written in
+	Language string `json:"language,omitempty"`
+}
+
+// ScanFeedback explains one rating, Feature is the rating's json name.
+type ScanFeedback struct {
+	Feature         string `json:"feature"`
+	Explanation     string `json:"explanation"`
+	PotentialRating int    `json:"potential_rating"`
+}
@@ -0,0 +1,250 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"sapps/lib/util"
+	maindb "sapps/pkg/sapps/lib/db/main"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+const (
+	minBackoff = 5 * time.Second
+	maxBackoff = 5 * time.Minute
+	// Running jobs whose worker died are picked up again after this long
+	staleLockTimeout = 10 * time.Minute
+)
+
+// ErrStaleLock is passed to the failure handler of a job whose worker died
+// during its last attempt.
+var ErrStaleLock = errors.New("job worker stopped during the last attempt")
+
+type Job struct {
+	ID          string
+	Kind        string
+	Payload     json.RawMessage
+	Attempts    int
+	MaxAttempts int
+}
+
+// Handler processes a single job. Returning an error retries the job with
+// backoff until it runs out of attempts; wrap it with Permanent to fail the
+// job immediately.
+type Handler func(ctx context.Context, job Job) error
+
+// FailureHandler is called once when a job has failed for good.
+type FailureHandler func(ctx context.Context, job Job, err error)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Execer is satisfied by both the pool and pgx.Tx, so jobs can be enqueued in
+// the same transaction as the rows they refer to.
+type Execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+func Enqueue(ctx context.Context, db Execer, kind string, payload any, maxAttempts int) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `
+		INSERT INTO jobs (kind, payload, status, max_attempts) VALUES ($1, $2, $3, $4)
+	`, kind, payloadJSON, StatusQueued, maxAttempts)
+	return err
+}
+
+type registration struct {
+	handler   Handler
+	onFailure FailureHandler
+}
+
+type Queue struct {
+	db       *maindb.MainDB
+	handlers map[string]registration
+}
+
+func New(db *maindb.MainDB) *Queue {
+	return &Queue{
+		db:       db,
+		handlers: map[string]registration{},
+	}
+}
+
+func (q *Queue) Register(kind string, handler Handler, onFailure FailureHandler) {
+	q.handlers[kind] = registration{handler: handler, onFailure: onFailure}
+}
+
+// Run starts the workers and blocks until ctx is cancelled.
+func (q *Queue) Run(ctx context.Context, workers int, pollInterval time.Duration) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.worker(ctx, pollInterval)
+		}()
+	}
+	log.Printf("JOB QUEUE STARTED WITH %d WORKERS", workers)
+	wg.Wait()
+}
+
+func (q *Queue) worker(ctx context.Context, pollInterval time.Duration) {
+	for {
+		worked, err := q.work(ctx)
+		if err != nil {
+			util.LogErr(err)
+		}
+		if worked {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (q *Queue) kinds() []string {
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+// work claims and runs one job. It reports whether a job was found.
+func (q *Queue) work(ctx context.Context) (bool, error) {
+	if failed, err := q.failExhausted(ctx); failed || err != nil {
+		return failed, err
+	}
+
+	var job Job
+	err := q.db.QueryRow(ctx, `
+		UPDATE jobs SET status = $1, locked_at = NOW(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE kind = ANY($2)
+			AND ((status = $3 AND run_at <= NOW()) OR (status = $1 AND locked_at < NOW() - make_interval(secs => $4)))
+			AND attempts < max_attempts
+			ORDER BY run_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, kind, payload, attempts, max_attempts
+	`, StatusRunning, q.kinds(), StatusQueued, staleLockTimeout.Seconds()).Scan(&job.ID, &job.Kind, &job.Payload, &job.Attempts, &job.MaxAttempts)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	registration := q.handlers[job.Kind]
+	jobErr := q.run(ctx, registration.handler, job)
+	if jobErr == nil {
+		_, err = q.db.Exec(ctx, `
+			UPDATE jobs SET status = $1, finished_at = NOW(), locked_at = NULL WHERE id = $2
+		`, StatusDone, job.ID)
+		return true, err
+	}
+
+	var permanent *permanentError
+	if errors.As(jobErr, &permanent) || job.Attempts >= job.MaxAttempts {
+		util.LogErr(fmt.Errorf("job %s (%s) failed: %w", job.ID, job.Kind, jobErr))
+		_, err = q.db.Exec(ctx, `
+			UPDATE jobs SET status = $1, last_error = $2, finished_at = NOW(), locked_at = NULL WHERE id = $3
+		`, StatusFailed, jobErr.Error(), job.ID)
+		if registration.onFailure != nil {
+			registration.onFailure(ctx, job, jobErr)
+		}
+		return true, err
+	}
+
+	_, err = q.db.Exec(ctx, `
+		UPDATE jobs SET status = $1, last_error = $2, run_at = NOW() + make_interval(secs => $3), locked_at = NULL WHERE id = $4
+	`, StatusQueued, jobErr.Error(), Backoff(job.Attempts).Seconds(), job.ID)
+	return true, err
+}
+
+// failExhausted fails one stale job whose worker died on its last attempt,
+// instead of running it past max_attempts. It reports whether a job was found.
+func (q *Queue) failExhausted(ctx context.Context) (bool, error) {
+	var job Job
+	err := q.db.QueryRow(ctx, `
+		UPDATE jobs SET status = $1, last_error = $2, finished_at = NOW(), locked_at = NULL
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE kind = ANY($3)
+			AND status = $4 AND locked_at < NOW() - make_interval(secs => $5)
+			AND attempts >= max_attempts
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, kind, payload, attempts, max_attempts
+	`, StatusFailed, ErrStaleLock.Error(), q.kinds(), StatusRunning, staleLockTimeout.Seconds()).Scan(&job.ID, &job.Kind, &job.Payload, &job.Attempts, &job.MaxAttempts)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	util.LogErr(fmt.Errorf("job %s (%s) failed: %w", job.ID, job.Kind, ErrStaleLock))
+	if registration := q.handlers[job.Kind]; registration.onFailure != nil {
+		registration.onFailure(ctx, job, ErrStaleLock)
+	}
+	return true, nil
+}
+
+func (q *Queue) run(ctx context.Context, handler Handler, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// Backoff returns the delay before the next attempt after the given number of
+// attempts, doubling from minBackoff up to maxBackoff.
+func Backoff(attempts int) time.Duration {
+	backoff := minBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return backoff
+}
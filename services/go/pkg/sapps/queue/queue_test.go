@@ -0,0 +1,231 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/lib/db/main/maindbtest"
+)
+
+func TestBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{6, 160 * time.Second},
+		{7, maxBackoff},
+		{50, maxBackoff},
+	} {
+		if got := Backoff(tc.attempts); got != tc.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+type jobRow struct {
+	status    string
+	attempts  int
+	lastError *string
+	due       bool
+}
+
+func loadJob(t *testing.T, db *maindb.MainDB) jobRow {
+	t.Helper()
+	var row jobRow
+	err := db.QueryRow(context.Background(), `
+		SELECT status, attempts, last_error, run_at <= NOW() FROM jobs
+	`).Scan(&row.status, &row.attempts, &row.lastError, &row.due)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return row
+}
+
+func TestWorkRetriesWithBackoff(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	q := New(db)
+	var runs int
+	q.Register("test", func(ctx context.Context, job Job) error {
+		runs++
+		return errors.New("try again")
+	}, func(ctx context.Context, job Job, err error) {
+		t.Errorf("job %s failed for good after %d attempts", job.ID, job.Attempts)
+	})
+
+	if err := Enqueue(ctx, db, "test", map[string]string{"id": "1"}, 3); err != nil {
+		t.Fatal(err)
+	}
+	worked, err := q.work(ctx)
+	if err != nil || !worked {
+		t.Fatalf("work = %v, %v", worked, err)
+	}
+	row := loadJob(t, db)
+	if row.status != StatusQueued || row.attempts != 1 || row.lastError == nil || *row.lastError != "try again" {
+		t.Fatalf("after a failed attempt: %+v", row)
+	}
+	if row.due {
+		t.Fatal("retry is due immediately, want backoff")
+	}
+	// The job waits out its backoff
+	if worked, err := q.work(ctx); err != nil || worked {
+		t.Fatalf("work during backoff = %v, %v", worked, err)
+	}
+	if runs != 1 {
+		t.Fatalf("handler ran %d times, want 1", runs)
+	}
+}
+
+func TestWorkFailsAfterLastAttempt(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	q := New(db)
+	var failed []error
+	q.Register("test", func(ctx context.Context, job Job) error {
+		return errors.New("still broken")
+	}, func(ctx context.Context, job Job, err error) {
+		failed = append(failed, err)
+	})
+
+	if err := Enqueue(ctx, db, "test", nil, 2); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		if worked, err := q.work(ctx); err != nil || !worked {
+			t.Fatalf("attempt %d: work = %v, %v", attempt, worked, err)
+		}
+		// Skip the backoff
+		if _, err := db.Exec(ctx, `UPDATE jobs SET run_at = NOW()`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	row := loadJob(t, db)
+	if row.status != StatusFailed || row.attempts != 2 {
+		t.Fatalf("after the last attempt: %+v", row)
+	}
+	if len(failed) != 1 || failed[0].Error() != "still broken" {
+		t.Fatalf("failure handler got %v", failed)
+	}
+	if worked, err := q.work(ctx); err != nil || worked {
+		t.Fatalf("work after failure = %v, %v", worked, err)
+	}
+}
+
+func TestWorkPermanentFailure(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	q := New(db)
+	cause := errors.New("bad payload")
+	var failed []error
+	q.Register("test", func(ctx context.Context, job Job) error {
+		return Permanent(cause)
+	}, func(ctx context.Context, job Job, err error) {
+		failed = append(failed, err)
+	})
+
+	if err := Enqueue(ctx, db, "test", nil, 5); err != nil {
+		t.Fatal(err)
+	}
+	if worked, err := q.work(ctx); err != nil || !worked {
+		t.Fatalf("work = %v, %v", worked, err)
+	}
+	row := loadJob(t, db)
+	if row.status != StatusFailed || row.attempts != 1 || row.lastError == nil || *row.lastError != "bad payload" {
+		t.Fatalf("after a permanent error: %+v", row)
+	}
+	if len(failed) != 1 || !errors.Is(failed[0], cause) {
+		t.Fatalf("failure handler got %v", failed)
+	}
+}
+
+func TestWorkSkipsLockedJobs(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	q := New(db)
+	var runs int
+	q.Register("test", func(ctx context.Context, job Job) error {
+		runs++
+		return nil
+	}, nil)
+
+	if err := Enqueue(ctx, db, "test", nil, 5); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(ctx, `SELECT id FROM jobs FOR UPDATE`); err != nil {
+		t.Fatal(err)
+	}
+	// Another worker holds the row, so this one finds nothing instead of waiting
+	if worked, err := q.work(ctx); err != nil || worked {
+		t.Fatalf("work on a locked job = %v, %v", worked, err)
+	}
+	if err := tx.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if worked, err := q.work(ctx); err != nil || !worked {
+		t.Fatalf("work = %v, %v", worked, err)
+	}
+	if row := loadJob(t, db); row.status != StatusDone || runs != 1 {
+		t.Fatalf("after running: %+v, %d runs", row, runs)
+	}
+}
+
+func TestWorkReclaimsStaleJobs(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	q := New(db)
+	var runs []int
+	var failed []error
+	q.Register("test", func(ctx context.Context, job Job) error {
+		runs = append(runs, job.Attempts)
+		return nil
+	}, func(ctx context.Context, job Job, err error) {
+		failed = append(failed, err)
+	})
+	stale := func(attempts int) {
+		t.Helper()
+		maindbtest.Truncate(t, db, "jobs")
+		if err := Enqueue(ctx, db, "test", nil, 3); err != nil {
+			t.Fatal(err)
+		}
+		_, err := db.Exec(ctx, `
+			UPDATE jobs SET status = $1, attempts = $2, locked_at = NOW() - make_interval(secs => $3)
+		`, StatusRunning, attempts, (staleLockTimeout + time.Minute).Seconds())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A worker died with attempts left, so the job runs again
+	stale(1)
+	if worked, err := q.work(ctx); err != nil || !worked {
+		t.Fatalf("work = %v, %v", worked, err)
+	}
+	if row := loadJob(t, db); row.status != StatusDone || len(runs) != 1 || runs[0] != 2 {
+		t.Fatalf("reclaimed job: %+v, runs %v", row, runs)
+	}
+
+	// A worker died on the last attempt, so the job fails without running
+	stale(3)
+	if worked, err := q.work(ctx); err != nil || !worked {
+		t.Fatalf("work = %v, %v", worked, err)
+	}
+	row := loadJob(t, db)
+	if row.status != StatusFailed || row.attempts != 3 || len(runs) != 1 {
+		t.Fatalf("exhausted stale job: %+v, runs %v", row, runs)
+	}
+	if len(failed) != 1 || !errors.Is(failed[0], ErrStaleLock) {
+		t.Fatalf("failure handler got %v", failed)
+	}
+}
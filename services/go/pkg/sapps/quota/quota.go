@@ -0,0 +1,142 @@
+package quota
+
+import (
+	"fmt"
+	"sapps/pkg/sapps/constant"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FeatureScan       = "scan"
+	FeatureGeneration = "generation"
+)
+
+const (
+	// Tier of users without premium
+	TierFree = "free"
+	// Tier of premium users whose premium type has no limits of its own
+	TierPremium = "premium"
+)
+
+const (
+	PeriodHour = "hour"
+	PeriodDay  = "day"
+)
+
+// Limit is the number of uses allowed in any hour and any day, zero means
+// unlimited.
+type Limit struct {
+	Hourly int `json:"hourly"`
+	Daily  int `json:"daily"`
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Hourly <= 0 && l.Daily <= 0
+}
+
+// Limits holds the limit of every tier by feature.
+type Limits map[string]map[string]Limit
+
+// InjectLimits reads the limits from QUOTA_LIMITS.
+func InjectLimits() (Limits, error) {
+	return Parse(constant.QUOTA_LIMITS)
+}
+
+// Parse reads limits written as feature:tier=hourly/h,daily/d separated by
+// semicolons, e.g. "scan:free=5/h,20/d;scan:premium=30/h". A tier is free,
+// premium or a premium type such as sappsr_pro_a_1w.
+func Parse(value string) (Limits, error) {
+	limits := Limits{}
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, periods, ok := strings.Cut(entry, "=")
+		feature, tier, ok2 := strings.Cut(key, ":")
+		if !ok || !ok2 || feature == "" || tier == "" {
+			return nil, fmt.Errorf("invalid quota %q, expected feature:tier=N/h,N/d", entry)
+		}
+		var limit Limit
+		for _, period := range strings.Split(periods, ",") {
+			count, unit, ok := strings.Cut(strings.TrimSpace(period), "/")
+			n, err := strconv.Atoi(count)
+			if !ok || err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid quota period %q in %q", period, entry)
+			}
+			switch unit {
+			case "h":
+				limit.Hourly = n
+			case "d":
+				limit.Daily = n
+			default:
+				return nil, fmt.Errorf("invalid quota unit %q in %q, expected h or d", unit, entry)
+			}
+		}
+		if limits[feature] == nil {
+			limits[feature] = map[string]Limit{}
+		}
+		limits[feature][tier] = limit
+	}
+	return limits, nil
+}
+
+// For returns the limit of feature for a user with premiumType, which is nil
+// for users without premium.
+func (l Limits) For(feature string, premiumType *string) Limit {
+	tiers := l[feature]
+	if premiumType == nil {
+		return tiers[TierFree]
+	}
+	if limit, ok := tiers[*premiumType]; ok {
+		return limit
+	}
+	return tiers[TierPremium]
+}
+
+// Exceeded describes a full quota window.
+type Exceeded struct {
+	Period  string
+	Limit   int
+	ResetAt time.Time
+}
+
+// Check reports whether one more use at now fits the limit given the times
+// of earlier uses. Windows are sliding, a window frees up once enough of its
+// uses are older than the window length. When both windows are full the one
+// that frees up later is returned.
+func Check(usage []time.Time, limit Limit, now time.Time) *Exceeded {
+	var exceeded *Exceeded
+	for _, window := range []struct {
+		period string
+		limit  int
+		length time.Duration
+	}{
+		{PeriodHour, limit.Hourly, time.Hour},
+		{PeriodDay, limit.Daily, 24 * time.Hour},
+	} {
+		if window.limit <= 0 {
+			continue
+		}
+		var inWindow []time.Time
+		for _, t := range usage {
+			if t.After(now.Add(-window.length)) {
+				inWindow = append(inWindow, t)
+			}
+		}
+		if len(inWindow) < window.limit {
+			continue
+		}
+		sort.Slice(inWindow, func(i, j int) bool {
+			return inWindow[i].Before(inWindow[j])
+		})
+		resetAt := inWindow[len(inWindow)-window.limit].Add(window.length)
+		if exceeded == nil || resetAt.After(exceeded.ResetAt) {
+			exceeded = &Exceeded{Period: window.period, Limit: window.limit, ResetAt: resetAt}
+		}
+	}
+	return exceeded
+}
@@ -0,0 +1,58 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	limits, err := Parse("scan:free=5/h,20/d; scan:sappsr_pro_a_1w=10/h ;generation:premium=3/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	premiumType := "sappsr_pro_a_1w"
+	otherType := "sappsr_pro_a_1m"
+	for _, tc := range []struct {
+		feature     string
+		premiumType *string
+		want        Limit
+	}{
+		{FeatureScan, nil, Limit{Hourly: 5, Daily: 20}},
+		{FeatureScan, &premiumType, Limit{Hourly: 10}},
+		{FeatureScan, &otherType, Limit{}},
+		{FeatureGeneration, &otherType, Limit{Daily: 3}},
+		{FeatureGeneration, nil, Limit{}},
+	} {
+		if got := limits.For(tc.feature, tc.premiumType); got != tc.want {
+			t.Errorf("For(%s, %v) = %+v, want %+v", tc.feature, tc.premiumType, got, tc.want)
+		}
+	}
+
+	for _, invalid := range []string{"scan=5/h", "scan:free=5/w", "scan:free=-1/h", "scan:free"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("Parse(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+	usage := []time.Time{ago(30 * time.Minute), ago(10 * time.Minute), ago(20 * time.Hour), ago(5 * time.Hour)}
+
+	if exceeded := Check(usage, Limit{Hourly: 3, Daily: 5}, now); exceeded != nil {
+		t.Fatalf("4 uses exceeded 3/h 5/d: %+v", exceeded)
+	}
+
+	exceeded := Check(usage, Limit{Hourly: 2}, now)
+	if exceeded == nil || exceeded.Period != PeriodHour || !exceeded.ResetAt.Equal(ago(30*time.Minute).Add(time.Hour)) {
+		t.Fatalf("unexpected hourly result %+v", exceeded)
+	}
+
+	// The day frees up when the use from 20 hours ago leaves the window,
+	// which is later than the hour
+	exceeded = Check(usage, Limit{Hourly: 2, Daily: 4}, now)
+	if exceeded == nil || exceeded.Period != PeriodDay || !exceeded.ResetAt.Equal(ago(20*time.Hour).Add(24*time.Hour)) {
+		t.Fatalf("unexpected daily result %+v", exceeded)
+	}
+}
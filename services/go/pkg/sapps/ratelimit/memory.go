@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const memorySweepInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	rate      float64
+	burst     int
+}
+
+// MemoryStore keeps buckets in process, limits apply per instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, keys []string, rate float64, burst int) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	var retryAfter time.Duration
+	for _, key := range keys {
+		b, ok := s.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(burst), updatedAt: now}
+			s.buckets[key] = b
+		}
+		b.tokens = refill(b.tokens, now.Sub(b.updatedAt), rate, burst)
+		b.updatedAt = now
+		b.rate, b.burst = rate, burst
+		if b.tokens < 1 {
+			retryAfter = max(retryAfter, wait(b.tokens, rate))
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter, nil
+	}
+	for _, key := range keys {
+		s.buckets[key].tokens--
+	}
+	return true, 0, nil
+}
+
+// sweep drops buckets that have refilled completely, they are the same as
+// missing ones.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if refill(b.tokens, now.Sub(b.updatedAt), b.rate, b.burst) >= float64(b.burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	policy := PerMinute("test", 60, 2, KeyUser)
+	take := func(key string) (bool, time.Duration) {
+		t.Helper()
+		allowed, retryAfter, err := store.Take(context.Background(), []string{key}, policy.Rate, policy.Burst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed, retryAfter
+	}
+
+	for i := range policy.Burst {
+		if allowed, _ := take("a"); !allowed {
+			t.Fatalf("request %d of the burst rejected", i)
+		}
+	}
+	allowed, retryAfter := take("a")
+	if allowed {
+		t.Fatal("request over the burst allowed")
+	}
+	if retryAfter != time.Second {
+		t.Fatalf("retry after %v, want 1s", retryAfter)
+	}
+	if allowed, _ := take("b"); !allowed {
+		t.Fatal("buckets are not separate per key")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if allowed, retryAfter := take("a"); allowed || retryAfter != 500*time.Millisecond {
+		t.Fatalf("after half a token: %v, %v", allowed, retryAfter)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if allowed, _ := take("a"); !allowed {
+		t.Fatal("refilled token rejected")
+	}
+
+	now = now.Add(time.Hour)
+	take("c")
+	if _, ok := store.buckets["a"]; ok {
+		t.Fatal("full bucket was not swept")
+	}
+}
+
+func TestMemoryStoreTakesAllOrNothing(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	if allowed, _, _ := store.Take(ctx, []string{"ip"}, 1, 1); !allowed {
+		t.Fatal("first request rejected")
+	}
+	// The empty ip bucket rejects the request, the user bucket keeps its token
+	if allowed, _, _ := store.Take(ctx, []string{"user", "ip"}, 1, 1); allowed {
+		t.Fatal("request over the ip bucket allowed")
+	}
+	if allowed, _, _ := store.Take(ctx, []string{"user"}, 1, 1); !allowed {
+		t.Fatal("rejected request used up the user bucket")
+	}
+}
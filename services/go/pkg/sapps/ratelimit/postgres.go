@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"sapps/lib/connection"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table so every
+// instance shares them. Time is taken from the database clock.
+type PostgresStore struct {
+	db *connection.PostgresMainDB
+}
+
+func NewPostgresStore(db *connection.PostgresMainDB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, keys []string, rate float64, burst int) (bool, time.Duration, error) {
+	// Rows are locked in key order so concurrent takes of overlapping keys
+	// cannot deadlock
+	keys = slices.Sorted(slices.Values(keys))
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+		SELECT key, $2::float8, now() FROM unnest($1::text[]) AS key
+		ON CONFLICT (key) DO NOTHING
+	`, keys, float64(burst))
+	if err != nil {
+		return false, 0, err
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT LEAST($3::float8, tokens + GREATEST(EXTRACT(EPOCH FROM now() - updated_at), 0) * $2::float8)
+		FROM rate_limit_buckets WHERE key = ANY($1) ORDER BY key FOR UPDATE
+	`, keys, rate, float64(burst))
+	if err != nil {
+		return false, 0, err
+	}
+	tokens, err := pgx.CollectRows(rows, pgx.RowTo[float64])
+	if err != nil {
+		return false, 0, err
+	}
+	var retryAfter time.Duration
+	for _, t := range tokens {
+		if t < 1 {
+			retryAfter = max(retryAfter, wait(t, rate))
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter, nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE rate_limit_buckets
+		SET tokens = LEAST($3::float8, tokens + GREATEST(EXTRACT(EPOCH FROM now() - updated_at), 0) * $2::float8) - 1,
+			updated_at = GREATEST(now(), updated_at)
+		WHERE key = ANY($1)
+	`, keys, rate, float64(burst))
+	if err != nil {
+		return false, 0, err
+	}
+	return true, 0, tx.Commit(ctx)
+}
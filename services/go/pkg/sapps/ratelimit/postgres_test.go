@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sapps/pkg/sapps/lib/db/main/maindbtest"
+)
+
+func TestPostgresStore(t *testing.T) {
+	db := maindbtest.New(t)
+	store := NewPostgresStore(db.PostgresMainDB)
+	ctx := context.Background()
+	// One token a minute, the test never waits long enough for a refill
+	policy := PerMinute("test", 1, 2, KeyUser, KeyIP)
+	take := func(keys ...string) (bool, time.Duration) {
+		t.Helper()
+		allowed, retryAfter, err := store.Take(ctx, keys, policy.Rate, policy.Burst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed, retryAfter
+	}
+
+	for i := range policy.Burst {
+		if allowed, _ := take("user:a", "ip:1"); !allowed {
+			t.Fatalf("request %d of the burst rejected", i)
+		}
+	}
+	allowed, retryAfter := take("user:a", "ip:1")
+	if allowed || retryAfter <= 0 || retryAfter > time.Minute {
+		t.Fatalf("request over the burst: %v, retry after %v", allowed, retryAfter)
+	}
+
+	// ip:1 is empty, user:b must keep both of its tokens
+	if allowed, _ := take("user:b", "ip:1"); allowed {
+		t.Fatal("request over the ip bucket allowed")
+	}
+	for i := range policy.Burst {
+		if allowed, _ := take("user:b", "ip:2"); !allowed {
+			t.Fatalf("request %d of user:b rejected", i)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sapps/lib/connection"
+	"sapps/pkg/sapps/constant"
+	"time"
+)
+
+const (
+	KeyUser   = "user"
+	KeyIP     = "ip"
+	KeyDevice = "device"
+)
+
+const (
+	BackendMemory   = "memory"
+	BackendPostgres = "postgres"
+)
+
+// Policy is a token bucket per key: Burst requests can be made at once and
+// the bucket refills at Rate requests per second. Every key kind in Keys has
+// its own bucket and a request must fit all of them.
+type Policy struct {
+	Name  string
+	Rate  float64
+	Burst int
+	Keys  []string
+}
+
+// PerMinute allows n requests a minute with bursts of up to burst.
+func PerMinute(name string, n int, burst int, keys ...string) Policy {
+	return Policy{Name: name, Rate: float64(n) / 60, Burst: burst, Keys: keys}
+}
+
+// Store takes tokens from buckets.
+type Store interface {
+	// Take removes a token from the bucket of every key if each of them has
+	// one, otherwise it takes none and returns how long until all will.
+	Take(ctx context.Context, keys []string, rate float64, burst int) (bool, time.Duration, error)
+}
+
+// InjectStore returns the store selected by RATE_LIMIT_BACKEND. Only the
+// Postgres store shares limits between instances.
+func InjectStore(db *connection.PostgresMainDB) Store {
+	if constant.RATE_LIMIT_BACKEND == BackendPostgres {
+		return NewPostgresStore(db)
+	}
+	return NewMemoryStore()
+}
+
+// refill returns the tokens of a bucket after elapsed time.
+func refill(tokens float64, elapsed time.Duration, rate float64, burst int) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(burst), tokens+elapsed.Seconds()*rate)
+}
+
+// wait returns how long a bucket with tokens needs to get a whole token.
+func wait(tokens float64, rate float64) time.Duration {
+	if rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration((1 - tokens) / rate * float64(time.Second))
+}
@@ -0,0 +1,44 @@
+package route
+
+import (
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/model"
+	"sapps/pkg/sapps/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/dig"
+)
+
+type PostAdmin struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+type PostAdminRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+func (r *PostAdmin) Handler(c *middleware.RequestContext) error {
+	var req PostAdminRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Error(middleware.StatusBadRequest, "invalid request body")
+	}
+	if req.Name == "" {
+		return c.Error(middleware.StatusBadRequest, "name is required")
+	}
+	if !model.ValidAdminRole(req.Role) {
+		return c.Error(middleware.StatusBadRequest, "role must be one of viewer, support, owner")
+	}
+
+	admin, key, err := service.NewAdminService(r.MainDB).CreateAdmin(c.Context(), adminActor(c), req.Name, req.Role)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to create admin")
+	}
+	return c.JSON(fiber.Map{
+		"admin": admin,
+		"key":   key,
+	})
+}
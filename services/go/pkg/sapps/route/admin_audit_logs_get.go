@@ -0,0 +1,28 @@
+package route
+
+import (
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/dig"
+)
+
+type GetAdminAuditLogs struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+func (r *GetAdminAuditLogs) Handler(c *middleware.RequestContext) error {
+	limit := c.QueryInt("limit", 100)
+	if limit <= 0 || limit > 1000 {
+		return c.Error(middleware.StatusBadRequest, "limit must be between 1 and 1000")
+	}
+	logs, err := service.NewAdminService(r.MainDB).AuditLogs(c.Context(), adminActor(c), c.Query("user_id"), limit)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to fetch audit logs")
+	}
+	return c.JSON(fiber.Map{"audit_logs": logs})
+}
@@ -0,0 +1,95 @@
+package route
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"time"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/dig"
+)
+
+type GetAdminCosts struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+type GetAdminCostsResponse struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	GroupBy string `json:"group_by"`
+	*service.CostReport
+}
+
+func (r *GetAdminCosts) Handler(c *middleware.RequestContext) error {
+	from, to, err := adminDateRange(c)
+	if err != nil {
+		return c.Error(middleware.StatusBadRequest, err.Error())
+	}
+	groupBy := c.Query("group_by", service.CostGroupByDay)
+	if !service.ValidCostGroupBy(groupBy) {
+		return c.Error(middleware.StatusBadRequest, service.ErrInvalidCostGroupBy.Error())
+	}
+	limit := c.QueryInt("limit", 100)
+	if limit <= 0 || limit > 1000 {
+		return c.Error(middleware.StatusBadRequest, "limit must be between 1 and 1000")
+	}
+
+	report, err := service.NewCostReportService(r.MainDB).Report(c.Context(), from, to, groupBy, limit)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to build cost report")
+	}
+	err = service.AuditLog(c.Context(), r.MainDB, adminActor(c), service.AdminActionViewCosts, "", fiber.Map{
+		"from":     from.Format(time.DateOnly),
+		"to":       to.Format(time.DateOnly),
+		"group_by": groupBy,
+	})
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to build cost report")
+	}
+
+	if c.Query("format") == "csv" {
+		body, err := costCSV(groupBy, report)
+		if err != nil {
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to build cost report")
+		}
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="costs-`+groupBy+`-`+from.Format(time.DateOnly)+`.csv"`)
+		return c.Send(body)
+	}
+
+	return c.JSON(GetAdminCostsResponse{
+		From:       from.Format(time.DateOnly),
+		To:         to.Format(time.DateOnly),
+		GroupBy:    groupBy,
+		CostReport: report,
+	})
+}
+
+func costCSV(groupBy string, report *service.CostReport) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{groupBy, "cost", "requests", "users", "revenue", "margin"})
+	optional := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', 2, 64)
+	}
+	for _, row := range report.Rows {
+		w.Write([]string{
+			row.Key, strconv.FormatFloat(row.Cost, 'f', 4, 64), strconv.Itoa(row.Requests), strconv.Itoa(row.Users),
+			optional(row.Revenue), optional(row.Margin),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,126 @@
+package route
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"strconv"
+	"time"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/dig"
+)
+
+const maxReportDays = 366
+
+type GetAdminRevenue struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+type GetAdminRevenueResponse struct {
+	From    string                   `json:"from"`
+	To      string                   `json:"to"`
+	GroupBy string                   `json:"group_by"`
+	Metrics []service.RevenueMetrics `json:"metrics"`
+}
+
+func (r *GetAdminRevenue) Handler(c *middleware.RequestContext) error {
+	from, to, err := adminDateRange(c)
+	if err != nil {
+		return c.Error(middleware.StatusBadRequest, err.Error())
+	}
+
+	groupBy := c.Query("group_by", service.RevenueGroupByDay)
+	if !service.ValidRevenueGroupBy(groupBy) {
+		return c.Error(middleware.StatusBadRequest, service.ErrInvalidGroupBy.Error())
+	}
+
+	metrics, err := service.NewRevenueAnalyticsService(r.MainDB).Report(c.Context(), from, to, groupBy)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to build revenue report")
+	}
+	err = service.AuditLog(c.Context(), r.MainDB, adminActor(c), service.AdminActionViewRevenue, "", fiber.Map{
+		"from":     from.Format(time.DateOnly),
+		"to":       to.Format(time.DateOnly),
+		"group_by": groupBy,
+	})
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to build revenue report")
+	}
+
+	if c.Query("format") == "csv" {
+		body, err := revenueCSV(groupBy, metrics)
+		if err != nil {
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to build revenue report")
+		}
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="revenue-`+groupBy+`-`+from.Format(time.DateOnly)+`.csv"`)
+		return c.Send(body)
+	}
+
+	return c.JSON(GetAdminRevenueResponse{
+		From:    from.Format(time.DateOnly),
+		To:      to.Format(time.DateOnly),
+		GroupBy: groupBy,
+		Metrics: metrics,
+	})
+}
+
+func revenueCSV(groupBy string, metrics []service.RevenueMetrics) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{
+		groupBy, "revenue", "net_revenue", "new_revenue", "renewal_revenue", "refunded_revenue", "mrr",
+		"active_subscriptions", "purchases", "renewals", "trials", "trial_conversions", "trial_conversion_rate",
+		"refunds", "refund_rate", "churned", "churn_rate",
+	})
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	rate := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	for _, m := range metrics {
+		w.Write([]string{
+			m.Key, money(m.Revenue), money(m.NetRevenue), money(m.NewRevenue), money(m.RenewalRevenue), money(m.RefundedRevenue), money(m.MRR),
+			strconv.Itoa(m.ActiveSubscriptions), strconv.Itoa(m.Purchases), strconv.Itoa(m.Renewals), strconv.Itoa(m.Trials),
+			strconv.Itoa(m.TrialConversions), rate(m.TrialConversionRate), strconv.Itoa(m.Refunds), rate(m.RefundRate),
+			strconv.Itoa(m.Churned), rate(m.ChurnRate),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// adminDateRange parses the from (inclusive) and to (exclusive) query
+// parameters of admin reports, both YYYY-MM-DD in UTC, defaulting to the last
+// 30 days.
+func adminDateRange(c *middleware.RequestContext) (time.Time, time.Time, error) {
+	to := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be YYYY-MM-DD")
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -30)
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be YYYY-MM-DD")
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	if to.Sub(from) > maxReportDays*24*time.Hour {
+		return time.Time{}, time.Time{}, errors.New("range must be at most 366 days")
+	}
+	return from, to, nil
+}
@@ -0,0 +1,50 @@
+package route
+
+import (
+	"errors"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"go.uber.org/dig"
+)
+
+type GetAdminUser struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+// Handler finds a user by exactly one of the id, firebase_id or device_id
+// query parameters.
+func (r *GetAdminUser) Handler(c *middleware.RequestContext) error {
+	var by, value string
+	for _, field := range []string{service.AdminLookupByID, service.AdminLookupByFirebaseID, service.AdminLookupByDeviceID} {
+		if v := c.Query(field); v != "" {
+			if by != "" {
+				return c.Error(middleware.StatusBadRequest, "only one of id, firebase_id and device_id can be given")
+			}
+			by, value = field, v
+		}
+	}
+	if by == "" {
+		return c.Error(middleware.StatusBadRequest, "one of id, firebase_id and device_id is required")
+	}
+
+	user, err := service.NewAdminService(r.MainDB).LookupUser(c.Context(), adminActor(c), by, value)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(user)
+}
+
+func adminActor(c *middleware.RequestContext) service.AdminActor {
+	return service.AdminActor{AdminID: c.Admin().ID, IPAddress: c.IP()}
+}
+
+func adminError(c *middleware.RequestContext, err error) error {
+	if errors.Is(err, service.ErrUserNotFound) {
+		return c.Error(middleware.StatusNotFound, err.Error())
+	}
+	c.LogErr(err)
+	return c.Error(middleware.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,49 @@
+package route
+
+import (
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/dig"
+)
+
+type GetAdminUserScans struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+func (r *GetAdminUserScans) Handler(c *middleware.RequestContext) error {
+	scans, err := service.NewAdminService(r.MainDB).UserScans(c.Context(), adminActor(c), c.Params("id"))
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(fiber.Map{"scans": scans})
+}
+
+type GetAdminUserGenerations struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+func (r *GetAdminUserGenerations) Handler(c *middleware.RequestContext) error {
+	generations, err := service.NewAdminService(r.MainDB).UserGenerations(c.Context(), adminActor(c), c.Params("id"))
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(fiber.Map{"generations": generations})
+}
+
+type GetAdminUserRevenueCat struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+func (r *GetAdminUserRevenueCat) Handler(c *middleware.RequestContext) error {
+	logs, err := service.NewAdminService(r.MainDB).UserRevenueCatLogs(c.Context(), adminActor(c), c.Params("id"))
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(fiber.Map{"revenuecat_logs": logs})
+}
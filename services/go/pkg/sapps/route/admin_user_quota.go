@@ -0,0 +1,57 @@
+package route
+
+import (
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/quota"
+	"sapps/pkg/sapps/service"
+
+	"go.uber.org/dig"
+)
+
+func validQuotaFeature(feature string) bool {
+	return feature == quota.FeatureScan || feature == quota.FeatureGeneration
+}
+
+type PutAdminUserQuota struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+func (r *PutAdminUserQuota) Handler(c *middleware.RequestContext) error {
+	feature := c.Params("feature")
+	if !validQuotaFeature(feature) {
+		return c.Error(middleware.StatusBadRequest, "feature must be scan or generation")
+	}
+	var limit quota.Limit
+	if err := c.BodyParser(&limit); err != nil {
+		return c.Error(middleware.StatusBadRequest, "invalid request body")
+	}
+	if limit.Hourly < 0 || limit.Daily < 0 {
+		return c.Error(middleware.StatusBadRequest, "limits must not be negative")
+	}
+
+	user, err := service.NewAdminService(r.MainDB).SetQuota(c.Context(), adminActor(c), c.Params("id"), feature, limit)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(user)
+}
+
+type DeleteAdminUserQuota struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+func (r *DeleteAdminUserQuota) Handler(c *middleware.RequestContext) error {
+	feature := c.Params("feature")
+	if !validQuotaFeature(feature) {
+		return c.Error(middleware.StatusBadRequest, "feature must be scan or generation")
+	}
+
+	user, err := service.NewAdminService(r.MainDB).DeleteQuota(c.Context(), adminActor(c), c.Params("id"), feature)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(user)
+}
@@ -0,0 +1,104 @@
+package route
+
+import (
+	"time"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"go.uber.org/dig"
+)
+
+type PostAdminUserPremium struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+type PostAdminUserPremiumRequest struct {
+	PremiumType string `json:"premium_type"`
+	// RFC 3339, premium does not expire when empty
+	ExpireDate *time.Time `json:"expire_date"`
+}
+
+func (r *PostAdminUserPremium) Handler(c *middleware.RequestContext) error {
+	var req PostAdminUserPremiumRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Error(middleware.StatusBadRequest, "invalid request body")
+	}
+	if req.PremiumType == "" {
+		return c.Error(middleware.StatusBadRequest, "premium_type is required")
+	}
+	if req.ExpireDate != nil && !req.ExpireDate.After(time.Now()) {
+		return c.Error(middleware.StatusBadRequest, "expire_date must be in the future")
+	}
+
+	user, err := service.NewAdminService(r.MainDB).GrantPremium(c.Context(), adminActor(c), c.Params("id"), req.PremiumType, req.ExpireDate)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(user)
+}
+
+type DeleteAdminUserPremium struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+func (r *DeleteAdminUserPremium) Handler(c *middleware.RequestContext) error {
+	user, err := service.NewAdminService(r.MainDB).RevokePremium(c.Context(), adminActor(c), c.Params("id"))
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(user)
+}
+
+type PostAdminUserCoinsReset struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+type PostAdminUserCoinsResetRequest struct {
+	// Defaults to the weekly allowance
+	Coin *int `json:"coin"`
+}
+
+func (r *PostAdminUserCoinsReset) Handler(c *middleware.RequestContext) error {
+	var req PostAdminUserCoinsResetRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Error(middleware.StatusBadRequest, "invalid request body")
+		}
+	}
+	if req.Coin != nil && *req.Coin < 0 {
+		return c.Error(middleware.StatusBadRequest, "coin must not be negative")
+	}
+
+	user, err := service.NewAdminService(r.MainDB).ResetCoins(c.Context(), adminActor(c), c.Params("id"), req.Coin)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(user)
+}
+
+type PatchAdminUserDebug struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+type PatchAdminUserDebugRequest struct {
+	Debug *bool `json:"debug"`
+}
+
+func (r *PatchAdminUserDebug) Handler(c *middleware.RequestContext) error {
+	var req PatchAdminUserDebugRequest
+	if err := c.BodyParser(&req); err != nil || req.Debug == nil {
+		return c.Error(middleware.StatusBadRequest, "debug is required")
+	}
+
+	user, err := service.NewAdminService(r.MainDB).SetDebug(c.Context(), adminActor(c), c.Params("id"), *req.Debug)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(user)
+}
@@ -1,26 +1,100 @@
 package route
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"sapps/pkg/sapps/constant"
+	"sapps/pkg/sapps/imagestore"
+	"sapps/pkg/sapps/imagevariant"
 	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/middleware"
-	"os"
+	"sapps/pkg/sapps/service"
+	"strconv"
+	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"go.uber.org/dig"
 )
 
 type GetCDNImage struct {
 	dig.In
-	MainDB *maindb.MainDB
+	MainDB   *maindb.MainDB
+	Store    imagestore.Store
+	Variants *imagevariant.Renderer
 }
 
 func (r *GetCDNImage) Handler(c *middleware.RequestContext) error {
 	id := c.Params("id")
-	image, err := os.Open(fmt.Sprintf("%s/cdn/img/%s", constant.WD_PATH, id))
+	if !service.ValidImageKey(id) {
+		return c.Error(middleware.StatusNotFound)
+	}
+	opts, err := imagevariant.ParseOptions(c.Query("width"), c.Query("height"), c.Query("quality"), c.Query("fit"))
 	if err != nil {
+		return c.Error(middleware.StatusBadRequest, err.Error())
+	}
+	expires := c.Query("expires")
+	if err := service.VerifyImageURL(id, expires, c.Query("signature"), opts, time.Now()); err != nil {
+		return c.Error(middleware.StatusForbidden, err.Error())
+	}
+	format := r.Variants.Negotiate(c.Get(fiber.HeaderAccept))
+
+	// Only the client holding the URL may cache it, and not past its expiry
+	unix, _ := strconv.ParseInt(expires, 10, 64)
+	cacheControl := fmt.Sprintf("private, max-age=%d", max(unix-time.Now().Unix(), 0))
+	c.Vary(fiber.HeaderAccept)
+
+	if opts.Original() && format == imagevariant.FormatJPEG {
+		object, err := r.Store.Get(c.Context(), id)
+		if err != nil {
+			return r.storeError(c, err)
+		}
+		// Keys are never reused for other content, the hash identifies it
+		return sendImage(c, object.Data, object.ContentType, `"`+object.Hash+`"`, cacheControl)
+	}
+	variant, err := r.Variants.Render(c.Context(), id, opts, format, func(ctx context.Context) ([]byte, error) {
+		object, err := r.Store.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return object.Data, nil
+	})
+	if err != nil {
+		return r.storeError(c, err)
+	}
+	return sendImage(c, variant.Data, variant.ContentType, variant.ETag, cacheControl)
+}
+
+func (r *GetCDNImage) storeError(c *middleware.RequestContext, err error) error {
+	if !errors.Is(err, imagestore.ErrNotFound) {
 		c.LogErr(err)
-		return c.Error(middleware.StatusNotFound)
+		return c.Error(middleware.StatusInternalServerError)
+	}
+	return c.Error(middleware.StatusNotFound)
+}
+
+// sendImage answers conditional requests and a single byte range, a Range
+// header with several ranges gets the whole image.
+func sendImage(c *middleware.RequestContext, data []byte, contentType string, etag string, cacheControl string) error {
+	c.Set(fiber.HeaderCacheControl, cacheControl)
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	if c.Get(fiber.HeaderRange) == "" || (c.Get(fiber.HeaderIfRange) != "" && c.Get(fiber.HeaderIfRange) != etag) {
+		return c.Send(data)
+	}
+	ranges, err := c.Range(len(data))
+	if errors.Is(err, fiber.ErrRangeUnsatisfiable) {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", len(data)))
+		return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+	}
+	if err != nil || ranges.Type != "bytes" || len(ranges.Ranges) != 1 {
+		return c.Send(data)
 	}
-	return c.SendStream(image)
+	start, end := ranges.Ranges[0].Start, ranges.Ranges[0].End
+	c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+	c.Status(fiber.StatusPartialContent)
+	return c.Send(data[start : end+1])
 }
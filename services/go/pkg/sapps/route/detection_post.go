@@ -0,0 +1,98 @@
+package route
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"sapps/pkg/sapps/detector"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/model"
+
+	"github.com/google/uuid"
+	"go.uber.org/dig"
+)
+
+type PostDetection struct {
+	dig.In
+	MainDB   *maindb.MainDB
+	Detector *detector.Aggregator
+}
+
+type PostDetectionRequest struct {
+	InputText string `json:"input_text"`
+}
+
+type PostDetectionResponse struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	CreatedDate int64  `json:"created_date"`
+	model.DetectionResponse
+}
+
+func (r *PostDetection) Handler(c *middleware.RequestContext) error {
+	var req PostDetectionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Error(middleware.StatusBadRequest, "invalid request body")
+	}
+
+	req.InputText = strings.TrimSpace(req.InputText)
+	if req.InputText == "" {
+		return c.Error(middleware.StatusBadRequest, "input_text is required")
+	}
+	if utf8.RuneCountInString(req.InputText) > maxInputTextLength {
+		return c.Error(middleware.StatusBadRequest, "input_text is too long")
+	}
+
+	id := uuid.New().String()
+	createdAt := time.Now()
+	_, err := r.MainDB.Exec(c.Context(),
+		"INSERT INTO detections (id, user_id, input_text, status, created_date) VALUES ($1, $2, $3, $4, $5)",
+		id, c.UserID(), req.InputText, "processing", createdAt)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to save detection")
+	}
+
+	detection, err := r.Detector.Detect(c.UserContext(), req.InputText)
+	if err != nil {
+		c.LogErr(err)
+		_, dbErr := r.MainDB.Exec(c.Context(),
+			"UPDATE detections SET status = $1, processed_date = NOW() WHERE id = $2",
+			"failed", id)
+		if dbErr != nil {
+			c.LogErr(dbErr)
+		}
+		return c.Error(middleware.StatusInternalServerError, "failed to detect text")
+	}
+
+	breakdownJSON, err := json.Marshal(detection.Breakdown)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to process detection")
+	}
+	serviceResultsJSON, err := json.Marshal(detection.ServiceResults)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to process detection")
+	}
+
+	_, err = r.MainDB.Exec(c.Context(),
+		`UPDATE detections
+		 SET ai_probability = $1, breakdown_json = $2, service_results = $3, status = $4, processed_date = NOW()
+		 WHERE id = $5`,
+		detection.AIProbability, breakdownJSON, serviceResultsJSON, "completed", id)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to save detection")
+	}
+
+	return c.JSON(PostDetectionResponse{
+		ID:                id,
+		Status:            "completed",
+		CreatedDate:       createdAt.Unix(),
+		DetectionResponse: *detection,
+	})
+}
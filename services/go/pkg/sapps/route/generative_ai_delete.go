@@ -2,12 +2,11 @@ package route
 
 import (
 	"fmt"
-	"os"
 	"path"
-	"sapps/pkg/sapps/constant"
+	"sapps/pkg/sapps/imagestore"
 	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/middleware"
-	"strings"
+	"sapps/pkg/sapps/service"
 
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/dig"
@@ -16,6 +15,7 @@ import (
 type DeleteGenerativeAI struct {
 	dig.In
 	MainDB *maindb.MainDB
+	Store  imagestore.Store
 }
 
 func (r *DeleteGenerativeAI) Handler(c *middleware.RequestContext) error {
@@ -24,37 +24,51 @@ func (r *DeleteGenerativeAI) Handler(c *middleware.RequestContext) error {
 		return c.Error(middleware.StatusBadRequest, "id is required")
 	}
 
+	// The task is deleted together with the refund of a task that was still
+	// queued, its submit job then finds no row and kie is never paid for it
+	tx, err := r.MainDB.Begin(c.Context())
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to delete task")
+	}
+	defer tx.Rollback(c.Context())
+
+	var status string
 	var resultURL *string
-	// Check if task exists and belongs to user
-	err := r.MainDB.QueryRow(c.Context(),
-		"SELECT result_url FROM generative_ai_tasks WHERE id = $1 AND user_id = $2",
-		id, c.UserID()).Scan(&resultURL)
+	err = tx.QueryRow(c.Context(),
+		"DELETE FROM generative_ai_tasks WHERE id = $1 AND user_id = $2 RETURNING status, result_url",
+		id, c.UserID()).Scan(&status, &resultURL)
 	if err != nil {
 		if err.Error() == "no rows in result set" {
 			return c.Error(middleware.NewStatus(fiber.StatusNotFound, "NOT_FOUND"), "task not found")
 		}
 		c.LogErr(err)
-		return c.Error(middleware.StatusInternalServerError, "failed to fetch task")
+		return c.Error(middleware.StatusInternalServerError, "failed to delete task")
 	}
-
-	// Delete from DB
-	_, err = r.MainDB.Exec(c.Context(),
-		"DELETE FROM generative_ai_tasks WHERE id = $1 AND user_id = $2",
-		id, c.UserID())
-	if err != nil {
+	if status == service.GenerativeAIStatusQueued {
+		err := maindb.RefundCoins(c.Context(), tx, maindb.CoinReasonGeneration, id, maindb.CoinReasonGenerationRefund)
+		if err != nil {
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to refund coins")
+		}
+		if err := maindb.ReleaseUsage(c.Context(), tx, id); err != nil {
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to delete task")
+		}
+	}
+	if err := tx.Commit(c.Context()); err != nil {
 		c.LogErr(err)
 		return c.Error(middleware.StatusInternalServerError, "failed to delete task")
 	}
 
-	// Delete file from disk if exists
+	// Delete the result image if exists
 	if resultURL != nil && *resultURL != "" {
-		// Extract filename from URL (assuming format .../cdn/img/filename.jpg)
-		filename := path.Base(*resultURL)
-		if filename != "" && !strings.Contains(filename, "/") && !strings.Contains(filename, "\\") {
-			filePath := fmt.Sprintf("%s/cdn/img/%s", constant.WD_PATH, filename)
-			if err := os.Remove(filePath); err != nil {
+		// Extract the key from URL (assuming format .../cdn/img/filename.jpg)
+		key := path.Base(*resultURL)
+		if imagestore.ValidKey(key) {
+			if err := r.Store.Delete(c.Context(), key); err != nil {
 				// Log error but don't fail request since DB delete was successful
-				c.LogErr(fmt.Errorf("failed to delete file %s: %v", filePath, err))
+				c.LogErr(fmt.Errorf("failed to delete image %s: %v", key, err))
 			}
 		}
 	}
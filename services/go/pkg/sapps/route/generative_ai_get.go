@@ -3,6 +3,7 @@ package route
 import (
 	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
 
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/dig"
@@ -34,7 +35,7 @@ func (r *GetGenerativeAI) Handler(c *middleware.RequestContext) error {
 	var completedAt *int64
 
 	row := r.MainDB.QueryRow(c.Context(),
-		`SELECT id, task_id, image_id, prompt, status, result_url, 
+		`SELECT id, COALESCE(task_id, ''), image_id, prompt, status, result_url, 
 		        EXTRACT(EPOCH FROM created_at)::bigint,
 		        EXTRACT(EPOCH FROM completed_at)::bigint
 		 FROM generative_ai_tasks 
@@ -51,6 +52,7 @@ func (r *GetGenerativeAI) Handler(c *middleware.RequestContext) error {
 	}
 
 	resp.CompletedAt = completedAt
+	resp.ResultURL = service.SignResultURL(resp.ResultURL)
 
 	return c.JSON(resp)
 }
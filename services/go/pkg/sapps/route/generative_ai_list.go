@@ -3,6 +3,7 @@ package route
 import (
 	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
 
 	"go.uber.org/dig"
 )
@@ -13,9 +14,10 @@ type GetGenerativeAIList struct {
 }
 
 type GenerativeAIListItem struct {
-	ID        string  `json:"id"`
-	ResultURL *string `json:"result_url,omitempty"`
-	CreatedAt int64   `json:"created_at"`
+	ID           string  `json:"id"`
+	ResultURL    *string `json:"result_url,omitempty"`
+	ThumbnailURL *string `json:"thumbnail_url,omitempty"`
+	CreatedAt    int64   `json:"created_at"`
 }
 
 type GetGenerativeAIListResponse struct {
@@ -42,6 +44,8 @@ func (r *GetGenerativeAIList) Handler(c *middleware.RequestContext) error {
 			c.LogErr(err)
 			continue
 		}
+		item.ThumbnailURL = service.ResultThumbnailURL(item.ResultURL)
+		item.ResultURL = service.SignResultURL(item.ResultURL)
 		generations = append(generations, item)
 	}
 
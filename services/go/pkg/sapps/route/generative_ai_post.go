@@ -1,14 +1,10 @@
 package route
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"sapps/pkg/sapps/constant"
+	"errors"
 	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -19,6 +15,7 @@ import (
 type PostGenerativeAI struct {
 	dig.In
 	MainDB *maindb.MainDB
+	Images service.ImageResolver
 }
 
 type PostGenerativeAIRequest struct {
@@ -26,27 +23,6 @@ type PostGenerativeAIRequest struct {
 	Prompt  string `json:"prompt"`
 }
 
-type KieCreateTaskRequest struct {
-	Model       string             `json:"model"`
-	CallBackURL string             `json:"callBackUrl"`
-	Input       KieCreateTaskInput `json:"input"`
-}
-
-type KieCreateTaskInput struct {
-	ImageUrls    []string `json:"image_urls"`
-	Prompt       string   `json:"prompt"`
-	OutputFormat string   `json:"output_format"`
-	ImageSize    string   `json:"image_size"`
-}
-
-type KieCreateTaskResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-	Data    struct {
-		TaskID string `json:"taskId"`
-	} `json:"data"`
-}
-
 type PostGenerativeAIResponse struct {
 	ID        string `json:"id"`
 	TaskID    string `json:"task_id"`
@@ -55,10 +31,6 @@ type PostGenerativeAIResponse struct {
 }
 
 func (r *PostGenerativeAI) Handler(c *middleware.RequestContext) error {
-	if c.User().PremiumType == nil {
-		return c.Error(middleware.NewStatus(fiber.StatusBadRequest, "SHOW_PAYWALL"))
-	}
-
 	var req PostGenerativeAIRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Error(middleware.StatusBadRequest, "invalid request body")
@@ -70,88 +42,73 @@ func (r *PostGenerativeAI) Handler(c *middleware.RequestContext) error {
 	if req.Prompt == "" {
 		return c.Error(middleware.StatusBadRequest, "prompt is required")
 	}
+	if resolveImage(c, r.Images, req.ImageID) == nil {
+		return nil
+	}
 
 	var existingTask PostGenerativeAIResponse
 	err := r.MainDB.QueryRow(c.Context(),
-		`SELECT id, task_id, status, EXTRACT(EPOCH FROM created_at)::bigint 
+		`SELECT id, COALESCE(task_id, ''), status, EXTRACT(EPOCH FROM created_at)::bigint 
 		 FROM generative_ai_tasks 
-		 WHERE user_id = $1 AND image_id = $2 AND LOWER(prompt) = LOWER($3)
+		 WHERE user_id = $1 AND image_id = $2 AND LOWER(prompt) = LOWER($3) AND status NOT IN ($4, $5)
 		 LIMIT 1`,
-		c.UserID(), req.ImageID, req.Prompt).Scan(&existingTask.ID, &existingTask.TaskID, &existingTask.Status, &existingTask.CreatedAt)
+		c.UserID(), req.ImageID, req.Prompt, service.GenerativeAIStatusFailed, service.GenerativeAIStatusTimedOut).Scan(&existingTask.ID, &existingTask.TaskID, &existingTask.Status, &existingTask.CreatedAt)
 
 	if err == nil {
+		// Nothing new is generated, so the request does not count
+		c.ReleaseUsage()
 		return c.JSON(existingTask)
 	}
 
-	imageURL := fmt.Sprintf("%s/cdn/img/%s.jpg", constant.API_URL, req.ImageID)
-
-	kieReq := KieCreateTaskRequest{
-		Model:       "google/nano-banana-edit",
-		CallBackURL: fmt.Sprintf("%s/webhook/kie/callback", constant.API_URL),
-		Input: KieCreateTaskInput{
-			ImageUrls:    []string{imageURL},
-			Prompt:       req.Prompt,
-			OutputFormat: "jpeg",
-			ImageSize:    "auto",
-		},
-	}
+	id := uuid.New().String()
+	createdAt := time.Now()
 
-	kieReqBody, err := json.Marshal(kieReq)
+	// The task row and its submit job are stored together so the task is
+	// never left queued without a job to process it
+	tx, err := r.MainDB.Begin(c.Context())
 	if err != nil {
 		c.LogErr(err)
-		return c.Error(middleware.StatusInternalServerError, "failed to create request")
+		return c.Error(middleware.StatusInternalServerError, "failed to save task")
 	}
+	defer tx.Rollback(c.Context())
 
-	httpReq, err := http.NewRequest("POST", "https://api.kie.ai/api/v1/jobs/createTask", bytes.NewBuffer(kieReqBody))
+	_, err = tx.Exec(c.Context(),
+		`INSERT INTO generative_ai_tasks (id, user_id, image_id, prompt, status, created_at, ip_address) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id, c.UserID(), req.ImageID, req.Prompt, service.GenerativeAIStatusQueued, createdAt, c.IP())
 	if err != nil {
 		c.LogErr(err)
-		return c.Error(middleware.StatusInternalServerError, "failed to create request")
+		return c.Error(middleware.StatusInternalServerError, "failed to save task")
 	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+constant.GetKiaAPIKey())
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		c.LogErr(err)
-		return c.Error(middleware.StatusInternalServerError, "failed to send request to AI service")
+	if eventID := c.UsageEventID(); eventID != nil {
+		if err := maindb.LinkUsage(c.Context(), tx, *eventID, id); err != nil {
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to save task")
+		}
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.LogErr(err)
-		return c.Error(middleware.StatusInternalServerError, "failed to read response")
+	// Users without premium pay with coins, refunded if the generation fails
+	if c.User().PremiumType == nil {
+		err := maindb.AddCoins(c.Context(), tx, c.UserID(), -maindb.GenerationCoinCost, maindb.CoinReasonGeneration, id)
+		if err != nil {
+			if errors.Is(err, maindb.ErrInsufficientCoins) {
+				return c.Error(middleware.NewStatus(fiber.StatusBadRequest, "SHOW_PAYWALL"))
+			}
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to spend coins")
+		}
 	}
-
-	var kieResp KieCreateTaskResponse
-	if err := json.Unmarshal(body, &kieResp); err != nil {
+	if err := service.EnqueueSubmitGenerativeAITask(c.Context(), tx, id); err != nil {
 		c.LogErr(err)
-		return c.Error(middleware.StatusInternalServerError, "failed to parse response")
-	}
-
-	if kieResp.Code != 200 {
-		c.LogErr(fmt.Errorf("kie api error: %s %d", kieResp.Message, kieResp.Code))
-		return c.Error(middleware.StatusInternalServerError, "AI service error")
+		return c.Error(middleware.StatusInternalServerError, "failed to save task")
 	}
-
-	id := uuid.New().String()
-	createdAt := time.Now()
-
-	_, err = r.MainDB.Exec(c.Context(),
-		`INSERT INTO generative_ai_tasks (id, user_id, image_id, prompt, task_id, status, created_at) 
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		id, c.UserID(), req.ImageID, req.Prompt, kieResp.Data.TaskID, "pending", createdAt)
-	if err != nil {
+	if err := tx.Commit(c.Context()); err != nil {
 		c.LogErr(err)
 		return c.Error(middleware.StatusInternalServerError, "failed to save task")
 	}
 
 	return c.JSON(PostGenerativeAIResponse{
 		ID:        id,
-		TaskID:    kieResp.Data.TaskID,
-		Status:    "pending",
+		Status:    service.GenerativeAIStatusQueued,
 		CreatedAt: createdAt.Unix(),
 	})
 }
@@ -0,0 +1,44 @@
+package route
+
+import (
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/model"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/dig"
+)
+
+type GetHumanizations struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+func (r *GetHumanizations) Handler(c *middleware.RequestContext) error {
+	rows, err := r.MainDB.Query(c.Context(),
+		`SELECT id, input_text, output_text, original_detection, final_detection, improvement_score, status, created_date, processed_date, user_id
+		 FROM humanizations
+		 WHERE user_id = $1
+		 ORDER BY created_date DESC`,
+		c.UserID())
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to fetch humanizations")
+	}
+	defer rows.Close()
+
+	humanizations := []model.Humanization{}
+	for rows.Next() {
+		var h model.Humanization
+		if err := rows.Scan(&h.ID, &h.InputText, &h.OutputText, &h.OriginalDetection, &h.FinalDetection, &h.ImprovementScore, &h.Status, &h.CreatedDate, &h.ProcessedDate, &h.UserID); err != nil {
+			c.LogErr(err)
+			continue
+		}
+		humanizations = append(humanizations, h)
+	}
+
+	return c.JSON(fiber.Map{
+		"status":        "success",
+		"humanizations": humanizations,
+	})
+}
@@ -0,0 +1,78 @@
+package route
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/model"
+	"sapps/pkg/sapps/service"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/dig"
+)
+
+const maxInputTextLength = 10000
+
+type PostHumanization struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+type PostHumanizationRequest struct {
+	InputText string `json:"input_text"`
+}
+
+func (r *PostHumanization) Handler(c *middleware.RequestContext) error {
+	var req PostHumanizationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Error(middleware.StatusBadRequest, "invalid request body")
+	}
+
+	req.InputText = strings.TrimSpace(req.InputText)
+	if req.InputText == "" {
+		return c.Error(middleware.StatusBadRequest, "input_text is required")
+	}
+	if utf8.RuneCountInString(req.InputText) > maxInputTextLength {
+		return c.Error(middleware.StatusBadRequest, "input_text is too long")
+	}
+
+	humanization := model.Humanization{
+		ID:          uuid.New().String(),
+		InputText:   req.InputText,
+		Status:      service.HumanizationStatusProcessing,
+		CreatedDate: time.Now(),
+		UserID:      c.UserID(),
+	}
+	tx, err := r.MainDB.Begin(c.Context())
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to save humanization")
+	}
+	defer tx.Rollback(c.Context())
+
+	_, err = tx.Exec(c.Context(),
+		"INSERT INTO humanizations (id, user_id, input_text, status, created_date) VALUES ($1, $2, $3, $4, $5)",
+		humanization.ID, humanization.UserID, humanization.InputText, humanization.Status, humanization.CreatedDate)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to save humanization")
+	}
+	if err := service.EnqueueProcessHumanization(c.Context(), tx, humanization.ID); err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to save humanization")
+	}
+	if err := tx.Commit(c.Context()); err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to save humanization")
+	}
+
+	return c.JSON(fiber.Map{
+		"status":       "success",
+		"message":      "Humanization started. Check back for results.",
+		"humanization": humanization,
+	})
+}
@@ -0,0 +1,31 @@
+package route
+
+import (
+	"errors"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var StatusUnsupportedImageType = middleware.NewStatus(fiber.StatusUnsupportedMediaType, "UNSUPPORTED_IMAGE_TYPE")
+
+// resolveImage returns the image of the request when the user may use it,
+// otherwise it responds with the error and returns nil.
+func resolveImage(c *middleware.RequestContext, images service.ImageResolver, imageID string) *service.ResolvedImage {
+	resolved, err := images.Resolve(c.Context(), c.UserID(), imageID)
+	switch {
+	case err == nil:
+		return resolved
+	case errors.Is(err, service.ErrImageNotFound):
+		c.Error(middleware.StatusNotFound, "image not found")
+	case errors.Is(err, service.ErrImageNotOwned):
+		c.Error(middleware.StatusForbidden, "image belongs to another user")
+	case errors.Is(err, service.ErrUnsupportedImageType):
+		c.Error(StatusUnsupportedImageType, "unsupported image type")
+	default:
+		c.LogErr(err)
+		c.Error(middleware.StatusInternalServerError, "failed to load image")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeResolver fails every image with err.
+type fakeResolver struct {
+	err error
+}
+
+func (f *fakeResolver) Resolve(ctx context.Context, userID string, imageID string) (*service.ResolvedImage, error) {
+	return nil, f.err
+}
+
+func TestResolveImageErrors(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		code   int
+		status string
+	}{
+		{service.ErrImageNotOwned, fiber.StatusForbidden, middleware.StatusForbidden.Status},
+		{service.ErrImageNotFound, fiber.StatusNotFound, middleware.StatusNotFound.Status},
+		{service.ErrUnsupportedImageType, fiber.StatusUnsupportedMediaType, StatusUnsupportedImageType.Status},
+	} {
+		images := &fakeResolver{err: tc.err}
+		for path, handler := range map[string]middleware.Handle{
+			"/scans":        &PostScan{Images: images},
+			"/generativeai": &PostGenerativeAI{Images: images},
+		} {
+			app := fiber.New()
+			app.Post(path, func(c *fiber.Ctx) error {
+				middleware.NewRequestContext(c).SetUserID("user-1")
+				return c.Next()
+			}, middleware.HandleWrapper(handler))
+
+			req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(`{"image_id":"image-1","prompt":"smile"}`))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body middleware.ErrorStatus
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.code || body.Error.Status != tc.status {
+				t.Errorf("%s with %v: %d %s, want %d %s", path, tc.err, resp.StatusCode, body.Error.Status, tc.code, tc.status)
+			}
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package route
 
 import (
+	"crypto/subtle"
+	"errors"
+	"sapps/lib/connection"
+	"sapps/pkg/sapps/constant"
 	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/middleware"
 	"sapps/pkg/sapps/service"
@@ -15,15 +19,33 @@ type PostRevenuecatWebhook struct {
 }
 
 func (r *PostRevenuecatWebhook) Handler(c *middleware.RequestContext) error {
+	authorization := c.Get(fiber.HeaderAuthorization)
+	if constant.REVENUECAT_WEBHOOK_AUTH == "" || subtle.ConstantTimeCompare([]byte(authorization), []byte(constant.REVENUECAT_WEBHOOK_AUTH)) != 1 {
+		return c.Error(middleware.StatusUnauthorized, "invalid authorization")
+	}
+
 	var eventData service.RevenueCatEvent
 	if err := c.BodyParser(&eventData); err != nil {
 		c.LogErr(err)
 		return c.Error(middleware.StatusBadRequest, err.Error())
 	}
 
-	revenueCatService := service.NewRevenueCatService(r.MainDB)
+	db := r.MainDB
+	if eventData.Event.Environment == service.EnvironmentSandbox && constant.REVENUECAT_SANDBOX_MODE == constant.SandboxModeDatabase {
+		sandbox, err := connection.InjectSandboxDB()
+		if err != nil {
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "sandbox database is unavailable")
+		}
+		db = maindb.InjectMainDB(sandbox)
+	}
+
+	revenueCatService := service.NewRevenueCatService(db)
 	if err := revenueCatService.HandleWebhook(c.Context(), &eventData); err != nil {
 		c.LogErr(err)
+		if errors.Is(err, service.ErrMissingEventID) || errors.Is(err, service.ErrStaleEvent) {
+			return c.Error(middleware.StatusBadRequest, err.Error())
+		}
 		return c.Error(middleware.StatusInternalServerError, err.Error())
 	}
 
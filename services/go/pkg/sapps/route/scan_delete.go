@@ -0,0 +1,81 @@
+package route
+
+import (
+	"fmt"
+	"sapps/pkg/sapps/imagestore"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/dig"
+)
+
+type DeleteScan struct {
+	dig.In
+	MainDB *maindb.MainDB
+	Store  imagestore.Store
+}
+
+// Handler deletes the scan with its share link. The source image is deleted
+// too unless another scan or a generation still uses it.
+func (r *DeleteScan) Handler(c *middleware.RequestContext) error {
+	scanID := c.Params("id")
+	if scanID == "" {
+		return c.Error(middleware.StatusBadRequest, "scan id is required")
+	}
+
+	tx, err := r.MainDB.Begin(c.Context())
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to delete scan")
+	}
+	defer tx.Rollback(c.Context())
+
+	var imageID *string
+	var status string
+	err = tx.QueryRow(c.Context(),
+		"DELETE FROM scans WHERE scan_id = $1 AND user_id = $2 RETURNING image_id, status",
+		scanID, c.UserID()).Scan(&imageID, &status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return c.Error(middleware.StatusNotFound, "scan not found")
+		}
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to delete scan")
+	}
+	// Its job will find nothing to process, the coins are given back now
+	if status == service.ScanStatusProcessing {
+		if err := maindb.RefundCoins(c.Context(), tx, maindb.CoinReasonScan, scanID, maindb.CoinReasonScanRefund); err != nil {
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to delete scan")
+		}
+	}
+
+	deleteImage := false
+	if imageID != nil && *imageID != "" {
+		tag, err := tx.Exec(c.Context(), `
+			DELETE FROM images WHERE id = $1 AND user_id = $2
+			AND NOT EXISTS (SELECT 1 FROM scans WHERE image_id = $1)
+			AND NOT EXISTS (SELECT 1 FROM generative_ai_tasks WHERE image_id = $1)
+		`, *imageID, c.UserID())
+		if err != nil {
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to delete scan")
+		}
+		deleteImage = tag.RowsAffected() > 0
+	}
+	if err := tx.Commit(c.Context()); err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to delete scan")
+	}
+
+	if deleteImage {
+		if err := r.Store.Delete(c.Context(), *imageID+".jpg"); err != nil {
+			// The scan is already gone, a leftover image is only logged
+			c.LogErr(fmt.Errorf("failed to delete image %s: %v", *imageID, err))
+		}
+	}
+
+	return c.JSON(map[string]string{"status": "ok"})
+}
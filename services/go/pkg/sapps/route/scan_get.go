@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/model"
 	"time"
 
 	"go.uber.org/dig"
@@ -15,9 +16,11 @@ type GetScan struct {
 }
 
 type GetScanResponse struct {
-	ScanID    string   `json:"scan_id"`
-	Data      ScanData `json:"data"`
-	CreatedAt int64    `json:"created_at"`
+	ScanID string `json:"scan_id"`
+	Status string `json:"status"`
+	// Data is null when the scan failed
+	Data      *model.ScanData `json:"data"`
+	CreatedAt int64           `json:"created_at"`
 }
 
 func (r *GetScan) Handler(c *middleware.RequestContext) error {
@@ -27,23 +30,27 @@ func (r *GetScan) Handler(c *middleware.RequestContext) error {
 	}
 
 	var dataBytes []byte
+	var status string
 	var createdAt time.Time
 	err := r.MainDB.QueryRow(c.Context(),
-		"SELECT data, created_at FROM scans WHERE scan_id = $1 AND user_id = $2",
-		scanID, c.UserID()).Scan(&dataBytes, &createdAt)
+		"SELECT data, status, created_at FROM scans WHERE scan_id = $1 AND user_id = $2",
+		scanID, c.UserID()).Scan(&dataBytes, &status, &createdAt)
 	if err != nil {
 		c.LogErr(err)
 		return c.Error(middleware.StatusNotFound, "scan not found")
 	}
 
-	var data ScanData
-	if err := json.Unmarshal(dataBytes, &data); err != nil {
-		c.LogErr(err)
-		return c.Error(middleware.StatusInternalServerError, "failed to parse scan data")
+	var data *model.ScanData
+	if dataBytes != nil {
+		if err := json.Unmarshal(dataBytes, &data); err != nil {
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to parse scan data")
+		}
 	}
 
 	return c.JSON(GetScanResponse{
 		ScanID:    scanID,
+		Status:    status,
 		Data:      data,
 		CreatedAt: createdAt.Unix(),
 	})
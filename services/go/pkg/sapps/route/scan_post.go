@@ -1,108 +1,40 @@
 package route
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"log"
-	"regexp"
-	"sapps/lib/connection"
-	"sapps/pkg/sapps/constant"
+	"errors"
+	"sapps/pkg/sapps/facecheck"
 	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/middleware"
-	"strconv"
+	"sapps/pkg/sapps/service"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
-	"github.com/openai/openai-go/v3"
-	"github.com/openai/openai-go/v3/shared"
 	"go.uber.org/dig"
 )
 
+var StatusFaceCheckUnavailable = middleware.NewStatus(fiber.StatusServiceUnavailable, "FACE_CHECK_UNAVAILABLE")
+
 type PostScan struct {
 	dig.In
 	MainDB  *maindb.MainDB
-	ChatGPT *connection.ChatGPT
+	Checker *facecheck.Checker
+	Images  service.ImageResolver
 }
 
 type PostScanRequest struct {
 	ImageID string `json:"image_id"`
 }
 
-type ScanData struct {
-	FaceOverallRating int `json:"face_overall_rating"`
-	FemininityRating  int `json:"femininity_rating"`
-	MasculinityRating int `json:"masculinity_rating"`
-	FaceRating        int `json:"face_rating"`
-	EyesRating        int `json:"eyes_rating"`
-	JawlineRating     int `json:"jawline_rating"`
-	SkinRating        int `json:"skin_rating"`
-}
-
+// PostScanResponse has the processing scan, GET /scans/:id returns its data
+// once the status is completed.
 type PostScanResponse struct {
-	ScanID    string   `json:"scan_id"`
-	Data      ScanData `json:"data"`
-	CreatedAt int64    `json:"created_at"`
-}
-
-const scanSystemPrompt = `You are an expert facial analysis AI. Analyze the provided face image and rate the following features on a scale of 1-10.
-
-Respond ONLY in TOML format with the following structure:
-face_overall_rating = "X/10"
-femininity_rating = "X/10"
-masculinity_rating = "X/10"
-face_rating = "X/10"
-eyes_rating = "X/10"
-jawline_rating = "X/10"
-skin_rating = "X/10"
-
-Replace X with your rating number. Be honest and objective in your assessment.`
-
-func parseRating(s string) int {
-	re := regexp.MustCompile(`(\d+)`)
-	match := re.FindString(s)
-	if match == "" {
-		return 0
-	}
-	val, _ := strconv.Atoi(match)
-	return val
-}
-
-func parseTOMLResponse(response string) ScanData {
-	data := ScanData{}
-	re := regexp.MustCompile(`(\w+)\s*=\s*"?(\d+)/?10?"?`)
-	matches := re.FindAllStringSubmatch(response, -1)
-	for _, match := range matches {
-		if len(match) < 3 {
-			continue
-		}
-		key := match[1]
-		val, _ := strconv.Atoi(match[2])
-		switch key {
-		case "face_overall_rating":
-			data.FaceOverallRating = val
-		case "femininity_rating":
-			data.FemininityRating = val
-		case "masculinity_rating":
-			data.MasculinityRating = val
-		case "face_rating":
-			data.FaceRating = val
-		case "eyes_rating":
-			data.EyesRating = val
-		case "jawline_rating":
-			data.JawlineRating = val
-		case "skin_rating":
-			data.SkinRating = val
-		}
-	}
-	return data
+	ScanID    string `json:"scan_id"`
+	Status    string `json:"status"`
+	CreatedAt int64  `json:"created_at"`
 }
 
 func (r *PostScan) Handler(c *middleware.RequestContext) error {
-	if c.User().PremiumType == nil {
-		return c.Error(middleware.NewStatus(fiber.StatusBadRequest, "SHOW_PAYWALL"))
-	}
 	var req PostScanRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Error(middleware.StatusBadRequest, "invalid request body")
@@ -112,45 +44,74 @@ func (r *PostScan) Handler(c *middleware.RequestContext) error {
 		return c.Error(middleware.StatusBadRequest, "image_id is required")
 	}
 
-	imageURL := fmt.Sprintf("%s/cdn/img/%s.jpg", constant.API_URL, req.ImageID)
-
-	response, _, err := r.ChatGPT.GenerateCompletionWithImage(
-		context.Background(),
-		shared.ChatModelGPT5,
-		scanSystemPrompt,
-		imageURL,
-		openai.ChatCompletionNewParamsResponseFormatUnion{
-			OfText: &openai.ResponseFormatTextParam{},
-		},
-		1,
-	)
+	resolved := resolveImage(c, r.Images, req.ImageID)
+	if resolved == nil {
+		return nil
+	}
+	// Images without a single sharp face are rejected before anything is paid
+	img, err := resolved.Decode()
 	if err != nil {
 		c.LogErr(err)
-		return c.Error(middleware.StatusInternalServerError, "failed to analyze image")
+		return c.Error(middleware.NewStatus(fiber.StatusUnprocessableEntity, "INVALID_IMAGE"), "image could not be decoded")
 	}
-	log.Println(response)
-
-	scanData := parseTOMLResponse(response)
-	scanDataJSON, err := json.Marshal(scanData)
-	if err != nil {
+	if err := r.Checker.Check(c.Context(), img); err != nil {
+		var rejection *facecheck.Rejection
+		if errors.As(err, &rejection) {
+			return c.Error(middleware.NewStatus(fiber.StatusUnprocessableEntity, rejection.Code), rejection.Message)
+		}
+		// Nothing is spent yet, the client can try again
 		c.LogErr(err)
-		return c.Error(middleware.StatusInternalServerError, "failed to process scan data")
+		return c.Error(StatusFaceCheckUnavailable, "face check is unavailable, try again later")
 	}
 
 	scanID := uuid.New().String()
 	createdAt := time.Now()
 
-	_, err = r.MainDB.Exec(c.Context(),
-		"INSERT INTO scans (scan_id, user_id, image_id, data, created_at) VALUES ($1, $2, $3, $4, $5)",
-		scanID, c.UserID(), req.ImageID, scanDataJSON, createdAt)
+	// The scan row, its coin debit and its job are stored together so the
+	// scan is never left processing without a job to finish it
+	tx, err := r.MainDB.Begin(c.Context())
 	if err != nil {
 		c.LogErr(err)
 		return c.Error(middleware.StatusInternalServerError, "failed to save scan")
 	}
+	defer tx.Rollback(c.Context())
+
+	_, err = tx.Exec(c.Context(),
+		"INSERT INTO scans (scan_id, user_id, image_id, status, language, ip_address, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		scanID, c.UserID(), req.ImageID, service.ScanStatusProcessing, service.ScanLanguage(c.Language()), c.IP(), createdAt)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to save scan")
+	}
+	if eventID := c.UsageEventID(); eventID != nil {
+		if err := maindb.LinkUsage(c.Context(), tx, *eventID, scanID); err != nil {
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to save scan")
+		}
+	}
+	// Users without premium pay for the scan with coins, refunded if it fails
+	if c.User().PremiumType == nil {
+		err := maindb.AddCoins(c.Context(), tx, c.UserID(), -maindb.ScanCoinCost, maindb.CoinReasonScan, scanID)
+		if err != nil {
+			if errors.Is(err, maindb.ErrInsufficientCoins) {
+				return c.Error(middleware.NewStatus(fiber.StatusBadRequest, "SHOW_PAYWALL"))
+			}
+			c.LogErr(err)
+			return c.Error(middleware.StatusInternalServerError, "failed to spend coins")
+		}
+	}
+	if err := service.EnqueueProcessScan(c.Context(), tx, scanID); err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to save scan")
+	}
+	if err := tx.Commit(c.Context()); err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to save scan")
+	}
 
 	return c.JSON(PostScanResponse{
 		ScanID:    scanID,
-		Data:      scanData,
+		Status:    service.ScanStatusProcessing,
 		CreatedAt: createdAt.Unix(),
 	})
 }
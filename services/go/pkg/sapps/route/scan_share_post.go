@@ -0,0 +1,32 @@
+package route
+
+import (
+	"errors"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"go.uber.org/dig"
+)
+
+type PostScanShare struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+// Handler returns the public link of the scan, the same one on every call.
+func (r *PostScanShare) Handler(c *middleware.RequestContext) error {
+	share, err := service.NewScanShareService(r.MainDB).Share(c.Context(), c.UserID(), c.Params("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrScanNotFound):
+			return c.Error(middleware.StatusNotFound, "scan not found")
+		case errors.Is(err, service.ErrScanNotCompleted):
+			return c.Error(middleware.StatusConflict, "scan is not completed")
+		}
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to share scan")
+	}
+	return c.JSON(share)
+}
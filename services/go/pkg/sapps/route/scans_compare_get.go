@@ -0,0 +1,39 @@
+package route
+
+import (
+	"errors"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"go.uber.org/dig"
+)
+
+type GetScansCompare struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+// Handler compares scan a with scan b, deltas are b minus a.
+func (r *GetScansCompare) Handler(c *middleware.RequestContext) error {
+	a, b := c.Query("a"), c.Query("b")
+	if a == "" || b == "" {
+		return c.Error(middleware.StatusBadRequest, "a and b are required")
+	}
+
+	comparison, err := service.NewScanProgressService(r.MainDB).Compare(c.Context(), c.UserID(), a, b)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrScanNotFound):
+			return c.Error(middleware.StatusNotFound, "scan not found")
+		case errors.Is(err, service.ErrScanNotCompleted):
+			return c.Error(middleware.StatusConflict, "scan is not completed")
+		case errors.Is(err, service.ErrScanUnrated):
+			return c.Error(middleware.StatusConflict, "scan has no ratings")
+		}
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to compare scans")
+	}
+	return c.JSON(comparison)
+}
@@ -1,10 +1,9 @@
 package route
 
 import (
-	"fmt"
-	"sapps/pkg/sapps/constant"
 	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
 	"time"
 
 	"go.uber.org/dig"
@@ -16,9 +15,11 @@ type GetScans struct {
 }
 
 type ScanItem struct {
-	ScanID    string `json:"scan_id"`
-	ImageURL  string `json:"image_url"`
-	CreatedAt int64  `json:"created_at"`
+	ScanID       string `json:"scan_id"`
+	ImageURL     string `json:"image_url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	Status       string `json:"status"`
+	CreatedAt    int64  `json:"created_at"`
 }
 
 type GetScansResponse struct {
@@ -27,7 +28,7 @@ type GetScansResponse struct {
 
 func (r *GetScans) Handler(c *middleware.RequestContext) error {
 	rows, err := r.MainDB.Query(c.Context(),
-		"SELECT scan_id, image_id, created_at FROM scans WHERE user_id = $1 ORDER BY created_at DESC",
+		"SELECT scan_id, image_id, status, created_at FROM scans WHERE user_id = $1 ORDER BY created_at DESC",
 		c.UserID())
 	if err != nil {
 		c.LogErr(err)
@@ -39,16 +40,18 @@ func (r *GetScans) Handler(c *middleware.RequestContext) error {
 	for rows.Next() {
 		var scanID string
 		var imageID string
+		var status string
 		var createdAt time.Time
-		if err := rows.Scan(&scanID, &imageID, &createdAt); err != nil {
+		if err := rows.Scan(&scanID, &imageID, &status, &createdAt); err != nil {
 			c.LogErr(err)
 			continue
 		}
-		imageURL := fmt.Sprintf("%s/cdn/img/%s.jpg", constant.API_URL, imageID)
 		scans = append(scans, ScanItem{
-			ScanID:    scanID,
-			ImageURL:  imageURL,
-			CreatedAt: createdAt.Unix(),
+			ScanID:       scanID,
+			ImageURL:     service.ImageURL(imageID),
+			ThumbnailURL: service.ThumbnailURL(imageID),
+			Status:       status,
+			CreatedAt:    createdAt.Unix(),
 		})
 	}
 
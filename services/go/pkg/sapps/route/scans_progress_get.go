@@ -0,0 +1,23 @@
+package route
+
+import (
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"go.uber.org/dig"
+)
+
+type GetScansProgress struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+func (r *GetScansProgress) Handler(c *middleware.RequestContext) error {
+	progress, err := service.NewScanProgressService(r.MainDB).Progress(c.Context(), c.UserID())
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to fetch scan progress")
+	}
+	return c.JSON(progress)
+}
@@ -0,0 +1,107 @@
+package route
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"image/png"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+	"sapps/pkg/sapps/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/dig"
+)
+
+const shareCacheControl = "public, max-age=3600"
+
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Face scan: {{.Overall}}/10</title>
+<meta property="og:title" content="Face scan: {{.Overall}}/10">
+<meta property="og:type" content="website">
+<meta property="og:url" content="{{.Share.URL}}">
+<meta property="og:image" content="{{.Share.CardURL}}">
+<meta name="twitter:card" content="summary_large_image">
+</head>
+<body>
+<h1>Face scan: {{.Overall}}/10</h1>
+<img src="{{.Share.CardURL}}" alt="Face scan ratings" width="600">
+<ul>
+{{range .Ratings}}<li>{{.Label}}: {{.Rating}}/10</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+type GetShare struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+// Handler renders a shared scan as a page with link preview tags, or as JSON
+// for clients that accept it.
+func (r *GetShare) Handler(c *middleware.RequestContext) error {
+	shared, err := sharedScan(c, r.MainDB)
+	if shared == nil {
+		return err
+	}
+	c.Set(fiber.HeaderCacheControl, shareCacheControl)
+	if c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON {
+		return c.JSON(shared)
+	}
+	var buf bytes.Buffer
+	if err := sharePage.Execute(&buf, shared); err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to render share")
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Send(buf.Bytes())
+}
+
+type GetShareCard struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+func (r *GetShareCard) Handler(c *middleware.RequestContext) error {
+	shared, err := sharedScan(c, r.MainDB)
+	if shared == nil {
+		return err
+	}
+	card, err := service.RenderShareCard(shared)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to render share card")
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, card); err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to render share card")
+	}
+	c.Set(fiber.HeaderContentType, "image/png")
+	c.Set(fiber.HeaderCacheControl, shareCacheControl)
+	return c.Send(buf.Bytes())
+}
+
+// sharedScan loads the scan of the token parameter. When there is none it
+// writes the error response and returns a nil scan.
+func sharedScan(c *middleware.RequestContext, db *maindb.MainDB) (*service.SharedScan, error) {
+	token := c.Params("token")
+	if !service.ValidShareToken(token) {
+		return nil, c.Error(middleware.StatusNotFound, "share not found")
+	}
+	shared, err := service.NewScanShareService(db).Shared(c.Context(), token)
+	if err != nil {
+		if errors.Is(err, service.ErrShareNotFound) {
+			return nil, c.Error(middleware.StatusNotFound, "share not found")
+		}
+		c.LogErr(err)
+		return nil, c.Error(middleware.StatusInternalServerError, "failed to fetch share")
+	}
+	return shared, nil
+}
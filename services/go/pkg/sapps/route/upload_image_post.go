@@ -1,23 +1,25 @@
 package route
 
 import (
-	"fmt"
-	"image"
-	"image/jpeg"
-	_ "image/png"
-	"os"
-	"sapps/pkg/sapps/constant"
+	"errors"
+	"io"
+	"sapps/pkg/sapps/imagestore"
+	"sapps/pkg/sapps/imageupload"
 	maindb "sapps/pkg/sapps/lib/db/main"
 	"sapps/pkg/sapps/middleware"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"go.uber.org/dig"
-	_ "golang.org/x/image/webp"
 )
 
+var StatusImageTooLarge = middleware.NewStatus(fiber.StatusRequestEntityTooLarge, "IMAGE_TOO_LARGE")
+
 type PostUploadImage struct {
 	dig.In
-	MainDB *maindb.MainDB
+	MainDB    *maindb.MainDB
+	Store     imagestore.Store
+	Processor *imageupload.Processor
 }
 
 type PostUploadImageResponse struct {
@@ -35,25 +37,36 @@ func (r *PostUploadImage) Handler(c *middleware.RequestContext) error {
 		return c.Error(middleware.StatusBadRequest, "invalid image")
 	}
 	defer buffer.Close()
-
-	img, _, err := image.Decode(buffer)
+	data, err := io.ReadAll(buffer)
 	if err != nil {
 		return c.Error(middleware.StatusBadRequest, "invalid image")
 	}
-	imageID := uuid.New().String()
-	filename := imageID + ".jpg"
-	out, err := os.Create(fmt.Sprintf("%s/cdn/img/%s", constant.WD_PATH, filename))
+
+	upload, err := r.Processor.Process(c.Context(), data)
 	if err != nil {
-		return c.Error(middleware.StatusInternalServerError, "failed to save image")
+		switch {
+		case errors.Is(err, imageupload.ErrTooManyPixels):
+			return c.Error(StatusImageTooLarge, "image resolution is too large")
+		case errors.Is(err, imageupload.ErrUnsupportedFormat):
+			return c.Error(middleware.StatusBadRequest, "unsupported image format")
+		case errors.Is(err, imageupload.ErrInvalidImage):
+			return c.Error(middleware.StatusBadRequest, "invalid image")
+		}
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to process image")
 	}
-	defer out.Close()
-	err = jpeg.Encode(out, img, &jpeg.Options{Quality: 97})
+	imageID := uuid.New().String()
+	hash, _, err := imagestore.PutJPEG(c.Context(), r.Store, imageID+".jpg", upload.Image, 97)
 	if err != nil {
+		c.LogErr(err)
 		return c.Error(middleware.StatusInternalServerError, "failed to save image")
 	}
 
-	_, err = r.MainDB.Exec(c.Context(), "insert into images (id, user_id, size, content_type) values ($1, $2, $3, $4)",
-		imageID, c.UserID(), file.Size, file.Header.Get("Content-Type"))
+	// Width and height are of the stored image, format and content type of
+	// the upload as sniffed rather than as the client claimed
+	bounds := upload.Image.Bounds()
+	_, err = r.MainDB.Exec(c.Context(), "insert into images (id, user_id, size, content_type, hash, width, height, format) values ($1, $2, $3, $4, $5, $6, $7, $8)",
+		imageID, c.UserID(), file.Size, upload.ContentType(), hash, bounds.Dx(), bounds.Dy(), upload.Format)
 	if err != nil {
 		c.LogErr(err)
 		return c.Error(middleware.StatusInternalServerError, "failed to save image info")
@@ -0,0 +1,53 @@
+package route
+
+import (
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/middleware"
+
+	"go.uber.org/dig"
+)
+
+type GetCoinHistory struct {
+	dig.In
+	MainDB *maindb.MainDB
+}
+
+type GetCoinHistoryResponse struct {
+	Coin    int                      `json:"coin"`
+	History []maindb.CoinLedgerEntry `json:"history"`
+	// Cursor of the next page, nil on the last one
+	NextBefore *string `json:"next_before"`
+}
+
+// Handler returns the newest ledger entries first, older pages are fetched
+// with before set to next_before of the previous page.
+func (r *GetCoinHistory) Handler(c *middleware.RequestContext) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 200 {
+		return c.Error(middleware.StatusBadRequest, "limit must be between 1 and 200")
+	}
+	var before *maindb.CoinCursor
+	if value := c.Query("before"); value != "" {
+		cursor, err := maindb.ParseCoinCursor(value)
+		if err != nil {
+			return c.Error(middleware.StatusBadRequest, "invalid before")
+		}
+		before = &cursor
+	}
+
+	history, err := r.MainDB.CoinHistory(c.Context(), c.UserID(), before, limit)
+	if err != nil {
+		c.LogErr(err)
+		return c.Error(middleware.StatusInternalServerError, "failed to fetch coin history")
+	}
+	response := GetCoinHistoryResponse{
+		Coin:    c.User().Coin,
+		History: history,
+	}
+	if len(history) == limit {
+		last := history[len(history)-1]
+		next := maindb.CoinCursor{CreatedDate: last.CreatedDate, ID: last.ID}.String()
+		response.NextBefore = &next
+	}
+	return c.JSON(response)
+}
@@ -4,6 +4,13 @@ import (
 	"context"
 	"sapps/lib/connection"
 	"sapps/lib/util"
+	"sapps/pkg/sapps/constant"
+	"sapps/pkg/sapps/detector"
+	"sapps/pkg/sapps/imagestore"
+	"sapps/pkg/sapps/imagevariant"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/queue"
+	"sapps/pkg/sapps/service"
 	"log"
 	"net/http"
 	"time"
@@ -14,6 +21,82 @@ import (
 func Scripts() {
 	//go apiIsLive()
 	go SendPushToNonPremiumUsers()
+	store := imagestore.InjectStore()
+	go JobQueue(store)
+	go GenerativeAIReconciler(store)
+	go CostAlerts()
+	go UsageCleanup()
+	go ImageVariantCleanup()
+}
+
+func JobQueue(store imagestore.Store) {
+	db := maindb.InjectMainDB(connection.InjectMainDB())
+	q := queue.New(db)
+	service.NewGenerativeAIService(db, service.NewKieClient(), store).Register(q)
+	// Without OpenAI the other jobs still run, scans and humanizations wait
+	// in the queue
+	chatGPT, err := connection.NewChatGPT()
+	if err != nil {
+		util.LogErr(err)
+	} else {
+		service.NewScanService(db, chatGPT, connection.InjectFirebase()).Register(q)
+		service.NewHumanizationService(db, chatGPT, detector.InjectAggregator()).Register(q)
+	}
+	q.Run(context.Background(), 4, time.Second)
+}
+
+// GenerativeAIReconciler polls kie for tasks whose callback never arrived.
+func GenerativeAIReconciler(store imagestore.Store) {
+	db := maindb.InjectMainDB(connection.InjectMainDB())
+	generativeAIService := service.NewGenerativeAIService(db, service.NewKieClient(), store)
+	ticker := time.NewTicker(time.Minute)
+	for range ticker.C {
+		err := generativeAIService.Reconcile(context.Background(), constant.KIE_RECONCILE_AFTER, constant.KIE_TASK_TIMEOUT)
+		if err != nil {
+			util.LogErr(err)
+		}
+	}
+}
+
+// CostAlerts raises an alert when a user's AI cost in a day crosses the threshold.
+func CostAlerts() {
+	db := maindb.InjectMainDB(connection.InjectMainDB())
+	costAlertService := service.NewCostAlertService(db, constant.COST_ALERT_WEBHOOK_URL)
+	ticker := time.NewTicker(5 * time.Minute)
+	for range ticker.C {
+		if _, err := costAlertService.Check(context.Background(), constant.COST_ALERT_THRESHOLD); err != nil {
+			util.LogErr(err)
+		}
+	}
+}
+
+// UsageCleanup removes usage events older than the longest quota window and
+// rate limit buckets idle long enough to have refilled.
+func UsageCleanup() {
+	dbConn := connection.InjectMainDB()
+	ticker := time.NewTicker(time.Hour)
+	for range ticker.C {
+		_, err := dbConn.Exec(context.Background(), `DELETE FROM usage_events WHERE created_date < NOW() - INTERVAL '2 day'`)
+		if err != nil {
+			util.LogErr(err)
+		}
+		_, err = dbConn.Exec(context.Background(), `DELETE FROM rate_limit_buckets WHERE updated_at < NOW() - INTERVAL '1 hour'`)
+		if err != nil {
+			util.LogErr(err)
+		}
+	}
+}
+
+// ImageVariantCleanup removes cached /cdn/img variants nobody requested
+// lately, each instance caches its own.
+func ImageVariantCleanup() {
+	renderer := imagevariant.InjectRenderer()
+	ticker := time.NewTicker(time.Hour)
+	for range ticker.C {
+		if _, err := renderer.Prune(constant.IMAGE_VARIANT_CACHE_TTL); err != nil {
+			util.LogErr(err)
+		}
+	}
 }
 
 func apiIsLive() {
@@ -0,0 +1,402 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"sapps/lib/util"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/model"
+	"sapps/pkg/sapps/queue"
+	"sapps/pkg/sapps/quota"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	AdminActionLookupUser         = "lookup_user"
+	AdminActionViewScans          = "view_scans"
+	AdminActionViewGenerations    = "view_generations"
+	AdminActionViewRevenueCat     = "view_revenuecat"
+	AdminActionViewRevenue        = "view_revenue"
+	AdminActionViewCosts          = "view_costs"
+	AdminActionViewAuditLogs      = "view_audit_logs"
+	AdminActionGrantPremium       = "grant_premium"
+	AdminActionRevokePremium      = "revoke_premium"
+	AdminActionResetCoins         = "reset_coins"
+	AdminActionSetDebug           = "set_debug"
+	AdminActionCreateAdmin        = "create_admin"
+	AdminActionSetQuota           = "set_quota"
+	AdminActionDeleteQuota        = "delete_quota"
+	adminPremiumTransactionPrefix = "admin-"
+)
+
+const (
+	AdminLookupByID         = "id"
+	AdminLookupByFirebaseID = "firebase_id"
+	AdminLookupByDeviceID   = "device_id"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// AdminActor is the admin performing an action and where the request came from.
+type AdminActor struct {
+	AdminID   string
+	IPAddress string
+}
+
+type AdminUser struct {
+	ID                string     `json:"id"`
+	FirebaseID        *string    `json:"firebase_id"`
+	DeviceID          *string    `json:"device_id"`
+	Coin              int        `json:"coin"`
+	CoinResetDate     *time.Time `json:"coin_reset_date"`
+	Debug             bool       `json:"debug"`
+	Language          *string    `json:"language"`
+	Country           *string    `json:"country"`
+	Store             *string    `json:"store"`
+	BuildNumber       *int       `json:"build_number"`
+	RegisteredAt      *time.Time `json:"registered_at"`
+	LastOnline        *time.Time `json:"last_online"`
+	PremiumID         *string    `json:"premium_id"`
+	PremiumType       *string    `json:"premium_type"`
+	PremiumExpireDate *time.Time `json:"premium_expire_date"`
+}
+
+type AdminScan struct {
+	ScanID    string          `json:"scan_id"`
+	ImageID   *string         `json:"image_id"`
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type AdminGeneration struct {
+	ID          string     `json:"id"`
+	ImageID     *string    `json:"image_id"`
+	Prompt      *string    `json:"prompt"`
+	TaskID      *string    `json:"task_id"`
+	Status      *string    `json:"status"`
+	ResultURL   *string    `json:"result_url"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+type AdminRevenueCatLog struct {
+	EventID       string     `json:"event_id"`
+	EventType     *string    `json:"event_type"`
+	ProductID     *string    `json:"product_id"`
+	Price         *float64   `json:"price"`
+	Store         *string    `json:"store"`
+	Environment   *string    `json:"environment"`
+	TransactionID *string    `json:"transaction_id"`
+	SkipReason    *string    `json:"skip_reason"`
+	CreatedAt     *time.Time `json:"created_at"`
+}
+
+type AdminAuditLog struct {
+	ID           string          `json:"id"`
+	AdminID      string          `json:"admin_id"`
+	Action       string          `json:"action"`
+	TargetUserID *string         `json:"target_user_id"`
+	Details      json.RawMessage `json:"details"`
+	IPAddress    *string         `json:"ip_address"`
+	CreatedDate  time.Time       `json:"created_date"`
+}
+
+type AdminService struct {
+	db *maindb.MainDB
+}
+
+func NewAdminService(db *maindb.MainDB) *AdminService {
+	return &AdminService{db: db}
+}
+
+// AuditLog records an admin action. Changes pass their transaction so the
+// change and its audit entry are committed together.
+func AuditLog(ctx context.Context, db queue.Execer, actor AdminActor, action string, targetUserID string, details any) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `
+		INSERT INTO admin_audit_logs (admin_id, action, target_user_id, details, ip_address) VALUES ($1, $2, $3, $4, $5)
+	`, actor.AdminID, action, nullIfEmpty(targetUserID), detailsJSON, nullIfEmpty(actor.IPAddress))
+	return err
+}
+
+func (s *AdminService) LookupUser(ctx context.Context, actor AdminActor, by string, value string) (*AdminUser, error) {
+	column := map[string]string{
+		AdminLookupByID:         "u.id",
+		AdminLookupByFirebaseID: "u.firebase_id",
+		AdminLookupByDeviceID:   "u.device_id",
+	}[by]
+	if column == "" {
+		return nil, errors.New("invalid lookup field")
+	}
+	user, err := s.user(ctx, s.db, column, value, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := AuditLog(ctx, s.db, actor, AdminActionLookupUser, user.ID, map[string]string{by: value}); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// user loads the user matching value in column, a trusted column name.
+func (s *AdminService) user(ctx context.Context, db rowQuerier, column string, value string, lock string) (*AdminUser, error) {
+	var user AdminUser
+	err := db.QueryRow(ctx, `
+		SELECT u.id, u.firebase_id, u.device_id, COALESCE(u.coin, 0), u.coin_reset_date, COALESCE(u.debug, false), u.language,
+		       u.country, u.store, u.build_number, u.registered_at, u.last_online, u.premium_id, pd.premium_type, pd.expire_date
+		FROM users u
+		LEFT JOIN premium_data pd ON pd.id = u.premium_id
+		WHERE `+column+` = $1
+		ORDER BY u.last_online DESC NULLS LAST
+		LIMIT 1
+		`+lock, value).Scan(
+		&user.ID, &user.FirebaseID, &user.DeviceID, &user.Coin, &user.CoinResetDate, &user.Debug, &user.Language,
+		&user.Country, &user.Store, &user.BuildNumber, &user.RegisteredAt, &user.LastOnline, &user.PremiumID, &user.PremiumType, &user.PremiumExpireDate,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *AdminService) UserScans(ctx context.Context, actor AdminActor, userID string) ([]AdminScan, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT scan_id, image_id, status, data, created_at FROM scans WHERE user_id = $1 ORDER BY created_at DESC LIMIT 200
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	scans, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (AdminScan, error) {
+		var scan AdminScan
+		err := row.Scan(&scan.ScanID, &scan.ImageID, &scan.Status, &scan.Data, &scan.CreatedAt)
+		return scan, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scans, AuditLog(ctx, s.db, actor, AdminActionViewScans, userID, nil)
+}
+
+func (s *AdminService) UserGenerations(ctx context.Context, actor AdminActor, userID string) ([]AdminGeneration, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, image_id, prompt, task_id, status, result_url, created_at, completed_at
+		FROM generative_ai_tasks WHERE user_id = $1 ORDER BY created_at DESC LIMIT 200
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	generations, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (AdminGeneration, error) {
+		var g AdminGeneration
+		err := row.Scan(&g.ID, &g.ImageID, &g.Prompt, &g.TaskID, &g.Status, &g.ResultURL, &g.CreatedAt, &g.CompletedAt)
+		g.ResultURL = SignResultURL(g.ResultURL)
+		return g, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return generations, AuditLog(ctx, s.db, actor, AdminActionViewGenerations, userID, nil)
+}
+
+// UserRevenueCatLogs returns the RevenueCat events of the user, matched on
+// their current firebase id.
+func (s *AdminService) UserRevenueCatLogs(ctx context.Context, actor AdminActor, userID string) ([]AdminRevenueCatLog, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT l.revenuecat_event_id, l.event_type, l.product_id, l.price, l.store, l.environment, l.transaction_id, l.skip_reason, l.created_at
+		FROM revenuecat_logs l
+		JOIN users u ON u.firebase_id IN (l.app_user_id, l.original_app_user_id)
+		WHERE u.id = $1
+		ORDER BY l.created_at DESC
+		LIMIT 500
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (AdminRevenueCatLog, error) {
+		var l AdminRevenueCatLog
+		err := row.Scan(&l.EventID, &l.EventType, &l.ProductID, &l.Price, &l.Store, &l.Environment, &l.TransactionID, &l.SkipReason, &l.CreatedAt)
+		return l, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logs, AuditLog(ctx, s.db, actor, AdminActionViewRevenueCat, userID, nil)
+}
+
+// GrantPremium gives the user premium until expireDate, or forever when it is
+// nil. An admin entitlement of the user is updated in place, otherwise a new
+// one is created. RevenueCat transactions are never changed, they stay a
+// record of what the store sold.
+func (s *AdminService) GrantPremium(ctx context.Context, actor AdminActor, userID string, premiumType string, expireDate *time.Time) (*AdminUser, error) {
+	return s.change(ctx, actor, userID, AdminActionGrantPremium, map[string]any{"premium_type": premiumType, "expire_date": expireDate}, func(tx pgx.Tx, user *AdminUser) error {
+		premiumID := adminPremiumTransactionPrefix + uuid.New().String()
+		if user.PremiumID != nil && strings.HasPrefix(*user.PremiumID, adminPremiumTransactionPrefix) {
+			premiumID = *user.PremiumID
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO premium_data (id, premium_type, expire_date) VALUES ($1, $2, $3)
+			ON CONFLICT (id) DO UPDATE SET premium_type = excluded.premium_type, expire_date = excluded.expire_date
+		`, premiumID, premiumType, expireDate)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE users SET premium_id = $1 WHERE id = $2`, premiumID, userID)
+		return err
+	})
+}
+
+// RevokePremium ends the user's current entitlement immediately. A
+// RevenueCat transaction is only detached from the user, a later renewal
+// grants it again.
+func (s *AdminService) RevokePremium(ctx context.Context, actor AdminActor, userID string) (*AdminUser, error) {
+	return s.change(ctx, actor, userID, AdminActionRevokePremium, nil, func(tx pgx.Tx, user *AdminUser) error {
+		if user.PremiumID == nil {
+			return nil
+		}
+		if strings.HasPrefix(*user.PremiumID, adminPremiumTransactionPrefix) {
+			_, err := tx.Exec(ctx, `UPDATE premium_data SET expire_date = NOW() WHERE id = $1`, *user.PremiumID)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(ctx, `UPDATE users SET premium_id = NULL WHERE id = $1`, userID)
+		return err
+	})
+}
+
+// ResetCoins sets the user's coins and starts a new weekly period.
+func (s *AdminService) ResetCoins(ctx context.Context, actor AdminActor, userID string, coin *int) (*AdminUser, error) {
+	value := maindb.WeeklyCoins
+	if coin != nil {
+		value = *coin
+	}
+	return s.change(ctx, actor, userID, AdminActionResetCoins, map[string]int{"coin": value}, func(tx pgx.Tx, user *AdminUser) error {
+		if err := maindb.AddCoins(ctx, tx, userID, value-user.Coin, maindb.CoinReasonAdminReset, ""); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `
+			UPDATE users SET coin_reset_date = NOW() + INTERVAL '7 day' WHERE id = $1
+		`, userID)
+		return err
+	})
+}
+
+func (s *AdminService) SetDebug(ctx context.Context, actor AdminActor, userID string, debug bool) (*AdminUser, error) {
+	return s.change(ctx, actor, userID, AdminActionSetDebug, map[string]bool{"debug": debug}, func(tx pgx.Tx, user *AdminUser) error {
+		_, err := tx.Exec(ctx, `UPDATE users SET debug = $1 WHERE id = $2`, debug, userID)
+		return err
+	})
+}
+
+// SetQuota replaces the tier limit of feature for the user, zero allows
+// unlimited use.
+func (s *AdminService) SetQuota(ctx context.Context, actor AdminActor, userID string, feature string, limit quota.Limit) (*AdminUser, error) {
+	return s.change(ctx, actor, userID, AdminActionSetQuota, map[string]any{"feature": feature, "limit": limit}, func(tx pgx.Tx, user *AdminUser) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO user_quotas (user_id, feature, hourly_limit, daily_limit) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, feature) DO UPDATE SET hourly_limit = excluded.hourly_limit, daily_limit = excluded.daily_limit
+		`, userID, feature, limit.Hourly, limit.Daily)
+		return err
+	})
+}
+
+// DeleteQuota puts the user back on the limit of their tier.
+func (s *AdminService) DeleteQuota(ctx context.Context, actor AdminActor, userID string, feature string) (*AdminUser, error) {
+	return s.change(ctx, actor, userID, AdminActionDeleteQuota, map[string]string{"feature": feature}, func(tx pgx.Tx, user *AdminUser) error {
+		_, err := tx.Exec(ctx, `DELETE FROM user_quotas WHERE user_id = $1 AND feature = $2`, userID, feature)
+		return err
+	})
+}
+
+// change runs apply on the locked user row and records the action in the
+// same transaction, returning the user as it is afterwards.
+func (s *AdminService) change(ctx context.Context, actor AdminActor, userID string, action string, details any, apply func(tx pgx.Tx, user *AdminUser) error) (*AdminUser, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	user, err := s.user(ctx, tx, "u.id", userID, "FOR UPDATE OF u")
+	if err != nil {
+		return nil, err
+	}
+	if err := apply(tx, user); err != nil {
+		return nil, err
+	}
+	if err := AuditLog(ctx, tx, actor, action, userID, details); err != nil {
+		return nil, err
+	}
+	if user, err = s.user(ctx, tx, "u.id", userID, ""); err != nil {
+		return nil, err
+	}
+	return user, tx.Commit(ctx)
+}
+
+// CreateAdmin adds an admin and returns the key it authenticates with, which
+// is not stored and cannot be shown again.
+func (s *AdminService) CreateAdmin(ctx context.Context, actor AdminActor, name string, role string) (*model.Admin, string, error) {
+	keyBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, "", err
+	}
+	key := hex.EncodeToString(keyBytes)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	admin := model.Admin{Name: name, Role: role}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO admins (name, role, key_hash) VALUES ($1, $2, $3) RETURNING id
+	`, name, role, util.HashKey(key)).Scan(&admin.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := AuditLog(ctx, tx, actor, AdminActionCreateAdmin, "", admin); err != nil {
+		return nil, "", err
+	}
+	return &admin, key, tx.Commit(ctx)
+}
+
+func (s *AdminService) AuditLogs(ctx context.Context, actor AdminActor, targetUserID string, limit int) ([]AdminAuditLog, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, admin_id, action, target_user_id, details, ip_address, created_date
+		FROM admin_audit_logs
+		WHERE $1::text IS NULL OR target_user_id = $1
+		ORDER BY created_date DESC
+		LIMIT $2
+	`, nullIfEmpty(targetUserID), limit)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (AdminAuditLog, error) {
+		var l AdminAuditLog
+		err := row.Scan(&l.ID, &l.AdminID, &l.Action, &l.TargetUserID, &l.Details, &l.IPAddress, &l.CreatedDate)
+		return l, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logs, AuditLog(ctx, s.db, actor, AdminActionViewAuditLogs, targetUserID, nil)
+}
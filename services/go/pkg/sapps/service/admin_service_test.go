@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"sapps/pkg/sapps/lib/db/main/maindbtest"
+)
+
+func TestAdminPremiumLeavesRevenueCatTransactions(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	s := NewAdminService(db)
+	actor := AdminActor{AdminID: "admin-1"}
+
+	storeExpiry := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	_, err := db.Exec(ctx, `
+		INSERT INTO premium_data (id, premium_type, expire_date) VALUES ('rc-tx-1', 'sappsr_pro_a_1w', $1)
+	`, storeExpiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO users (id, firebase_id, premium_id) VALUES ('user-1', 'firebase-1', 'rc-tx-1')`); err != nil {
+		t.Fatal(err)
+	}
+	storeRow := func() (string, *time.Time) {
+		t.Helper()
+		var premiumType string
+		var expireDate *time.Time
+		err := db.QueryRow(ctx, `SELECT premium_type, expire_date FROM premium_data WHERE id = 'rc-tx-1'`).Scan(&premiumType, &expireDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return premiumType, expireDate
+	}
+
+	user, err := s.GrantPremium(ctx, actor, "user-1", "sappsr_pro_a_1y", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PremiumID == nil || !strings.HasPrefix(*user.PremiumID, adminPremiumTransactionPrefix) || *user.PremiumType != "sappsr_pro_a_1y" {
+		t.Fatalf("granted premium %v %v", user.PremiumID, user.PremiumType)
+	}
+	grantID := *user.PremiumID
+
+	// A second grant updates the admin entitlement
+	monthEnd := time.Now().Add(30 * 24 * time.Hour)
+	if user, err = s.GrantPremium(ctx, actor, "user-1", "sappsr_pro_a_1m", &monthEnd); err != nil {
+		t.Fatal(err)
+	}
+	if *user.PremiumID != grantID || *user.PremiumType != "sappsr_pro_a_1m" {
+		t.Fatalf("second grant %s %s, want %s updated", *user.PremiumID, *user.PremiumType, grantID)
+	}
+
+	if user, err = s.RevokePremium(ctx, actor, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if user.PremiumID != nil {
+		t.Fatalf("premium_id = %s after revoke", *user.PremiumID)
+	}
+	if premiumType, expireDate := storeRow(); premiumType != "sappsr_pro_a_1w" || expireDate == nil || !expireDate.Equal(storeExpiry) {
+		t.Fatalf("RevenueCat transaction changed to %s until %v", premiumType, expireDate)
+	}
+
+	// Revoking a RevenueCat entitlement only detaches it
+	if _, err := db.Exec(ctx, `UPDATE users SET premium_id = 'rc-tx-1' WHERE id = 'user-1'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RevokePremium(ctx, actor, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, expireDate := storeRow(); expireDate == nil || !expireDate.Equal(storeExpiry) {
+		t.Fatalf("revoke moved the RevenueCat expiry to %v", expireDate)
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sapps/lib/util"
+	maindb "sapps/pkg/sapps/lib/db/main"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type CostAlert struct {
+	UserID string
+	Cost   float64
+}
+
+type CostAlertService struct {
+	db         *maindb.MainDB
+	webhookURL string
+	client     *http.Client
+}
+
+func NewCostAlertService(db *maindb.MainDB, webhookURL string) *CostAlertService {
+	return &CostAlertService{
+		db:         db,
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Check alerts about every user whose cost today reached threshold USD. Each
+// user is alerted at most once a day.
+func (s *CostAlertService) Check(ctx context.Context, threshold float64) ([]CostAlert, error) {
+	rows, err := s.db.Query(ctx, `
+		INSERT INTO cost_alerts (user_id, day, cost)
+		SELECT user_id, CURRENT_DATE, SUM(price) FROM costs
+		WHERE created_date >= CURRENT_DATE AND user_id IS NOT NULL
+		GROUP BY user_id
+		HAVING SUM(price) >= $1
+		ON CONFLICT (user_id, day) DO NOTHING
+		RETURNING user_id, cost
+	`, threshold)
+	if err != nil {
+		return nil, err
+	}
+	alerts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (CostAlert, error) {
+		var alert CostAlert
+		err := row.Scan(&alert.UserID, &alert.Cost)
+		return alert, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, alert := range alerts {
+		message := fmt.Sprintf("User %s spent $%.2f on AI today, over the $%.2f threshold", alert.UserID, alert.Cost, threshold)
+		util.LogErr(fmt.Errorf("%s", message), "critical")
+		if err := s.notify(ctx, message); err != nil {
+			util.LogErr(err)
+		}
+	}
+	return alerts, nil
+}
+
+func (s *CostAlertService) notify(ctx context.Context, message string) error {
+	if s.webhookURL == "" {
+		return nil
+	}
+	body, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send cost alert: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to send cost alert: status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"sapps/pkg/sapps/constant"
+	maindb "sapps/pkg/sapps/lib/db/main"
+
+	"github.com/openai/openai-go/v3"
+)
+
+const (
+	CostGroupByDay     = "day"
+	CostGroupByUser    = "user"
+	CostGroupByFeature = "feature"
+)
+
+var ErrInvalidCostGroupBy = errors.New("group_by must be one of day, user, feature")
+
+// OpenAICost is the USD price of a completion's token usage.
+func OpenAICost(usage openai.CompletionUsage) float64 {
+	return (float64(usage.PromptTokens)*constant.OPENAI_INPUT_COST_PER_MTOK +
+		float64(usage.CompletionTokens)*constant.OPENAI_OUTPUT_COST_PER_MTOK) / 1_000_000
+}
+
+// KieCost is the USD price of the credits a kie task consumed.
+func KieCost(credits int) float64 {
+	return float64(credits) * constant.KIE_CREDIT_COST
+}
+
+// CostReportRow compares the AI cost of a group with the revenue it brought
+// in. Revenue is nil for features, which revenue cannot be attributed to.
+type CostReportRow struct {
+	Key      string   `json:"key"`
+	Cost     float64  `json:"cost"`
+	Requests int      `json:"requests"`
+	Users    int      `json:"users"`
+	Revenue  *float64 `json:"revenue"`
+	Margin   *float64 `json:"margin"`
+}
+
+type CostReport struct {
+	Cost    float64         `json:"cost"`
+	Revenue float64         `json:"revenue"`
+	Margin  float64         `json:"margin"`
+	Rows    []CostReportRow `json:"rows"`
+}
+
+type CostReportService struct {
+	db *maindb.MainDB
+}
+
+func NewCostReportService(db *maindb.MainDB) *CostReportService {
+	return &CostReportService{db: db}
+}
+
+func ValidCostGroupBy(groupBy string) bool {
+	return groupBy == CostGroupByDay || groupBy == CostGroupByUser || groupBy == CostGroupByFeature
+}
+
+// Report returns the costs between from and to grouped by groupBy. Users are
+// ordered by cost and limited to the limit most expensive ones, the totals
+// always cover everything. Revenue excludes sandbox events and refunds.
+func (s *CostReportService) Report(ctx context.Context, from time.Time, to time.Time, groupBy string, limit int) (*CostReport, error) {
+	if !ValidCostGroupBy(groupBy) {
+		return nil, ErrInvalidCostGroupBy
+	}
+	keyColumn := map[string]string{
+		CostGroupByDay:     "to_char(created_date, 'YYYY-MM-DD')",
+		CostGroupByUser:    "COALESCE(user_id, '')",
+		CostGroupByFeature: "COALESCE(reason, '')",
+	}[groupBy]
+	rows, err := s.db.Query(ctx, `
+		SELECT `+keyColumn+` AS key, COALESCE(SUM(price), 0), COUNT(*), COUNT(DISTINCT user_id)
+		FROM costs
+		WHERE created_date >= $1 AND created_date < $2
+		GROUP BY key
+		ORDER BY 2 DESC
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &CostReport{Rows: []CostReportRow{}}
+	for rows.Next() {
+		var row CostReportRow
+		if err := rows.Scan(&row.Key, &row.Cost, &row.Requests, &row.Users); err != nil {
+			return nil, err
+		}
+		report.Cost += row.Cost
+		if groupBy != CostGroupByUser || len(report.Rows) < limit {
+			report.Rows = append(report.Rows, row)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	revenue, err := s.revenue(ctx, from, to, groupBy)
+	if err != nil {
+		return nil, err
+	}
+	for _, amount := range revenue {
+		report.Revenue += amount
+	}
+	if groupBy != CostGroupByFeature {
+		seen := map[string]bool{}
+		for i := range report.Rows {
+			row := &report.Rows[i]
+			seen[row.Key] = true
+			amount := roundMoney(revenue[row.Key])
+			margin := roundMoney(amount - row.Cost)
+			row.Revenue, row.Margin = &amount, &margin
+		}
+		// Days with revenue but no cost still belong in a daily report
+		if groupBy == CostGroupByDay {
+			for key, amount := range revenue {
+				if !seen[key] {
+					amount, margin := roundMoney(amount), roundMoney(amount)
+					report.Rows = append(report.Rows, CostReportRow{Key: key, Revenue: &amount, Margin: &margin})
+				}
+			}
+			sort.Slice(report.Rows, func(i, j int) bool {
+				return report.Rows[i].Key < report.Rows[j].Key
+			})
+		}
+	}
+	for i := range report.Rows {
+		report.Rows[i].Cost = roundCost(report.Rows[i].Cost)
+	}
+	report.Margin = roundMoney(report.Revenue - report.Cost)
+	report.Cost = roundCost(report.Cost)
+	report.Revenue = roundMoney(report.Revenue)
+	return report, nil
+}
+
+// revenue sums the revenue between from and to per day or per user id.
+func (s *CostReportService) revenue(ctx context.Context, from time.Time, to time.Time, groupBy string) (map[string]float64, error) {
+	keyColumn := "''"
+	switch groupBy {
+	case CostGroupByDay:
+		keyColumn = "to_char(to_timestamp(event_ms / 1000.0) AT TIME ZONE 'UTC', 'YYYY-MM-DD')"
+	case CostGroupByUser:
+		keyColumn = "COALESCE(user_id, '')"
+	}
+	rows, err := s.db.Query(ctx, `
+		WITH logs AS (
+			SELECT u.id AS user_id, l.event_type, l.price, l.other_data->>'cancel_reason' AS cancel_reason,
+				COALESCE(l.event_timestamp_ms, (EXTRACT(EPOCH FROM l.created_at) * 1000)::bigint) AS event_ms
+			FROM revenuecat_logs l
+			LEFT JOIN users u ON u.firebase_id = l.app_user_id
+			WHERE COALESCE(l.environment, '') <> $3
+		)
+		SELECT `+keyColumn+` AS key,
+			COALESCE(SUM(CASE
+				WHEN event_type IN ($4, $5, $6) THEN price
+				WHEN event_type = $7 OR (event_type = $8 AND cancel_reason = $9) THEN -ABS(price)
+				ELSE 0
+			END), 0)
+		FROM logs
+		WHERE event_ms >= $1 AND event_ms < $2
+		GROUP BY key
+	`, from.UnixMilli(), to.UnixMilli(), EnvironmentSandbox,
+		EventInitialPurchase, EventRenewal, EventNonRenewingPurchase, EventRefund, EventCancellation, cancelReasonCustomerSupport)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revenue := map[string]float64{}
+	for rows.Next() {
+		var key string
+		var amount float64
+		if err := rows.Scan(&key, &amount); err != nil {
+			return nil, err
+		}
+		revenue[key] = amount
+	}
+	return revenue, rows.Err()
+}
+
+// roundCost keeps sub cent precision, single requests cost fractions of a cent.
+func roundCost(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
@@ -0,0 +1,403 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"sapps/lib/util"
+	"sapps/pkg/sapps/constant"
+	"sapps/pkg/sapps/imagestore"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/queue"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	GenerativeAIStatusQueued    = "queued"
+	GenerativeAIStatusSubmitted = "submitted"
+	GenerativeAIStatusCompleted = "completed"
+	GenerativeAIStatusFailed    = "failed"
+	GenerativeAIStatusTimedOut  = "timed_out"
+	// Status of rows created before the job queue, equivalent to submitted
+	generativeAIStatusLegacyPending = "pending"
+)
+
+const (
+	JobSubmitGenerativeAITask = "submit_generative_ai_task"
+	generativeAIMaxAttempts   = 5
+	// A claim on a task whose result is being downloaded expires after this
+	// long, in case the process died while downloading
+	generativeAIClaimTimeout = 5 * time.Minute
+)
+
+type submitGenerativeAITaskPayload struct {
+	ID string `json:"id"`
+}
+
+type GenerativeAIService struct {
+	db    *maindb.MainDB
+	kie   *KieClient
+	store imagestore.Store
+	// download fetches kie results
+	download *http.Client
+}
+
+func NewGenerativeAIService(db *maindb.MainDB, kie *KieClient, store imagestore.Store) *GenerativeAIService {
+	return &GenerativeAIService{
+		db:       db,
+		kie:      kie,
+		store:    store,
+		download: resultClient(http.DefaultTransport),
+	}
+}
+
+// resultClient only follows redirects to the hosts kie results may be
+// downloaded from.
+func resultClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		Timeout:   60 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 || !allowedResultURL(req.URL) {
+				return fmt.Errorf("redirect to %s is not allowed", req.URL)
+			}
+			return nil
+		},
+	}
+}
+
+// Register adds the generative AI job handlers to the queue.
+func (s *GenerativeAIService) Register(q *queue.Queue) {
+	q.Register(JobSubmitGenerativeAITask, s.submit, s.submitFailed)
+}
+
+// EnqueueSubmitGenerativeAITask schedules submitting the queued generative_ai_tasks row to kie.
+func EnqueueSubmitGenerativeAITask(ctx context.Context, db queue.Execer, id string) error {
+	return queue.Enqueue(ctx, db, JobSubmitGenerativeAITask, submitGenerativeAITaskPayload{ID: id}, generativeAIMaxAttempts)
+}
+
+func (s *GenerativeAIService) submit(ctx context.Context, job queue.Job) error {
+	var payload submitGenerativeAITaskPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return queue.Permanent(err)
+	}
+
+	var imageID, prompt, status string
+	err := s.db.QueryRow(ctx, `
+		SELECT image_id, prompt, status FROM generative_ai_tasks WHERE id = $1
+	`, payload.ID).Scan(&imageID, &prompt, &status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// Deleted by the user before it was submitted
+			return nil
+		}
+		return err
+	}
+	if status != GenerativeAIStatusQueued {
+		return nil
+	}
+
+	imageURL := ImageURL(imageID)
+	kieReq := KieCreateTaskRequest{
+		Model:       "google/nano-banana-edit",
+		CallBackURL: KieCallbackURL(payload.ID),
+		Input: KieCreateTaskInput{
+			ImageUrls:    []string{imageURL},
+			Prompt:       prompt,
+			OutputFormat: "jpeg",
+			ImageSize:    "auto",
+		},
+	}
+
+	taskID, err := s.kie.CreateTask(ctx, kieReq)
+	if err != nil {
+		var apiErr *KieAPIError
+		if errors.As(err, &apiErr) && !apiErr.Retryable() {
+			return queue.Permanent(err)
+		}
+		return err
+	}
+
+	_, err = s.db.Exec(ctx, `
+		UPDATE generative_ai_tasks SET task_id = $1, status = $2, submitted_at = NOW() WHERE id = $3 AND status = $4
+	`, taskID, GenerativeAIStatusSubmitted, payload.ID, GenerativeAIStatusQueued)
+	return err
+}
+
+// KieCallbackURL is the webhook kie calls for the task row, carrying a token
+// that only this server can produce for that row.
+func KieCallbackURL(id string) string {
+	query := url.Values{}
+	query.Set("id", id)
+	query.Set("token", kieCallbackToken(id))
+	return fmt.Sprintf("%s/webhook/kie/callback?%s", constant.API_URL, query.Encode())
+}
+
+func VerifyKieCallbackToken(id string, token string) bool {
+	return id != "" && util.VerifyHMAC(constant.KIE_CALLBACK_SECRET, "kie-callback:"+id, token)
+}
+
+func kieCallbackToken(id string) string {
+	return util.SignHMAC(constant.KIE_CALLBACK_SECRET, "kie-callback:"+id)
+}
+
+func (s *GenerativeAIService) submitFailed(ctx context.Context, job queue.Job, jobErr error) {
+	var payload submitGenerativeAITaskPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		util.LogErr(err)
+		return
+	}
+	err := s.finish(ctx, payload.ID, GenerativeAIStatusFailed, func(tx pgx.Tx) (pgconn.CommandTag, error) {
+		return tx.Exec(ctx, `
+			UPDATE generative_ai_tasks SET status = $1, raw_response = $2, completed_at = NOW() WHERE id = $3 AND status = $4
+		`, GenerativeAIStatusFailed, jobErr.Error(), payload.ID, GenerativeAIStatusQueued)
+	})
+	if err != nil {
+		util.LogErr(err)
+	}
+}
+
+// finish runs the update moving the task with the given id out of its
+// pending state, and refunds the coins and quota use spent on it when the new
+// status is not completed. Both happen in one transaction, so a task whose
+// update matched no row, because it was already finished, is never refunded
+// twice.
+func (s *GenerativeAIService) finish(ctx context.Context, id string, status string, update func(tx pgx.Tx) (pgconn.CommandTag, error)) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := update(tx)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 && status != GenerativeAIStatusCompleted {
+		if err := maindb.RefundCoins(ctx, tx, maindb.CoinReasonGeneration, id, maindb.CoinReasonGenerationRefund); err != nil {
+			return err
+		}
+		if err := maindb.ReleaseUsage(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// CompleteTask applies a finished kie task to its row: the result image is
+// downloaded and re-encoded locally and the status moves to completed or
+// failed. The task is claimed before downloading, so when the callback and
+// the reconciler deliver the same result only one of them downloads it, and
+// tasks that already left the submitted state are left untouched.
+func (s *GenerativeAIService) CompleteTask(ctx context.Context, record *KieTaskRecord) error {
+	var id, userID, ipAddress string
+	err := s.db.QueryRow(ctx, `
+		UPDATE generative_ai_tasks SET claimed_at = NOW()
+		WHERE task_id = $1 AND status IN ($2, $3) AND (claimed_at IS NULL OR claimed_at < NOW() - make_interval(secs => $4))
+		RETURNING id, user_id, COALESCE(ip_address, '')
+	`, record.Data.TaskID, GenerativeAIStatusSubmitted, generativeAIStatusLegacyPending, generativeAIClaimTimeout.Seconds()).Scan(&id, &userID, &ipAddress)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// Unknown, finished or being completed by someone else
+			return nil
+		}
+		return err
+	}
+
+	status := GenerativeAIStatusFailed
+	if record.Code == 200 && record.Data.State == "success" {
+		status = GenerativeAIStatusCompleted
+	}
+
+	var resultURL string
+	var result *resultImage
+	if record.Data.ResultJSON != "" {
+		var resultJSON KieResultJSON
+		if err := json.Unmarshal([]byte(record.Data.ResultJSON), &resultJSON); err == nil {
+			if len(resultJSON.ResultURLs) > 0 {
+				externalURL := resultJSON.ResultURLs[0]
+				result, err = s.downloadAndSaveImage(ctx, externalURL)
+				if err != nil {
+					util.LogErr(err)
+					status = GenerativeAIStatusFailed
+				} else {
+					// Stored unsigned, readers sign it with SignResultURL
+					resultURL = fmt.Sprintf("%s%s%s.jpg", constant.API_URL, imageURLPrefix, result.ID)
+				}
+			}
+		}
+	}
+
+	return s.finish(ctx, id, status, func(tx pgx.Tx) (pgconn.CommandTag, error) {
+		tag, err := tx.Exec(ctx, `
+			UPDATE generative_ai_tasks 
+			SET status = $1, result_url = $2, completed_at = NOW(), raw_response = $3
+			WHERE id = $4 AND status IN ($5, $6)
+		`, status, resultURL, record.Data.ResultJSON, id, GenerativeAIStatusSubmitted, generativeAIStatusLegacyPending)
+		if err != nil || tag.RowsAffected() == 0 {
+			return tag, err
+		}
+		if result != nil {
+			// Results belong to the user like uploads, they can be scanned or
+			// edited again
+			_, err = tx.Exec(ctx, `
+				INSERT INTO images (id, user_id, size, content_type, hash, width, height, format) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			`, result.ID, userID, result.Size, "image/jpeg", result.Hash, result.Width, result.Height, "jpeg")
+			if err != nil {
+				return tag, err
+			}
+		}
+		if record.Data.ConsumeCredits <= 0 {
+			return tag, nil
+		}
+		// Failed tasks may still have consumed credits
+		return tag, maindb.InsertCost(ctx, tx, userID, KieCost(record.Data.ConsumeCredits), maindb.CostReasonGeneration, id, ipAddress)
+	})
+}
+
+// Reconcile polls kie for tasks whose callback has not arrived within
+// reconcileAfter of submitting them, and marks the ones submitted longer than
+// timeout ago as timed out.
+func (s *GenerativeAIService) Reconcile(ctx context.Context, reconcileAfter time.Duration, timeout time.Duration) error {
+	// Rows from before submitted_at was recorded fall back to created_at
+	rows, err := s.db.Query(ctx, `
+		SELECT id, task_id, COALESCE(submitted_at, created_at) AS submitted FROM generative_ai_tasks
+		WHERE status IN ($1, $2) AND task_id IS NOT NULL AND COALESCE(submitted_at, created_at) < NOW() - make_interval(secs => $3)
+		ORDER BY submitted
+		LIMIT 100
+	`, GenerativeAIStatusSubmitted, generativeAIStatusLegacyPending, reconcileAfter.Seconds())
+	if err != nil {
+		return err
+	}
+	type pendingTask struct {
+		id          string
+		taskID      string
+		submittedAt time.Time
+	}
+	var tasks []pendingTask
+	for rows.Next() {
+		var task pendingTask
+		if err := rows.Scan(&task.id, &task.taskID, &task.submittedAt); err != nil {
+			util.LogErr(err)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		record, err := s.kie.GetTask(ctx, task.taskID)
+		if err != nil {
+			util.LogErr(fmt.Errorf("failed to get kie task %s: %w", task.taskID, err))
+		} else if record.Finished() {
+			if err := s.CompleteTask(ctx, record); err != nil {
+				util.LogErr(err)
+			}
+			continue
+		}
+
+		if time.Since(task.submittedAt) > timeout {
+			// A task whose result is being downloaded is not timed out
+			err := s.finish(ctx, task.id, GenerativeAIStatusTimedOut, func(tx pgx.Tx) (pgconn.CommandTag, error) {
+				return tx.Exec(ctx, `
+					UPDATE generative_ai_tasks SET status = $1, completed_at = NOW()
+					WHERE id = $2 AND status IN ($3, $4) AND (claimed_at IS NULL OR claimed_at < NOW() - make_interval(secs => $5))
+				`, GenerativeAIStatusTimedOut, task.id, GenerativeAIStatusSubmitted, generativeAIStatusLegacyPending, generativeAIClaimTimeout.Seconds())
+			})
+			if err != nil {
+				util.LogErr(err)
+			}
+		}
+	}
+	return nil
+}
+
+// allowedResultURL reports whether a kie result may be downloaded from the
+// URL, so callbacks cannot make the server fetch arbitrary addresses.
+func allowedResultURL(resultURL *url.URL) bool {
+	if resultURL.Scheme != "https" || resultURL.User != nil {
+		return false
+	}
+	host := strings.ToLower(resultURL.Hostname())
+	for _, allowed := range constant.KIE_RESULT_HOSTS {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, ".") && (strings.HasSuffix(host, allowed) || host == allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// resultImage is a kie result copied into the image store.
+type resultImage struct {
+	ID     string
+	Hash   string
+	Size   int
+	Width  int
+	Height int
+}
+
+// downloadAndSaveImage copies a kie result into the image store.
+func (s *GenerativeAIService) downloadAndSaveImage(ctx context.Context, externalURL string) (*resultImage, error) {
+	parsedURL, err := url.Parse(externalURL)
+	if err != nil || !allowedResultURL(parsedURL) {
+		return nil, fmt.Errorf("result url is not allowed: %s", externalURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", parsedURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.download.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image: status %d", resp.StatusCode)
+	}
+
+	tempFile, err := os.CreateTemp("", "kie-image-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	_, err = io.Copy(tempFile, resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save temp image: %w", err)
+	}
+
+	tempFile.Seek(0, 0)
+	img, _, err := image.Decode(tempFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	result := &resultImage{ID: uuid.New().String(), Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+	result.Hash, result.Size, err = imagestore.PutJPEG(ctx, s.store, result.ID+".jpg", img, 97)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save image: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sapps/pkg/sapps/constant"
+	"sapps/pkg/sapps/imagestore"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/lib/db/main/maindbtest"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// fakeKie answers task status requests with records and serves a 40x30 JPEG
+// as the result of every task. When release is set downloads wait for it.
+type fakeKie struct {
+	*httptest.Server
+	mu        sync.Mutex
+	records   map[string]KieTaskRecord
+	gets      []string
+	downloads atomic.Int32
+	started   chan struct{}
+	release   chan struct{}
+}
+
+func newFakeKie(t *testing.T) *fakeKie {
+	t.Helper()
+	var result bytes.Buffer
+	if err := jpeg.Encode(&result, image.NewRGBA(image.Rect(0, 0, 40, 30)), nil); err != nil {
+		t.Fatal(err)
+	}
+	kie := &fakeKie{records: map[string]KieTaskRecord{}}
+	kie.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/jobs/recordInfo" {
+			taskID := r.URL.Query().Get("taskId")
+			kie.mu.Lock()
+			kie.gets = append(kie.gets, taskID)
+			record, ok := kie.records[taskID]
+			kie.mu.Unlock()
+			if !ok {
+				record = KieTaskRecord{Code: 200, Data: KieTaskData{TaskID: taskID, State: "generating"}}
+			}
+			_ = json.NewEncoder(w).Encode(record)
+			return
+		}
+		kie.downloads.Add(1)
+		if kie.release != nil {
+			kie.started <- struct{}{}
+			<-kie.release
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(result.Bytes())
+	}))
+	t.Cleanup(kie.Close)
+
+	hosts := constant.KIE_RESULT_HOSTS
+	constant.KIE_RESULT_HOSTS = []string{"127.0.0.1"}
+	t.Cleanup(func() { constant.KIE_RESULT_HOSTS = hosts })
+	return kie
+}
+
+func (k *fakeKie) success(taskID string) *KieTaskRecord {
+	return &KieTaskRecord{Code: 200, Data: KieTaskData{
+		TaskID:     taskID,
+		State:      "success",
+		ResultJSON: `{"resultUrls":["` + k.URL + `/result.jpg"]}`,
+	}}
+}
+
+func newTestGenerativeAIService(db *maindb.MainDB, kie *fakeKie) (*GenerativeAIService, imagestore.Store) {
+	store := imagestore.NewMemoryStore()
+	s := NewGenerativeAIService(db, &KieClient{BaseURL: kie.URL, Client: kie.Client()}, store)
+	s.download = resultClient(kie.Client().Transport)
+	return s, store
+}
+
+// insertGenerativeAITask inserts a task created and submitted to kie the given
+// durations ago.
+func insertGenerativeAITask(t *testing.T, db *maindb.MainDB, userID string, created time.Duration, submitted time.Duration) (string, string) {
+	t.Helper()
+	id, taskID := uuid.New().String(), uuid.New().String()
+	_, err := db.Exec(context.Background(), `
+		INSERT INTO generative_ai_tasks (id, user_id, image_id, prompt, task_id, status, created_at, submitted_at)
+		VALUES ($1, $2, $3, 'prompt', $4, $5, NOW() - make_interval(secs => $6), NOW() - make_interval(secs => $7))
+	`, id, userID, uuid.New().String(), taskID, GenerativeAIStatusSubmitted, created.Seconds(), submitted.Seconds())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, taskID
+}
+
+func generativeAIStatus(t *testing.T, db *maindb.MainDB, id string) string {
+	t.Helper()
+	var status string
+	if err := db.QueryRow(context.Background(), `SELECT status FROM generative_ai_tasks WHERE id = $1`, id).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestCompleteTaskStoresResult(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	kie := newFakeKie(t)
+	s, store := newTestGenerativeAIService(db, kie)
+	id, taskID := insertGenerativeAITask(t, db, "user-1", time.Minute, time.Minute)
+
+	if err := s.CompleteTask(ctx, kie.success(taskID)); err != nil {
+		t.Fatal(err)
+	}
+	var status, resultURL string
+	err := db.QueryRow(ctx, `SELECT status, result_url FROM generative_ai_tasks WHERE id = $1`, id).Scan(&status, &resultURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageID, ok := strings.CutPrefix(resultURL, constant.API_URL+imageURLPrefix)
+	if status != GenerativeAIStatusCompleted || !ok {
+		t.Fatalf("task is %s with result %s", status, resultURL)
+	}
+	imageID = strings.TrimSuffix(imageID, ".jpg")
+
+	object, err := store.Get(ctx, imageID+".jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID, hash, format string
+	var size, width, height int
+	err = db.QueryRow(ctx, `
+		SELECT user_id, hash, size, width, height, format FROM images WHERE id = $1
+	`, imageID).Scan(&userID, &hash, &size, &width, &height, &format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != "user-1" || hash != object.Hash || size != len(object.Data) || width != 40 || height != 30 || format != "jpeg" {
+		t.Fatalf("images row %s %s %d %dx%d %s, stored %s", userID, hash, size, width, height, format, object.Hash)
+	}
+
+	// The result delivered again is ignored
+	if err := s.CompleteTask(ctx, kie.success(taskID)); err != nil || kie.downloads.Load() != 1 {
+		t.Fatalf("completed twice: %v, %d downloads", err, kie.downloads.Load())
+	}
+}
+
+func TestCompleteTaskClaimsTask(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	kie := newFakeKie(t)
+	kie.started = make(chan struct{})
+	kie.release = make(chan struct{})
+	s, _ := newTestGenerativeAIService(db, kie)
+	id, taskID := insertGenerativeAITask(t, db, "user-1", time.Minute, time.Minute)
+
+	done := make(chan error)
+	go func() {
+		done <- s.CompleteTask(ctx, kie.success(taskID))
+	}()
+	<-kie.started
+
+	// The reconciler finds the result while the callback downloads it, a
+	// second download would block until the deadline
+	reconcileCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := s.CompleteTask(reconcileCtx, kie.success(taskID)); err != nil {
+		t.Fatal(err)
+	}
+	close(kie.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := kie.downloads.Load(); n != 1 {
+		t.Fatalf("%d downloads, want 1", n)
+	}
+	if status := generativeAIStatus(t, db, id); status != GenerativeAIStatusCompleted {
+		t.Fatalf("task is %s", status)
+	}
+}
+
+func TestReconcileMeasuresFromSubmit(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	kie := newFakeKie(t)
+	s, _ := newTestGenerativeAIService(db, kie)
+
+	// Queued for a long time but only just submitted
+	_, recentTask := insertGenerativeAITask(t, db, "user-1", 3*time.Hour, time.Minute)
+	// Submitted long enough ago to poll, not to time out
+	pollID, _ := insertGenerativeAITask(t, db, "user-1", 3*time.Hour, 20*time.Minute)
+	// Submitted longer ago than the timeout
+	timedOutID, _ := insertGenerativeAITask(t, db, "user-1", 3*time.Hour, 2*time.Hour)
+	// Finished while waiting for the callback
+	finishedID, finishedTask := insertGenerativeAITask(t, db, "user-1", 3*time.Hour, 30*time.Minute)
+	kie.records[finishedTask] = *kie.success(finishedTask)
+	for _, id := range []string{timedOutID, finishedID} {
+		_, err := db.Exec(ctx, `INSERT INTO usage_events (user_id, feature, reference_id) VALUES ('user-1', 'generation', $1)`, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Reconcile(ctx, 10*time.Minute, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, taskID := range kie.gets {
+		if taskID == recentTask {
+			t.Fatal("polled a task submitted a minute ago")
+		}
+	}
+	if len(kie.gets) != 3 {
+		t.Fatalf("polled %d tasks, want 3", len(kie.gets))
+	}
+	want := map[string]string{
+		pollID:     GenerativeAIStatusSubmitted,
+		timedOutID: GenerativeAIStatusTimedOut,
+		finishedID: GenerativeAIStatusCompleted,
+	}
+	for id, status := range want {
+		if got := generativeAIStatus(t, db, id); got != status {
+			t.Errorf("task %s is %s, want %s", id, got, status)
+		}
+	}
+	// Only the timed out task gives its quota use back
+	rows, err := db.Query(ctx, `SELECT reference_id FROM usage_events`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uses, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uses) != 1 || uses[0] != finishedID {
+		t.Fatalf("uses left for %v, want %s", uses, finishedID)
+	}
+}
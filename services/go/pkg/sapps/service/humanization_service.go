@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"sapps/lib/util"
+	"sapps/pkg/sapps/detector"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/model"
+	"sapps/pkg/sapps/queue"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/shared"
+)
+
+const (
+	HumanizationStatusProcessing = "processing"
+	HumanizationStatusCompleted  = "completed"
+	HumanizationStatusFailed     = "failed"
+)
+
+const humanizeSystemPrompt = `You are an expert editor. Rewrite the text provided by the user so that it reads as if a human wrote it.
+
+- Keep the original meaning, facts and language of the text.
+- Vary sentence length and structure, prefer plain words over formal ones and avoid filler phrases.
+- Do not add headings, comments, quotes or explanations.
+
+Respond ONLY with the rewritten text.`
+
+const detectionSystemPrompt = `You are an AI-generated text detector. Estimate the probability that the text provided by the user was written by an AI model.
+
+Respond ONLY in JSON format with the following structure:
+{"ai_probability": X}
+
+Replace X with a number between 0.0 (definitely human) and 1.0 (definitely AI).`
+
+const (
+	JobProcessHumanization  = "process_humanization"
+	humanizationMaxAttempts = 3
+	humanizationTimeout     = 5 * time.Minute
+	chatGPTDetectionService = "ChatGPT"
+	likelyHumanBelow        = 0.3
+	likelyAIAbove           = 0.7
+)
+
+type processHumanizationPayload struct {
+	ID string `json:"id"`
+}
+
+// Completer is the part of connection.ChatGPT humanizations use.
+type Completer interface {
+	GenerateCompletionWithHistory(ctx context.Context, model shared.ChatModel, params []openai.ChatCompletionMessageParamUnion, responseFormat openai.ChatCompletionNewParamsResponseFormatUnion, temperature float64) (string, openai.CompletionUsage, error)
+}
+
+type HumanizationService struct {
+	db       *maindb.MainDB
+	chatGPT  Completer
+	detector *detector.Aggregator
+}
+
+func NewHumanizationService(db *maindb.MainDB, chatGPT Completer, detector *detector.Aggregator) *HumanizationService {
+	return &HumanizationService{
+		db:       db,
+		chatGPT:  chatGPT,
+		detector: detector,
+	}
+}
+
+// Register adds the humanization job handlers to the queue.
+func (s *HumanizationService) Register(q *queue.Queue) {
+	q.Register(JobProcessHumanization, s.process, s.processFailed)
+}
+
+// EnqueueProcessHumanization schedules humanizing the processing
+// humanizations row.
+func EnqueueProcessHumanization(ctx context.Context, db queue.Execer, humanizationID string) error {
+	return queue.Enqueue(ctx, db, JobProcessHumanization, processHumanizationPayload{ID: humanizationID}, humanizationMaxAttempts)
+}
+
+func (s *HumanizationService) process(ctx context.Context, job queue.Job) error {
+	var payload processHumanizationPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return queue.Permanent(err)
+	}
+
+	var inputText, status string
+	err := s.db.QueryRow(ctx, `
+		SELECT input_text, status FROM humanizations WHERE id = $1
+	`, payload.ID).Scan(&inputText, &status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if status != HumanizationStatusProcessing {
+		return nil
+	}
+
+	processCtx, cancel := context.WithTimeout(ctx, humanizationTimeout)
+	defer cancel()
+	return s.Process(processCtx, payload.ID, inputText)
+}
+
+func (s *HumanizationService) processFailed(ctx context.Context, job queue.Job, jobErr error) {
+	var payload processHumanizationPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		util.LogErr(err)
+		return
+	}
+	_, err := s.db.Exec(ctx, `
+		UPDATE humanizations SET status = $1, processed_date = NOW() WHERE id = $2 AND status = $3
+	`, HumanizationStatusFailed, payload.ID, HumanizationStatusProcessing)
+	if err != nil {
+		util.LogErr(err)
+	}
+}
+
+// Process humanizes the input text of a processing row, scores the text
+// before and after, and completes the row. Errors leave the row processing
+// for the job to retry.
+func (s *HumanizationService) Process(ctx context.Context, humanizationID string, inputText string) error {
+	originalProbability, originalDetection, err := s.detect(ctx, inputText)
+	if err != nil {
+		return err
+	}
+
+	outputText, _, err := s.chatGPT.GenerateCompletionWithHistory(
+		ctx,
+		shared.ChatModelGPT5,
+		[]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(humanizeSystemPrompt),
+			openai.UserMessage(inputText),
+		},
+		openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfText: &openai.ResponseFormatTextParam{},
+		},
+		1,
+	)
+	if err != nil {
+		return err
+	}
+	outputText = strings.TrimSpace(outputText)
+	if outputText == "" {
+		return errors.New("empty humanized text")
+	}
+
+	finalProbability, finalDetection, err := s.detect(ctx, outputText)
+	if err != nil {
+		return err
+	}
+	improvementScore := ImprovementScore(originalProbability, finalProbability)
+
+	_, err = s.db.Exec(ctx, `
+		UPDATE humanizations
+		SET output_text = $1, original_detection = $2, final_detection = $3, improvement_score = $4, status = $5, processed_date = NOW()
+		WHERE id = $6 AND status = $7
+	`, outputText, originalDetection, finalDetection, improvementScore, HumanizationStatusCompleted, humanizationID, HumanizationStatusProcessing)
+	return err
+}
+
+// detect scores the text with the configured detection providers and falls
+// back to asking ChatGPT when none are configured. It returns the merged
+// probability and the per-service scores as stored in the row.
+func (s *HumanizationService) detect(ctx context.Context, text string) (float64, string, error) {
+	if s.detector.Enabled() {
+		detection, err := s.detector.Detect(ctx, text)
+		if err != nil {
+			return 0, "", err
+		}
+		detectionJSON, err := DetectionJSON(detection.ServiceResults)
+		return detection.AIProbability, detectionJSON, err
+	}
+
+	response, _, err := s.chatGPT.GenerateCompletionWithHistory(
+		ctx,
+		shared.ChatModelGPT5Mini,
+		[]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(detectionSystemPrompt),
+			openai.UserMessage(text),
+		},
+		openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONObject: &openai.ResponseFormatJSONObjectParam{},
+		},
+		1,
+	)
+	if err != nil {
+		return 0, "", err
+	}
+	var result struct {
+		AIProbability *float64 `json:"ai_probability"`
+	}
+	if err := json.Unmarshal([]byte(response), &result); err != nil {
+		return 0, "", fmt.Errorf("failed to parse detection response: %w", err)
+	}
+	if result.AIProbability == nil {
+		return 0, "", errors.New("detection response has no ai_probability")
+	}
+	probability := math.Min(math.Max(*result.AIProbability, 0), 1)
+	detectionJSON, err := json.Marshal([]model.ServiceDetection{{
+		Service:    chatGPTDetectionService,
+		Score:      probability,
+		Confidence: DetectionConfidence(probability),
+	}})
+	return probability, string(detectionJSON), err
+}
+
+// DetectionJSON is the JSON array of the scores of the providers that
+// answered, the format of original_detection and final_detection.
+func DetectionJSON(results model.ServiceResults) (string, error) {
+	detections := []model.ServiceDetection{}
+	results.Each(func(service string, result *model.ServiceResult) {
+		if result.AIProbability == nil {
+			return
+		}
+		detections = append(detections, model.ServiceDetection{
+			Service:    service,
+			Score:      *result.AIProbability,
+			Confidence: DetectionConfidence(*result.AIProbability),
+		})
+	})
+	detectionJSON, err := json.Marshal(detections)
+	return string(detectionJSON), err
+}
+
+// DetectionConfidence labels an AI probability the way clients show it.
+func DetectionConfidence(probability float64) string {
+	switch {
+	case probability < likelyHumanBelow:
+		return "Likely Human"
+	case probability > likelyAIAbove:
+		return "Likely AI"
+	}
+	return "Uncertain"
+}
+
+// ImprovementScore is the percentage reduction in AI detection probability.
+func ImprovementScore(originalDetection, finalDetection float64) float64 {
+	if originalDetection <= 0 {
+		return 0
+	}
+	score := (originalDetection - finalDetection) / originalDetection * 100
+	return math.Round(math.Max(score, 0)*100) / 100
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"sapps/pkg/sapps/detector"
+	"sapps/pkg/sapps/lib/db/main/maindbtest"
+	"sapps/pkg/sapps/model"
+	"sapps/pkg/sapps/queue"
+
+	"github.com/google/uuid"
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/shared"
+)
+
+// fakeCompleter answers humanizations with output and detections with
+// detection.
+type fakeCompleter struct {
+	output    string
+	detection string
+	err       error
+}
+
+func (f *fakeCompleter) GenerateCompletionWithHistory(ctx context.Context, model shared.ChatModel, params []openai.ChatCompletionMessageParamUnion, responseFormat openai.ChatCompletionNewParamsResponseFormatUnion, temperature float64) (string, openai.CompletionUsage, error) {
+	if f.err != nil {
+		return "", openai.CompletionUsage{}, f.err
+	}
+	if responseFormat.OfJSONObject != nil {
+		return f.detection, openai.CompletionUsage{}, nil
+	}
+	return f.output, openai.CompletionUsage{}, nil
+}
+
+// fakeDetector scores the humanized text lower than the input.
+type fakeDetector struct {
+	name  string
+	input string
+	err   error
+}
+
+func (d *fakeDetector) Name() string {
+	return d.name
+}
+
+func (d *fakeDetector) Detect(ctx context.Context, text string) (*detector.Result, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if text == d.input {
+		return &detector.Result{AIProbability: 0.8}, nil
+	}
+	return &detector.Result{AIProbability: 0.2}, nil
+}
+
+func TestDetectionConfidence(t *testing.T) {
+	for probability, want := range map[float64]string{
+		0:    "Likely Human",
+		0.29: "Likely Human",
+		0.3:  "Uncertain",
+		0.7:  "Uncertain",
+		0.71: "Likely AI",
+		1:    "Likely AI",
+	} {
+		if got := DetectionConfidence(probability); got != want {
+			t.Errorf("DetectionConfidence(%v) = %s, want %s", probability, got, want)
+		}
+	}
+}
+
+func TestDetectionJSON(t *testing.T) {
+	sapling, gptZero := 0.85, 0.2
+	got, err := DetectionJSON(model.ServiceResults{
+		GPTZero:   &model.ServiceResult{AIProbability: &gptZero},
+		Sapling:   &model.ServiceResult{AIProbability: &sapling},
+		Copyleaks: &model.ServiceResult{Error: "timeout"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"service":"GPTZero","score":0.2,"confidence":"Likely Human"},{"service":"Sapling","score":0.85,"confidence":"Likely AI"}]`
+	if got != want {
+		t.Errorf("DetectionJSON = %s, want %s", got, want)
+	}
+
+	if got, _ := DetectionJSON(model.ServiceResults{}); got != "[]" {
+		t.Errorf("DetectionJSON without providers = %s, want []", got)
+	}
+}
+
+func TestImprovementScore(t *testing.T) {
+	if got := ImprovementScore(0.8, 0.2); got != 75 {
+		t.Errorf("ImprovementScore = %v, want 75", got)
+	}
+	if got := ImprovementScore(0.2, 0.8); got != 0 {
+		t.Errorf("worse text = %v, want 0", got)
+	}
+	if got := ImprovementScore(0, 0.5); got != 0 {
+		t.Errorf("human input = %v, want 0", got)
+	}
+}
+
+func TestHumanizationProcess(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	input := "Artificial intelligence has revolutionized data analysis."
+
+	insert := func() string {
+		t.Helper()
+		id := uuid.New().String()
+		_, err := db.Exec(ctx, `INSERT INTO humanizations (id, user_id, input_text, status) VALUES ($1, $2, $3, $4)`,
+			id, "user-1", input, HumanizationStatusProcessing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	job := func(id string) queue.Job {
+		payload, _ := json.Marshal(processHumanizationPayload{ID: id})
+		return queue.Job{Kind: JobProcessHumanization, Payload: payload}
+	}
+	row := func(id string) model.Humanization {
+		t.Helper()
+		var h model.Humanization
+		err := db.QueryRow(ctx, `
+			SELECT output_text, original_detection, final_detection, improvement_score, status, processed_date FROM humanizations WHERE id = $1
+		`, id).Scan(&h.OutputText, &h.OriginalDetection, &h.FinalDetection, &h.ImprovementScore, &h.Status, &h.ProcessedDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	t.Run("providers", func(t *testing.T) {
+		aggregator := detector.NewAggregator(time.Second,
+			&fakeDetector{name: detector.SaplingName, input: input},
+			&fakeDetector{name: detector.GPTZeroName, err: errors.New("boom")},
+		)
+		s := NewHumanizationService(db, &fakeCompleter{output: " Humanized text. "}, aggregator)
+		id := insert()
+		if err := s.process(ctx, job(id)); err != nil {
+			t.Fatal(err)
+		}
+		h := row(id)
+		if h.Status != HumanizationStatusCompleted || h.ProcessedDate == nil || *h.OutputText != "Humanized text." {
+			t.Fatalf("unexpected row %+v", h)
+		}
+		if want := `[{"service":"Sapling","score":0.8,"confidence":"Likely AI"}]`; *h.OriginalDetection != want {
+			t.Errorf("original_detection = %s, want %s", *h.OriginalDetection, want)
+		}
+		if want := `[{"service":"Sapling","score":0.2,"confidence":"Likely Human"}]`; *h.FinalDetection != want {
+			t.Errorf("final_detection = %s, want %s", *h.FinalDetection, want)
+		}
+		if *h.ImprovementScore != 75 {
+			t.Errorf("improvement_score = %v, want 75", *h.ImprovementScore)
+		}
+	})
+
+	t.Run("chatgpt fallback", func(t *testing.T) {
+		s := NewHumanizationService(db, &fakeCompleter{output: "Humanized text.", detection: `{"ai_probability":0.5}`}, nil)
+		id := insert()
+		if err := s.process(ctx, job(id)); err != nil {
+			t.Fatal(err)
+		}
+		if want := `[{"service":"ChatGPT","score":0.5,"confidence":"Uncertain"}]`; *row(id).FinalDetection != want {
+			t.Errorf("final_detection = %s, want %s", *row(id).FinalDetection, want)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		s := NewHumanizationService(db, &fakeCompleter{err: errors.New("openai down")}, nil)
+		id := insert()
+		err := s.process(ctx, job(id))
+		if err == nil {
+			t.Fatal("expected an error to retry")
+		}
+		if h := row(id); h.Status != HumanizationStatusProcessing {
+			t.Fatalf("status before the last attempt = %s, want processing", h.Status)
+		}
+		s.processFailed(ctx, job(id), err)
+		if h := row(id); h.Status != HumanizationStatusFailed || h.ProcessedDate == nil {
+			t.Fatalf("unexpected failed row %+v", h)
+		}
+	})
+
+	t.Run("finished rows are skipped", func(t *testing.T) {
+		s := NewHumanizationService(db, &fakeCompleter{err: errors.New("must not be called")}, nil)
+		id := insert()
+		if _, err := db.Exec(ctx, `UPDATE humanizations SET status = $1 WHERE id = $2`, HumanizationStatusFailed, id); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.process(ctx, job(id)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.process(ctx, job(uuid.New().String())); err != nil {
+			t.Fatalf("deleted row: %v", err)
+		}
+	})
+}
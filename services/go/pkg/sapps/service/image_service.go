@@ -0,0 +1,95 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"sapps/pkg/sapps/imagestore"
+	maindb "sapps/pkg/sapps/lib/db/main"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrImageNotFound        = errors.New("image not found")
+	ErrImageNotOwned        = errors.New("image belongs to another user")
+	ErrUnsupportedImageType = errors.New("unsupported image type")
+)
+
+// imageTypes are the types scans and generations can read, OpenAI and kie
+// accept all of them.
+var imageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// ResolvedImage is an uploaded image its user may use.
+type ResolvedImage struct {
+	ID     string
+	Object *imagestore.Object
+}
+
+// Decode decodes the stored image.
+func (i *ResolvedImage) Decode() (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(i.Object.Data))
+	return img, err
+}
+
+// ImageResolver resolves the image_id of scan and generation requests.
+type ImageResolver interface {
+	Resolve(ctx context.Context, userID string, imageID string) (*ResolvedImage, error)
+}
+
+// ImageService resolves the image_id requests name, features must not
+// proceed with an image the user did not upload.
+type ImageService struct {
+	db    *maindb.MainDB
+	store imagestore.Store
+}
+
+func NewImageService(db *maindb.MainDB, store imagestore.Store) *ImageService {
+	return &ImageService{db: db, store: store}
+}
+
+func InjectImageResolver(db *maindb.MainDB, store imagestore.Store) ImageResolver {
+	return NewImageService(db, store)
+}
+
+// Resolve returns the image when it was uploaded by userID, is still stored
+// and is of a type the features read.
+func (s *ImageService) Resolve(ctx context.Context, userID string, imageID string) (*ResolvedImage, error) {
+	if parsed, err := uuid.Parse(imageID); err != nil || parsed.String() != imageID {
+		return nil, ErrImageNotFound
+	}
+	var ownerID *string
+	err := s.db.QueryRow(ctx, `SELECT user_id FROM images WHERE id = $1`, imageID).Scan(&ownerID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	if ownerID == nil || *ownerID != userID {
+		return nil, ErrImageNotOwned
+	}
+
+	object, err := s.store.Get(ctx, imageID+".jpg")
+	if err != nil {
+		if errors.Is(err, imagestore.ErrNotFound) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	// The stored bytes decide, not the content type recorded at upload
+	if !imageTypes[http.DetectContentType(object.Data)] {
+		return nil, ErrUnsupportedImageType
+	}
+	return &ResolvedImage{ID: imageID, Object: object}, nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+
+	"sapps/pkg/sapps/imagestore"
+	"sapps/pkg/sapps/lib/db/main/maindbtest"
+
+	"github.com/google/uuid"
+)
+
+func TestImageServiceResolve(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	store := imagestore.NewMemoryStore()
+	s := NewImageService(db, store)
+
+	upload := func(userID string, data []byte) string {
+		t.Helper()
+		id := uuid.New().String()
+		if data != nil {
+			if _, err := store.Put(ctx, id+".jpg", data, "image/jpeg"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.Exec(ctx, `INSERT INTO images (id, user_id) VALUES ($1, $2)`, id, userID); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	jpegImage := func() []byte {
+		t.Helper()
+		if _, _, err := imagestore.PutJPEG(ctx, store, "source.jpg", image.NewRGBA(image.Rect(0, 0, 8, 8)), 90); err != nil {
+			t.Fatal(err)
+		}
+		object, err := store.Get(ctx, "source.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return object.Data
+	}()
+
+	own := upload("user-1", jpegImage)
+	resolved, err := s.Resolve(ctx, "user-1", own)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.ID != own {
+		t.Fatalf("resolved %s", resolved.ID)
+	}
+	if img, err := resolved.Decode(); err != nil || img.Bounds().Dx() != 8 {
+		t.Fatalf("decode: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		userID  string
+		imageID string
+		err     error
+	}{
+		{"another user's image", "user-2", own, ErrImageNotOwned},
+		{"image without user", "user-1", upload("", jpegImage), ErrImageNotOwned},
+		{"unknown id", "user-1", uuid.New().String(), ErrImageNotFound},
+		{"malformed id", "user-1", "../" + own, ErrImageNotFound},
+		{"uppercase id", "user-1", "A" + own[1:], ErrImageNotFound},
+		{"file removed", "user-1", upload("user-1", nil), ErrImageNotFound},
+		{"not an image", "user-1", upload("user-1", []byte("%PDF-1.7 not an image")), ErrUnsupportedImageType},
+	}
+	for _, test := range tests {
+		if _, err := s.Resolve(ctx, test.userID, test.imageID); !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sapps/lib/util"
+	"sapps/pkg/sapps/constant"
+	"sapps/pkg/sapps/imagevariant"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrImageURLExpired   = errors.New("image url expired")
+	ErrImageURLSignature = errors.New("invalid image url signature")
+)
+
+const (
+	imageURLPrefix = "/cdn/img/"
+	// ThumbnailSize is the side of the square thumbnails list screens show
+	ThumbnailSize = 320
+	// Expiries are rounded up to this, so the URL of an image stays the same
+	// for this long and clients can cache it
+	imageURLExpiryWindow = 15 * time.Minute
+)
+
+var thumbnailOptions = imagevariant.Options{Width: ThumbnailSize, Height: ThumbnailSize, Fit: imagevariant.FitCover}
+
+// ImageURL is a URL for the stored image that stops working soon after
+// CDN_URL_TTL. Images are only served through these, so only whoever was
+// handed one by an owner checked route, or OpenAI and kie, can read them.
+func ImageURL(imageID string) string {
+	return SignImageURL(imageID+".jpg", imageURLExpiry(time.Now()), imagevariant.Options{})
+}
+
+// SignImageURL signs the URL of the variant opts of key. The signature covers
+// the options, a URL for a thumbnail cannot be turned into one for another
+// size.
+func SignImageURL(key string, expires time.Time, opts imagevariant.Options) string {
+	query := opts.Values()
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", imageURLSignature(key, expires.Unix(), opts))
+	return fmt.Sprintf("%s%s%s?%s", constant.API_URL, imageURLPrefix, key, query.Encode())
+}
+
+// ThumbnailURL is ImageURL for a square thumbnail of the image.
+func ThumbnailURL(imageID string) string {
+	return SignImageURL(imageID+".jpg", imageURLExpiry(time.Now()), thumbnailOptions)
+}
+
+// SignResultURL signs the unsigned CDN URL generations store as result_url,
+// other URLs are returned as they are.
+func SignResultURL(resultURL *string) *string {
+	key, ok := resultKey(resultURL)
+	if !ok {
+		return resultURL
+	}
+	signed := SignImageURL(key, imageURLExpiry(time.Now()), imagevariant.Options{})
+	return &signed
+}
+
+// ResultThumbnailURL is the thumbnail of a stored result_url, nil when it is
+// not a CDN URL.
+func ResultThumbnailURL(resultURL *string) *string {
+	key, ok := resultKey(resultURL)
+	if !ok {
+		return nil
+	}
+	thumbnail := SignImageURL(key, imageURLExpiry(time.Now()), thumbnailOptions)
+	return &thumbnail
+}
+
+// VerifyImageURL checks the expires and signature query parameters of a
+// request for the variant opts of key.
+func VerifyImageURL(key string, expires string, signature string, opts imagevariant.Options, now time.Time) error {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || signature == "" || !util.VerifyHMAC(constant.CDN_URL_SECRET, imageURLMessage(key, unix, opts), signature) {
+		return ErrImageURLSignature
+	}
+	if now.Unix() > unix {
+		return ErrImageURLExpired
+	}
+	return nil
+}
+
+// ValidImageKey reports whether key names an image the way they are stored,
+// a lowercase UUID with a .jpg extension.
+func ValidImageKey(key string) bool {
+	id, ok := strings.CutSuffix(key, ".jpg")
+	if !ok {
+		return false
+	}
+	parsed, err := uuid.Parse(id)
+	return err == nil && parsed.String() == id
+}
+
+func resultKey(resultURL *string) (string, bool) {
+	if resultURL == nil {
+		return "", false
+	}
+	key, ok := strings.CutPrefix(*resultURL, constant.API_URL+imageURLPrefix)
+	return key, ok && ValidImageKey(key)
+}
+
+// imageURLExpiry is CDN_URL_TTL from now rounded up to the expiry window, so
+// a URL is valid for at least CDN_URL_TTL.
+func imageURLExpiry(now time.Time) time.Time {
+	return now.Add(constant.CDN_URL_TTL).Truncate(imageURLExpiryWindow).Add(imageURLExpiryWindow)
+}
+
+func imageURLSignature(key string, expires int64, opts imagevariant.Options) string {
+	return util.SignHMAC(constant.CDN_URL_SECRET, imageURLMessage(key, expires, opts))
+}
+
+func imageURLMessage(key string, expires int64, opts imagevariant.Options) string {
+	return fmt.Sprintf("cdn-img:%s:%d:%s", key, expires, opts.Values().Encode())
+}
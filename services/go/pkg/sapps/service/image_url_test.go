@@ -0,0 +1,138 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"sapps/pkg/sapps/constant"
+	"sapps/pkg/sapps/imagevariant"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImageURL(t *testing.T) {
+	key := "0b5d6e3c-2f1a-4c7e-9d3b-8a6f5e4d3c2b.jpg"
+	now := time.Unix(1700000000, 0)
+	signed, err := url.Parse(SignImageURL(key, now.Add(time.Minute), imagevariant.Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.Path != "/cdn/img/"+key {
+		t.Fatalf("path = %s", signed.Path)
+	}
+	expires, signature := signed.Query().Get("expires"), signed.Query().Get("signature")
+
+	if err := VerifyImageURL(key, expires, signature, imagevariant.Options{}, now); err != nil {
+		t.Fatalf("valid url: %v", err)
+	}
+	if err := VerifyImageURL(key, expires, signature, imagevariant.Options{}, now.Add(2*time.Minute)); !errors.Is(err, ErrImageURLExpired) {
+		t.Fatalf("expired url: %v", err)
+	}
+	tests := map[string][3]string{
+		"other image":       {"1b5d6e3c-2f1a-4c7e-9d3b-8a6f5e4d3c2b.jpg", expires, signature},
+		"extended expiry":   {key, "1800000000", signature},
+		"missing expiry":    {key, "", signature},
+		"missing signature": {key, expires, ""},
+		"forged signature":  {key, expires, strings.Repeat("0", len(signature))},
+	}
+	for name, test := range tests {
+		if err := VerifyImageURL(test[0], test[1], test[2], imagevariant.Options{}, now); !errors.Is(err, ErrImageURLSignature) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if err := VerifyImageURL(key, expires, signature, imagevariant.Options{Width: 2048}, now); !errors.Is(err, ErrImageURLSignature) {
+		t.Errorf("added options: %v", err)
+	}
+}
+
+func TestImageURLOptions(t *testing.T) {
+	key := "0b5d6e3c-2f1a-4c7e-9d3b-8a6f5e4d3c2b.jpg"
+	now := time.Unix(1700000000, 0)
+	signed, err := url.Parse(SignImageURL(key, now.Add(time.Minute), thumbnailOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := signed.Query()
+	opts, err := imagevariant.ParseOptions(query.Get("width"), query.Get("height"), query.Get("quality"), query.Get("fit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts != thumbnailOptions {
+		t.Fatalf("options = %+v, want %+v", opts, thumbnailOptions)
+	}
+	expires, signature := query.Get("expires"), query.Get("signature")
+	if err := VerifyImageURL(key, expires, signature, opts, now); err != nil {
+		t.Fatalf("valid url: %v", err)
+	}
+	for name, tampered := range map[string]imagevariant.Options{
+		"larger":   {Width: 2048, Height: 2048, Fit: imagevariant.FitCover},
+		"quality":  {Width: ThumbnailSize, Height: ThumbnailSize, Quality: 100, Fit: imagevariant.FitCover},
+		"fit":      {Width: ThumbnailSize, Height: ThumbnailSize, Fit: imagevariant.FitContain},
+		"original": {},
+	} {
+		if err := VerifyImageURL(key, expires, signature, tampered, now); !errors.Is(err, ErrImageURLSignature) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestImageURLExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 3, 0, 0, time.UTC)
+	expires := imageURLExpiry(now)
+	if expires.Before(now.Add(constant.CDN_URL_TTL)) || expires.After(now.Add(constant.CDN_URL_TTL+imageURLExpiryWindow)) {
+		t.Fatalf("expires = %s", expires)
+	}
+	if later := imageURLExpiry(now.Add(10 * time.Minute)); !later.Equal(expires) {
+		t.Fatalf("expiry changed within the window: %s, %s", expires, later)
+	}
+	if next := imageURLExpiry(now.Add(imageURLExpiryWindow)); !next.After(expires) {
+		t.Fatalf("expiry did not move with the window: %s", next)
+	}
+}
+
+func TestValidImageKey(t *testing.T) {
+	valid := []string{"0b5d6e3c-2f1a-4c7e-9d3b-8a6f5e4d3c2b.jpg"}
+	invalid := []string{
+		"0b5d6e3c-2f1a-4c7e-9d3b-8a6f5e4d3c2b",
+		"0B5D6E3C-2F1A-4C7E-9D3B-8A6F5E4D3C2B.jpg",
+		"{0b5d6e3c-2f1a-4c7e-9d3b-8a6f5e4d3c2b}.jpg",
+		"0b5d6e3c2f1a4c7e9d3b8a6f5e4d3c2b.jpg",
+		"../0b5d6e3c-2f1a-4c7e-9d3b-8a6f5e4d3c2b.jpg",
+		"0b5d6e3c-2f1a-4c7e-9d3b-8a6f5e4d3c2b.png",
+		"",
+	}
+	for _, key := range valid {
+		if !ValidImageKey(key) {
+			t.Errorf("%q is invalid", key)
+		}
+	}
+	for _, key := range invalid {
+		if ValidImageKey(key) {
+			t.Errorf("%q is valid", key)
+		}
+	}
+}
+
+func TestSignResultURL(t *testing.T) {
+	local := constant.API_URL + "/cdn/img/0b5d6e3c-2f1a-4c7e-9d3b-8a6f5e4d3c2b.jpg"
+	if signed := SignResultURL(&local); !strings.HasPrefix(*signed, local+"?") {
+		t.Fatalf("local url = %s", *signed)
+	}
+	external := "https://tempfile.aiquickdraw.com/result.jpg"
+	if signed := SignResultURL(&external); *signed != external {
+		t.Fatalf("external url = %s", *signed)
+	}
+	if SignResultURL(nil) != nil {
+		t.Fatal("nil url was signed")
+	}
+	thumbnail, err := url.Parse(*ResultThumbnailURL(&local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query := thumbnail.Query(); query.Get("width") != "320" || query.Get("height") != "320" || query.Get("fit") != "cover" {
+		t.Fatalf("thumbnail url = %s", thumbnail)
+	}
+	if ResultThumbnailURL(&external) != nil {
+		t.Fatal("external url has a thumbnail")
+	}
+}
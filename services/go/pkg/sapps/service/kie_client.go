@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sapps/pkg/sapps/constant"
+	"time"
+)
+
+type KieCreateTaskRequest struct {
+	Model       string             `json:"model"`
+	CallBackURL string             `json:"callBackUrl"`
+	Input       KieCreateTaskInput `json:"input"`
+}
+
+type KieCreateTaskInput struct {
+	ImageUrls    []string `json:"image_urls"`
+	Prompt       string   `json:"prompt"`
+	OutputFormat string   `json:"output_format"`
+	ImageSize    string   `json:"image_size"`
+}
+
+type KieCreateTaskResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		TaskID string `json:"taskId"`
+	} `json:"data"`
+}
+
+type KieTaskData struct {
+	CompleteTime    int64  `json:"completeTime"`
+	ConsumeCredits  int    `json:"consumeCredits"`
+	CostTime        int    `json:"costTime"`
+	CreateTime      int64  `json:"createTime"`
+	FailMsg         string `json:"failMsg"`
+	Model           string `json:"model"`
+	Param           string `json:"param"`
+	RemainedCredits int    `json:"remainedCredits"`
+	ResultJSON      string `json:"resultJson"`
+	State           string `json:"state"`
+	TaskID          string `json:"taskId"`
+	UpdateTime      int64  `json:"updateTime"`
+}
+
+// KieTaskRecord is both the body of the kie callback and the response of the
+// task status endpoint.
+type KieTaskRecord struct {
+	Code int         `json:"code"`
+	Data KieTaskData `json:"data"`
+	Msg  string      `json:"msg"`
+}
+
+// Finished reports whether kie is done with the task, successfully or not.
+func (r *KieTaskRecord) Finished() bool {
+	return r.Data.State == "success" || r.Data.State == "fail"
+}
+
+type KieResultJSON struct {
+	ResultURLs []string `json:"resultUrls"`
+}
+
+// KieAPIError is a non 200 code returned by kie in the response body.
+type KieAPIError struct {
+	Code    int
+	Message string
+}
+
+func (e *KieAPIError) Error() string {
+	return fmt.Sprintf("kie api error: %s %d", e.Message, e.Code)
+}
+
+// Retryable reports whether sending the same request again may succeed.
+// Validation errors will not, while auth and credit errors may succeed with
+// another API key.
+func (e *KieAPIError) Retryable() bool {
+	return e.Code != http.StatusBadRequest && e.Code != http.StatusUnprocessableEntity
+}
+
+type KieClient struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewKieClient() *KieClient {
+	return &KieClient{
+		BaseURL: "https://api.kie.ai",
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (k *KieClient) CreateTask(ctx context.Context, kieReq KieCreateTaskRequest) (string, error) {
+	var kieResp KieCreateTaskResponse
+	if err := k.do(ctx, "POST", "/api/v1/jobs/createTask", kieReq, &kieResp); err != nil {
+		return "", err
+	}
+	if kieResp.Code != 200 {
+		return "", &KieAPIError{Code: kieResp.Code, Message: kieResp.Message}
+	}
+	return kieResp.Data.TaskID, nil
+}
+
+func (k *KieClient) GetTask(ctx context.Context, taskID string) (*KieTaskRecord, error) {
+	var record KieTaskRecord
+	if err := k.do(ctx, "GET", "/api/v1/jobs/recordInfo?taskId="+url.QueryEscape(taskID), nil, &record); err != nil {
+		return nil, err
+	}
+	if record.Code != 200 {
+		return nil, &KieAPIError{Code: record.Code, Message: record.Msg}
+	}
+	return &record, nil
+}
+
+func (k *KieClient) do(ctx context.Context, method string, path string, body any, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		kieReqBody, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(kieReqBody)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, k.BaseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+constant.GetKiaAPIKey())
+
+	resp, err := k.Client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request to kie: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read kie response: %w", err)
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("kie http error: status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse kie response: %w", err)
+	}
+	return nil
+}
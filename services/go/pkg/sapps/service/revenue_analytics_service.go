@@ -0,0 +1,360 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+)
+
+const (
+	RevenueGroupByDay     = "day"
+	RevenueGroupByProduct = "product"
+	RevenueGroupByStore   = "store"
+	RevenueGroupByCountry = "country"
+)
+
+const periodTypeTrial = "TRIAL"
+
+var ErrInvalidGroupBy = errors.New("group_by must be one of day, product, store, country")
+
+// RevenueMetrics are the revenue figures of one group, amounts are in USD as
+// reported by RevenueCat.
+type RevenueMetrics struct {
+	Key                 string  `json:"key"`
+	Revenue             float64 `json:"revenue"`
+	NetRevenue          float64 `json:"net_revenue"`
+	NewRevenue          float64 `json:"new_revenue"`
+	RenewalRevenue      float64 `json:"renewal_revenue"`
+	RefundedRevenue     float64 `json:"refunded_revenue"`
+	MRR                 float64 `json:"mrr"`
+	ActiveSubscriptions int     `json:"active_subscriptions"`
+	Purchases           int     `json:"purchases"`
+	Renewals            int     `json:"renewals"`
+	Trials              int     `json:"trials"`
+	TrialConversions    int     `json:"trial_conversions"`
+	TrialConversionRate float64 `json:"trial_conversion_rate"`
+	Refunds             int     `json:"refunds"`
+	RefundRate          float64 `json:"refund_rate"`
+	Churned             int     `json:"churned"`
+	ChurnRate           float64 `json:"churn_rate"`
+
+	activeAtStart int
+}
+
+// RevenueEvent is the part of a revenuecat_logs row the analytics need.
+type RevenueEvent struct {
+	EventType             string
+	ProductID             string
+	Store                 string
+	Country               string
+	PeriodType            string
+	CancelReason          string
+	IsTrialConversion     bool
+	Price                 float64
+	TakehomePercentage    float64
+	TransactionID         string
+	OriginalTransactionID string
+	EventAt               time.Time
+	PurchasedAt           time.Time
+	ExpiresAt             time.Time
+}
+
+type RevenueAnalyticsService struct {
+	db *maindb.MainDB
+}
+
+func NewRevenueAnalyticsService(db *maindb.MainDB) *RevenueAnalyticsService {
+	return &RevenueAnalyticsService{db: db}
+}
+
+// Report returns the revenue metrics between from and to grouped by groupBy.
+// Sandbox events are never included.
+func (s *RevenueAnalyticsService) Report(ctx context.Context, from time.Time, to time.Time, groupBy string) ([]RevenueMetrics, error) {
+	if !ValidRevenueGroupBy(groupBy) {
+		return nil, ErrInvalidGroupBy
+	}
+	aggregator := newRevenueAggregator(from, to, groupBy)
+	if err := s.eachEvent(ctx, from, to, aggregator.add); err != nil {
+		return nil, err
+	}
+	return aggregator.result(), nil
+}
+
+func ValidRevenueGroupBy(groupBy string) bool {
+	switch groupBy {
+	case RevenueGroupByDay, RevenueGroupByProduct, RevenueGroupByStore, RevenueGroupByCountry:
+		return true
+	}
+	return false
+}
+
+// eachEvent streams the events that happened in the range together with the
+// subscription periods overlapping it, which MRR and churn are computed from,
+// to fn in the order they happened. Rows are read one at a time, a long range
+// is never held in memory as a whole.
+func (s *RevenueAnalyticsService) eachEvent(ctx context.Context, from time.Time, to time.Time, fn func(RevenueEvent)) error {
+	rows, err := s.db.Query(ctx, `
+		WITH logs AS (
+			SELECT l.*,
+				COALESCE(l.event_timestamp_ms, (EXTRACT(EPOCH FROM l.created_at) * 1000)::bigint) AS event_ms,
+				COALESCE(NULLIF(u.country, ''), NULLIF(l.other_data->>'country_code', ''), 'unknown') AS country
+			FROM revenuecat_logs l
+			LEFT JOIN users u ON u.firebase_id = l.app_user_id
+			WHERE COALESCE(l.environment, '') <> $3
+		)
+		SELECT
+			COALESCE(event_type, ''),
+			COALESCE(product_id, ''),
+			COALESCE(store, ''),
+			country,
+			COALESCE(other_data->>'period_type', ''),
+			COALESCE(other_data->>'cancel_reason', ''),
+			COALESCE((other_data->>'is_trial_conversion')::boolean, false),
+			COALESCE(price, 0),
+			COALESCE(takehome_percentage, 1),
+			COALESCE(transaction_id, ''),
+			COALESCE(original_transaction_id, ''),
+			event_ms,
+			COALESCE(purchased_at_ms, 0),
+			COALESCE(expiration_at_ms, 0)
+		FROM logs
+		WHERE (event_ms >= $1 AND event_ms < $2)
+		OR (event_type IN ($4, $5) AND expiration_at_ms > $1 AND purchased_at_ms < $2)
+		ORDER BY event_ms
+	`, from.UnixMilli(), to.UnixMilli(), EnvironmentSandbox, EventInitialPurchase, EventRenewal)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e RevenueEvent
+		var eventMs, purchasedAtMs, expirationAtMs int64
+		err := rows.Scan(&e.EventType, &e.ProductID, &e.Store, &e.Country, &e.PeriodType, &e.CancelReason,
+			&e.IsTrialConversion, &e.Price, &e.TakehomePercentage, &e.TransactionID, &e.OriginalTransactionID,
+			&eventMs, &purchasedAtMs, &expirationAtMs)
+		if err != nil {
+			return err
+		}
+		e.EventAt = time.UnixMilli(eventMs).UTC()
+		if purchasedAtMs > 0 {
+			e.PurchasedAt = time.UnixMilli(purchasedAtMs).UTC()
+		}
+		if expirationAtMs > 0 {
+			e.ExpiresAt = time.UnixMilli(expirationAtMs).UTC()
+		}
+		fn(e)
+	}
+	return rows.Err()
+}
+
+type revenueBucket struct {
+	key   string
+	start time.Time
+	end   time.Time
+}
+
+// revenueAggregator computes the metrics of events added one at a time. Only
+// the paid periods are kept, for MRR and churn once all events are added.
+type revenueAggregator struct {
+	from     time.Time
+	to       time.Time
+	groupBy  string
+	metrics  map[string]*RevenueMetrics
+	buckets  []revenueBucket
+	refunded map[string]bool
+	periods  []RevenueEvent
+}
+
+func newRevenueAggregator(from time.Time, to time.Time, groupBy string) *revenueAggregator {
+	a := &revenueAggregator{
+		from:     from,
+		to:       to,
+		groupBy:  groupBy,
+		metrics:  map[string]*RevenueMetrics{},
+		refunded: map[string]bool{},
+	}
+	if groupBy == RevenueGroupByDay {
+		for day := truncateDay(from); day.Before(to); day = day.AddDate(0, 0, 1) {
+			bucket := revenueBucket{key: day.Format(time.DateOnly), start: day, end: day.AddDate(0, 0, 1)}
+			if bucket.start.Before(from) {
+				bucket.start = from
+			}
+			if bucket.end.After(to) {
+				bucket.end = to
+			}
+			a.buckets = append(a.buckets, bucket)
+			a.get(bucket.key)
+		}
+	}
+	return a
+}
+
+func (a *revenueAggregator) get(key string) *RevenueMetrics {
+	m, ok := a.metrics[key]
+	if !ok {
+		m = &RevenueMetrics{Key: key}
+		a.metrics[key] = m
+	}
+	return m
+}
+
+func (a *revenueAggregator) keyOf(e RevenueEvent) string {
+	switch a.groupBy {
+	case RevenueGroupByDay:
+		return e.EventAt.UTC().Format(time.DateOnly)
+	case RevenueGroupByProduct:
+		return premiumType(e.ProductID)
+	case RevenueGroupByStore:
+		return e.Store
+	default:
+		return e.Country
+	}
+}
+
+func (a *revenueAggregator) add(e RevenueEvent) {
+	if isRefund(e) && e.TransactionID != "" {
+		a.refunded[e.TransactionID] = true
+	}
+	if (e.EventType == EventInitialPurchase || e.EventType == EventRenewal) && e.PeriodType != periodTypeTrial &&
+		!e.PurchasedAt.IsZero() && e.ExpiresAt.After(e.PurchasedAt) {
+		a.periods = append(a.periods, e)
+	}
+
+	if e.EventAt.Before(a.from) || !e.EventAt.Before(a.to) {
+		return
+	}
+	m := a.get(a.keyOf(e))
+	switch {
+	case e.EventType == EventInitialPurchase && e.PeriodType == periodTypeTrial:
+		m.Trials++
+	case e.EventType == EventInitialPurchase || e.EventType == EventNonRenewingPurchase:
+		m.Purchases++
+		m.NewRevenue += e.Price
+		m.NetRevenue += e.Price * e.TakehomePercentage
+	case e.EventType == EventRenewal:
+		m.Renewals++
+		if e.IsTrialConversion {
+			m.TrialConversions++
+		}
+		m.RenewalRevenue += e.Price
+		m.NetRevenue += e.Price * e.TakehomePercentage
+	case isRefund(e):
+		m.Refunds++
+		m.RefundedRevenue += math.Abs(e.Price)
+		m.NetRevenue -= math.Abs(e.Price) * e.TakehomePercentage
+	case e.EventType == EventExpiration:
+		m.Churned++
+	}
+}
+
+func (a *revenueAggregator) result() []RevenueMetrics {
+	var live []RevenueEvent
+	for _, p := range a.periods {
+		if !a.refunded[p.TransactionID] {
+			live = append(live, p)
+		}
+	}
+	if a.groupBy == RevenueGroupByDay {
+		for _, bucket := range a.buckets {
+			m := a.get(bucket.key)
+			m.activeAtStart += len(activeAt(live, bucket.start))
+			for _, p := range activeAt(live, bucket.end.Add(-time.Millisecond)) {
+				m.ActiveSubscriptions++
+				m.MRR += monthlyPrice(p)
+			}
+		}
+	} else {
+		for _, p := range activeAt(live, a.from) {
+			a.get(a.keyOf(p)).activeAtStart++
+		}
+		for _, p := range activeAt(live, a.to.Add(-time.Millisecond)) {
+			m := a.get(a.keyOf(p))
+			m.ActiveSubscriptions++
+			m.MRR += monthlyPrice(p)
+		}
+	}
+
+	result := make([]RevenueMetrics, 0, len(a.metrics))
+	for _, m := range a.metrics {
+		m.Revenue = roundMoney(m.NewRevenue + m.RenewalRevenue - m.RefundedRevenue)
+		m.NetRevenue = roundMoney(m.NetRevenue)
+		m.NewRevenue = roundMoney(m.NewRevenue)
+		m.RenewalRevenue = roundMoney(m.RenewalRevenue)
+		m.RefundedRevenue = roundMoney(m.RefundedRevenue)
+		m.MRR = roundMoney(m.MRR)
+		m.TrialConversionRate = ratio(m.TrialConversions, m.Trials)
+		m.RefundRate = ratio(m.Refunds, m.Purchases+m.Renewals)
+		m.ChurnRate = ratio(m.Churned, m.activeAtStart)
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// AggregateRevenue computes the metrics of the events between from and to.
+// Daily groups include days without events, the other groups only keys that
+// have activity. MRR and active subscriptions are taken at the end of the
+// group's period, churn rate is relative to the subscriptions active at its
+// start.
+func AggregateRevenue(events []RevenueEvent, from time.Time, to time.Time, groupBy string) []RevenueMetrics {
+	aggregator := newRevenueAggregator(from, to, groupBy)
+	for _, e := range events {
+		aggregator.add(e)
+	}
+	return aggregator.result()
+}
+
+// activeAt returns the latest paid period of every subscription covering t.
+func activeAt(periods []RevenueEvent, t time.Time) []RevenueEvent {
+	latest := map[string]RevenueEvent{}
+	for _, p := range periods {
+		if t.Before(p.PurchasedAt) || !t.Before(p.ExpiresAt) {
+			continue
+		}
+		id := p.OriginalTransactionID
+		if id == "" {
+			id = p.TransactionID
+		}
+		if current, ok := latest[id]; !ok || p.PurchasedAt.After(current.PurchasedAt) {
+			latest[id] = p
+		}
+	}
+	active := make([]RevenueEvent, 0, len(latest))
+	for _, p := range latest {
+		active = append(active, p)
+	}
+	return active
+}
+
+// monthlyPrice normalizes the price of a period to 30 days, so weekly and
+// yearly plans add up to a monthly figure.
+func monthlyPrice(p RevenueEvent) float64 {
+	return p.Price * float64(30*24*time.Hour) / float64(p.ExpiresAt.Sub(p.PurchasedAt))
+}
+
+func isRefund(e RevenueEvent) bool {
+	return e.EventType == EventRefund || (e.EventType == EventCancellation && e.CancelReason == cancelReasonCustomerSupport)
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func ratio(n int, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(d)*10000) / 10000
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
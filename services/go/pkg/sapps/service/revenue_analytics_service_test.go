@@ -0,0 +1,62 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregateRevenue(t *testing.T) {
+	day := func(d int, hour int) time.Time {
+		return time.Date(2025, 3, d, hour, 0, 0, 0, time.UTC)
+	}
+	events := []RevenueEvent{
+		// Weekly subscription bought before the range and renewed inside it
+		{EventType: EventInitialPurchase, ProductID: "weekly:w", Store: "APP_STORE", Country: "US", Price: 7, TakehomePercentage: 0.7,
+			TransactionID: "t1", OriginalTransactionID: "o1", EventAt: day(1, 12), PurchasedAt: day(1, 12), ExpiresAt: day(8, 12)},
+		{EventType: EventRenewal, ProductID: "weekly:w", Store: "APP_STORE", Country: "US", Price: 7, TakehomePercentage: 0.7, IsTrialConversion: true,
+			TransactionID: "t2", OriginalTransactionID: "o1", EventAt: day(8, 12), PurchasedAt: day(8, 12), ExpiresAt: day(15, 12)},
+		// Trial that converts above
+		{EventType: EventInitialPurchase, ProductID: "weekly:w", Store: "APP_STORE", Country: "US", PeriodType: periodTypeTrial,
+			TransactionID: "t0", OriginalTransactionID: "o1", EventAt: day(8, 1), PurchasedAt: day(8, 1), ExpiresAt: day(8, 12)},
+		// Purchase refunded on the same day
+		{EventType: EventInitialPurchase, ProductID: "yearly", Store: "PLAY_STORE", Country: "TR", Price: 30, TakehomePercentage: 0.85,
+			TransactionID: "t3", OriginalTransactionID: "o3", EventAt: day(8, 13), PurchasedAt: day(8, 13), ExpiresAt: day(8, 13).AddDate(1, 0, 0)},
+		{EventType: EventCancellation, CancelReason: cancelReasonCustomerSupport, ProductID: "yearly", Store: "PLAY_STORE", Country: "TR", Price: -30, TakehomePercentage: 0.85,
+			TransactionID: "t3", OriginalTransactionID: "o3", EventAt: day(8, 18)},
+		{EventType: EventExpiration, ProductID: "weekly:w", Store: "APP_STORE", Country: "US", TransactionID: "t2", OriginalTransactionID: "o1", EventAt: day(9, 6)},
+	}
+
+	byDay := AggregateRevenue(events, day(8, 0), day(10, 0), RevenueGroupByDay)
+	if len(byDay) != 2 || byDay[0].Key != "2025-03-08" || byDay[1].Key != "2025-03-09" {
+		t.Fatalf("unexpected days %+v", byDay)
+	}
+	first := byDay[0]
+	if first.NewRevenue != 30 || first.RenewalRevenue != 7 || first.RefundedRevenue != 30 || first.Revenue != 7 {
+		t.Errorf("unexpected revenue %+v", first)
+	}
+	if first.NetRevenue != 4.9 {
+		t.Errorf("net revenue = %v, want 4.9", first.NetRevenue)
+	}
+	if first.Trials != 1 || first.TrialConversions != 1 || first.TrialConversionRate != 1 {
+		t.Errorf("unexpected trials %+v", first)
+	}
+	if first.Refunds != 1 || first.RefundRate != 0.5 {
+		t.Errorf("unexpected refunds %+v", first)
+	}
+	// Only the renewed weekly plan is active, the refunded yearly one is not
+	if first.ActiveSubscriptions != 1 || first.MRR != 30 {
+		t.Errorf("unexpected mrr %+v", first)
+	}
+	second := byDay[1]
+	if second.Churned != 1 || second.ChurnRate != 1 || second.Revenue != 0 {
+		t.Errorf("unexpected second day %+v", second)
+	}
+
+	byStore := AggregateRevenue(events, day(8, 0), day(10, 0), RevenueGroupByStore)
+	if len(byStore) != 2 || byStore[0].Key != "APP_STORE" || byStore[1].Key != "PLAY_STORE" {
+		t.Fatalf("unexpected stores %+v", byStore)
+	}
+	if byStore[0].Renewals != 1 || byStore[0].Churned != 1 || byStore[1].Refunds != 1 || byStore[1].Purchases != 1 {
+		t.Errorf("unexpected store metrics %+v", byStore)
+	}
+}
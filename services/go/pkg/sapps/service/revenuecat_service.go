@@ -4,15 +4,52 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"log"
 	"strings"
 	"time"
 
 	"sapps/lib/util"
+	"sapps/pkg/sapps/constant"
 	maindb "sapps/pkg/sapps/lib/db/main"
 
 	"github.com/jackc/pgx/v5"
 )
 
+// RevenueCat event types, see https://www.revenuecat.com/docs/integrations/webhooks/event-types-and-fields
+const (
+	EventInitialPurchase      = "INITIAL_PURCHASE"
+	EventRenewal              = "RENEWAL"
+	EventNonRenewingPurchase  = "NON_RENEWING_PURCHASE"
+	EventUncancellation       = "UNCANCELLATION"
+	EventSubscriptionExtended = "SUBSCRIPTION_EXTENDED"
+	EventCancellation         = "CANCELLATION"
+	EventSubscriptionPaused   = "SUBSCRIPTION_PAUSED"
+	EventBillingIssue         = "BILLING_ISSUE"
+	EventExpiration           = "EXPIRATION"
+	EventRefund               = "REFUND"
+	EventProductChange        = "PRODUCT_CHANGE"
+	EventTransfer             = "TRANSFER"
+)
+
+const (
+	EnvironmentProduction = "PRODUCTION"
+	EnvironmentSandbox    = "SANDBOX"
+)
+
+// Reasons an event is logged without changing entitlements
+const (
+	skipReasonSandboxIgnored      = "sandbox_ignored"
+	skipReasonSandboxNonDebugUser = "sandbox_non_debug_user"
+)
+
+// Cancellations with this reason are refunds issued through the store
+const cancelReasonCustomerSupport = "CUSTOMER_SUPPORT"
+
+var (
+	ErrMissingEventID = errors.New("revenuecat event id is missing")
+	ErrStaleEvent     = errors.New("revenuecat event is too old")
+)
+
 type RevenueCatService struct {
 	db *maindb.MainDB
 }
@@ -26,24 +63,30 @@ func NewRevenueCatService(db *maindb.MainDB) *RevenueCatService {
 type RevenueCatEvent struct {
 	ID    *string `json:"id"`
 	Event struct {
-		ID                       *string  `json:"id"`
-		AppUserID                string   `json:"app_user_id"`
-		OriginalAppUserID        string   `json:"original_app_user_id"`
-		ProductID                string   `json:"product_id"`
-		NewProductID             string   `json:"new_product_id"`
-		Price                    float64  `json:"price"`
-		Currency                 string   `json:"currency"`
-		PriceInPurchasedCurrency float64  `json:"price_in_purchased_currency"`
-		TakehomePercentage       float64  `json:"takehome_percentage"`
-		PurchasedAtMs            int64    `json:"purchased_at_ms"`
-		ExpirationAtMs           int64    `json:"expiration_at_ms"`
-		Store                    string   `json:"store"`
-		Environment              string   `json:"environment"`
-		TransactionID            string   `json:"transaction_id"`
-		OriginalTransactionID    string   `json:"original_transaction_id"`
-		Type                     string   `json:"type"`
-		TransferredTo            []string `json:"transferred_to"`
-		TransferredFrom          []string `json:"transferred_from"`
+		ID                        *string  `json:"id"`
+		AppUserID                 string   `json:"app_user_id"`
+		OriginalAppUserID         string   `json:"original_app_user_id"`
+		ProductID                 string   `json:"product_id"`
+		NewProductID              string   `json:"new_product_id"`
+		Price                     float64  `json:"price"`
+		Currency                  string   `json:"currency"`
+		PriceInPurchasedCurrency  float64  `json:"price_in_purchased_currency"`
+		TakehomePercentage        float64  `json:"takehome_percentage"`
+		EventTimestampMs          int64    `json:"event_timestamp_ms"`
+		PurchasedAtMs             int64    `json:"purchased_at_ms"`
+		ExpirationAtMs            int64    `json:"expiration_at_ms"`
+		GracePeriodExpirationAtMs int64    `json:"grace_period_expiration_at_ms"`
+		CancelReason              string   `json:"cancel_reason"`
+		PeriodType                string   `json:"period_type"`
+		IsTrialConversion         bool     `json:"is_trial_conversion"`
+		CountryCode               string   `json:"country_code"`
+		Store                     string   `json:"store"`
+		Environment               string   `json:"environment"`
+		TransactionID             string   `json:"transaction_id"`
+		OriginalTransactionID     string   `json:"original_transaction_id"`
+		Type                      string   `json:"type"`
+		TransferredTo             []string `json:"transferred_to"`
+		TransferredFrom           []string `json:"transferred_from"`
 	} `json:"event"`
 }
 
@@ -53,14 +96,17 @@ func (s *RevenueCatService) HandleWebhook(ctx context.Context, eventData *Revenu
 	if eventData.ID == nil {
 		eventID = eventData.Event.ID
 	}
-	if eventID == nil {
-		tt := "tt"
-		eventID = &tt
+	if eventID == nil || *eventID == "" {
+		return ErrMissingEventID
+	}
+	eventTime := time.UnixMilli(eventData.Event.EventTimestampMs)
+	if eventData.Event.EventTimestampMs <= 0 || time.Since(eventTime) > constant.REVENUECAT_MAX_EVENT_AGE {
+		return ErrStaleEvent
 	}
 
 	// Get product ID based on event type
 	productID := eventData.Event.ProductID
-	if eventData.Event.Type == "PRODUCT_CHANGE" {
+	if eventData.Event.Type == EventProductChange {
 		productID = eventData.Event.NewProductID
 	}
 
@@ -71,7 +117,22 @@ func (s *RevenueCatService) HandleWebhook(ctx context.Context, eventData *Revenu
 		return err
 	}
 
-	_, err = s.db.Exec(ctx, `
+	// The log row and the premium changes are committed together, so a
+	// failed event is not recorded and its redelivery is processed again
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		util.LogErr(err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	skipReason, err := s.sandboxSkipReason(ctx, tx, eventData)
+	if err != nil {
+		util.LogErr(err)
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, `
 		INSERT INTO revenuecat_logs (
 			revenuecat_event_id,
 			app_user_id,
@@ -89,8 +150,10 @@ func (s *RevenueCatService) HandleWebhook(ctx context.Context, eventData *Revenu
 			original_transaction_id,
 			other_data,
 			event_type,
-			user_id
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+			user_id,
+			sandbox,
+			skip_reason
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
 		ON CONFLICT (revenuecat_event_id) DO NOTHING
 	`,
 		eventID,
@@ -110,32 +173,104 @@ func (s *RevenueCatService) HandleWebhook(ctx context.Context, eventData *Revenu
 		otherData,
 		eventData.Event.Type,
 		eventData.Event.AppUserID,
+		eventData.Event.Environment == EnvironmentSandbox,
+		nullIfEmpty(skipReason),
 	)
 	if err != nil {
 		util.LogErr(err)
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		// Already processed, RevenueCat redelivers events it did not see acknowledged
+		log.Printf("Skipping duplicate RevenueCat event %s", *eventID)
+		return nil
+	}
+
+	if skipReason != "" {
+		log.Printf("Not applying RevenueCat event %s: %s", *eventID, skipReason)
+	} else if err := s.applyEvent(ctx, tx, eventData, productID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		util.LogErr(err)
+		return err
+	}
+	return nil
+}
 
-	// Process based on event type
-	switch eventData.Event.Type {
-	case "INITIAL_PURCHASE", "RENEWAL", "CANCELLATION", "EXPIRATION", "NON_RENEWING_PURCHASE":
-		return s.updatePremiumStatus(ctx, eventData.Event.AppUserID, eventData.Event.TransactionID, productID, eventData.Event.PurchasedAtMs, eventData.Event.ExpirationAtMs)
-	case "PRODUCT_CHANGE":
-		return s.handleProductChange(ctx, eventData, productID)
-	case "TRANSFER":
-		return s.handleTransfer(ctx, eventData)
+// sandboxSkipReason decides whether a SANDBOX event may change entitlements.
+// Production events always may.
+func (s *RevenueCatService) sandboxSkipReason(ctx context.Context, tx pgx.Tx, eventData *RevenueCatEvent) (string, error) {
+	if eventData.Event.Environment != EnvironmentSandbox {
+		return "", nil
+	}
+	switch constant.REVENUECAT_SANDBOX_MODE {
+	case constant.SandboxModeDatabase:
+		// The webhook route already sent the event to the sandbox database
+		return "", nil
+	case constant.SandboxModeIgnore:
+		return skipReasonSandboxIgnored, nil
+	}
+
+	firebaseIDs := []string{eventData.Event.AppUserID}
+	if eventData.Event.Type == EventTransfer {
+		firebaseIDs = append(append([]string{}, eventData.Event.TransferredFrom...), eventData.Event.TransferredTo...)
+	}
+	var allDebug bool
+	err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) > 0 AND bool_and(COALESCE(debug, false)) FROM users WHERE firebase_id = ANY($1)
+	`, firebaseIDs).Scan(&allDebug)
+	if err != nil {
+		return "", err
+	}
+	if !allDebug {
+		return skipReasonSandboxNonDebugUser, nil
+	}
+	return "", nil
+}
+
+func (s *RevenueCatService) applyEvent(ctx context.Context, tx pgx.Tx, eventData *RevenueCatEvent, productID string) error {
+	event := &eventData.Event
+	switch event.Type {
+	case EventInitialPurchase, EventRenewal, EventNonRenewingPurchase, EventUncancellation, EventSubscriptionExtended:
+		// Access until the new expiration date
+		return s.grantPremium(ctx, tx, event.AppUserID, event.TransactionID, productID, event.PurchasedAtMs, event.ExpirationAtMs)
+	case EventCancellation:
+		if event.CancelReason == cancelReasonCustomerSupport {
+			return s.revokePremium(ctx, tx, event.TransactionID)
+		}
+		// Auto renew is off, access is kept until the paid period ends
+		return s.setExpireDate(ctx, tx, event.TransactionID, msToTime(event.ExpirationAtMs))
+	case EventSubscriptionPaused:
+		// The pause starts at the end of the period, EXPIRATION follows then
+		return s.setExpireDate(ctx, tx, event.TransactionID, msToTime(event.ExpirationAtMs))
+	case EventBillingIssue:
+		// Access continues only through a grace period the store reports
+		gracePeriodEnd := msToTime(event.GracePeriodExpirationAtMs)
+		if gracePeriodEnd == nil {
+			return s.setExpireDate(ctx, tx, event.TransactionID, endedAt(event.ExpirationAtMs))
+		}
+		return s.extendForGracePeriod(ctx, tx, event.AppUserID, event.TransactionID, productID, event.PurchasedAtMs, *gracePeriodEnd)
+	case EventExpiration:
+		return s.setExpireDate(ctx, tx, event.TransactionID, endedAt(event.ExpirationAtMs))
+	case EventRefund:
+		return s.revokePremium(ctx, tx, event.TransactionID)
+	case EventProductChange:
+		return s.handleProductChange(ctx, tx, eventData, productID)
+	case EventTransfer:
+		return s.handleTransfer(ctx, tx, eventData)
 	default:
 		return nil
 	}
 }
 
-func (s *RevenueCatService) handleTransfer(ctx context.Context, event *RevenueCatEvent) error {
+func (s *RevenueCatService) handleTransfer(ctx context.Context, tx pgx.Tx, event *RevenueCatEvent) error {
 	// End premium membership for old user
 	transactionID := ""
 	if len(event.Event.TransferredFrom) > 0 {
 		for _, userID := range event.Event.TransferredFrom {
 			var err error
-			if transactionID, err = s.finishPremium(ctx, userID); err != nil {
+			if transactionID, err = s.finishPremium(ctx, tx, userID); err != nil {
 				return err
 			}
 		}
@@ -148,7 +283,7 @@ func (s *RevenueCatService) handleTransfer(ctx context.Context, event *RevenueCa
 		for _, userID := range event.Event.TransferredTo {
 			// Check if user exists
 			var exists bool
-			err := s.db.QueryRow(ctx, `
+			err := tx.QueryRow(ctx, `
 				SELECT EXISTS(SELECT 1 FROM users WHERE firebase_id = $1)
 			`, userID).Scan(&exists)
 			if err != nil {
@@ -159,7 +294,7 @@ func (s *RevenueCatService) handleTransfer(ctx context.Context, event *RevenueCa
 			if !exists {
 				// User doesn't exist, update the old user's firebase_id to the new one
 				if len(event.Event.TransferredFrom) > 0 {
-					_, err := s.db.Exec(ctx, `
+					_, err := tx.Exec(ctx, `
 						UPDATE users 
 						SET firebase_id = $1
 						WHERE firebase_id = $2
@@ -171,8 +306,8 @@ func (s *RevenueCatService) handleTransfer(ctx context.Context, event *RevenueCa
 				}
 			}
 
-			// Update premium status for the user (either existing or newly updated)
-			if err := s.updatePremiumStatus(ctx, userID, transactionID, "", event.Event.PurchasedAtMs, event.Event.ExpirationAtMs); err != nil {
+			// Move the existing entitlement to the user (either existing or newly updated)
+			if err := s.linkPremium(ctx, tx, userID, transactionID); err != nil {
 				return err
 			}
 		}
@@ -181,7 +316,7 @@ func (s *RevenueCatService) handleTransfer(ctx context.Context, event *RevenueCa
 	return nil
 }
 
-func (s *RevenueCatService) handleProductChange(ctx context.Context, event *RevenueCatEvent, productID string) error {
+func (s *RevenueCatService) handleProductChange(ctx context.Context, tx pgx.Tx, event *RevenueCatEvent, productID string) error {
 	var endDate *time.Time
 	if event.Event.ExpirationAtMs > 0 {
 		endDateMs := time.UnixMilli(event.Event.ExpirationAtMs)
@@ -189,7 +324,7 @@ func (s *RevenueCatService) handleProductChange(ctx context.Context, event *Reve
 	}
 	if endDate != nil {
 		if time.Now().After(*endDate) {
-			err := s.db.QueryRow(ctx, `
+			err := tx.QueryRow(ctx, `
 		SELECT pd.expire_date FROM users u
 		LEFT JOIN premium_data pd ON pd.id = u.premium_id AND pd.expire_date > NOW()
 		WHERE u.firebase_id = $1
@@ -204,29 +339,49 @@ func (s *RevenueCatService) handleProductChange(ctx context.Context, event *Reve
 			}
 		}
 	}
-	premiumType := productID
-	if strings.Contains(premiumType, ":") {
-		premiumType = strings.Split(premiumType, ":")[0]
-	}
-	_, err := s.db.Exec(ctx, `
+	_, err := tx.Exec(ctx, `
 		UPDATE premium_data SET expire_date = greatest(expire_date, $1), premium_type = $2 WHERE id = $3
-	`, endDate, premiumType, event.Event.TransactionID)
+	`, endDate, premiumType(productID), event.Event.TransactionID)
 	if err != nil {
 		util.LogErr(err)
 		return err
 	}
-	_, err = s.db.Exec(ctx, `
-		UPDATE users 
-		SET premium_id = NULL
-		WHERE premium_id = $1
-	`, event.Event.TransactionID)
+	return s.linkPremium(ctx, tx, event.Event.AppUserID, event.Event.TransactionID)
+}
+
+// grantPremium creates or extends the entitlement of the transaction and
+// makes it the user's current one.
+func (s *RevenueCatService) grantPremium(ctx context.Context, tx pgx.Tx, userID, transactionID, productID string, startDateMs, endDateMs int64) error {
+	startDate := time.UnixMilli(startDateMs)
+	if startDateMs <= 0 {
+		startDate = time.Now()
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO premium_data (id, premium_type, created_date, expire_date)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE
+		SET premium_type = COALESCE(NULLIF(excluded.premium_type, ''), premium_data.premium_type),
+		    expire_date = excluded.expire_date
+	`, transactionID, premiumType(productID), startDate, msToTime(endDateMs))
 	if err != nil {
 		util.LogErr(err)
 		return err
 	}
-	_, err = s.db.Exec(ctx, `
-		UPDATE users SET premium_id = $1 WHERE firebase_id = $2
-	`, event.Event.TransactionID, event.Event.AppUserID)
+	return s.linkPremium(ctx, tx, userID, transactionID)
+}
+
+// setExpireDate moves the end of the transaction's entitlement without
+// changing which user holds it. Unknown transactions are left alone, only a
+// purchase event grants premium. Without an expiration the date is kept, a
+// NULL expire_date is premium that never ends.
+func (s *RevenueCatService) setExpireDate(ctx context.Context, tx pgx.Tx, transactionID string, expireDate *time.Time) error {
+	if expireDate == nil {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+		UPDATE premium_data SET expire_date = $1 WHERE id = $2
+	`, expireDate, transactionID)
 	if err != nil {
 		util.LogErr(err)
 		return err
@@ -234,50 +389,83 @@ func (s *RevenueCatService) handleProductChange(ctx context.Context, event *Reve
 	return nil
 }
 
-func (s *RevenueCatService) updatePremiumStatus(ctx context.Context, userID, transactionID, productID string, startDateMs, endDateMs int64) error {
-	startDate := time.UnixMilli(startDateMs)
-	var endDate *time.Time
-	if endDateMs > 0 {
-		endDateMs := time.UnixMilli(endDateMs)
-		endDate = &endDateMs
+// extendForGracePeriod keeps the entitlement until the end of the grace
+// period the store reported. An unknown transaction is granted, so a missed
+// purchase event does not lock a user in grace period out.
+func (s *RevenueCatService) extendForGracePeriod(ctx context.Context, tx pgx.Tx, userID, transactionID, productID string, startDateMs int64, gracePeriodEnd time.Time) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE premium_data SET expire_date = $1 WHERE id = $2
+	`, gracePeriodEnd, transactionID)
+	if err != nil {
+		util.LogErr(err)
+		return err
 	}
+	if tag.RowsAffected() > 0 || !gracePeriodEnd.After(time.Now()) {
+		return nil
+	}
+	return s.grantPremium(ctx, tx, userID, transactionID, productID, startDateMs, gracePeriodEnd.UnixMilli())
+}
 
-	premiumType := productID
-	if strings.Contains(premiumType, ":") {
-		premiumType = strings.Split(premiumType, ":")[0]
+// endedAt is the expiration of a period that is over, now when the event has
+// none or one in the future.
+func endedAt(expirationAtMs int64) *time.Time {
+	expireDate := msToTime(expirationAtMs)
+	if expireDate == nil || expireDate.After(time.Now()) {
+		now := time.Now()
+		expireDate = &now
 	}
+	return expireDate
+}
 
-	// First, create or update premium_data
-	_, err := s.db.Exec(ctx, `
-		INSERT INTO premium_data (id, premium_type, created_date, expire_date)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (id) DO NOTHING
-	`, transactionID, premiumType, startDate, endDate)
+// revokePremium ends the entitlement immediately and detaches it from its user.
+func (s *RevenueCatService) revokePremium(ctx context.Context, tx pgx.Tx, transactionID string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE premium_data SET expire_date = NOW() WHERE id = $1
+	`, transactionID)
+	if err != nil {
+		util.LogErr(err)
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE users 
+		SET premium_id = NULL
+		WHERE premium_id = $1
+	`, transactionID)
 	if err != nil {
 		util.LogErr(err)
 		return err
 	}
+	return nil
+}
 
-	_, err = s.db.Exec(ctx, `
+// linkPremium makes the transaction the user's entitlement, taking it away
+// from any other user holding it.
+func (s *RevenueCatService) linkPremium(ctx context.Context, tx pgx.Tx, userID, transactionID string) error {
+	_, err := tx.Exec(ctx, `
 		UPDATE users 
 		SET premium_id = NULL
 		WHERE premium_id = $1
 	`, transactionID)
-	util.LogErr(err)
+	if err != nil {
+		util.LogErr(err)
+		return err
+	}
 
-	// Then update user's premium_id
-	_, err = s.db.Exec(ctx, `
+	_, err = tx.Exec(ctx, `
 		UPDATE users 
 		SET premium_id = $1
 		WHERE firebase_id = $2
 	`, transactionID, userID)
-	util.LogErr(err)
-	return err
+	if err != nil {
+		util.LogErr(err)
+		return err
+	}
+	return nil
 }
 
-func (s *RevenueCatService) finishPremium(ctx context.Context, userID string) (string, error) {
+func (s *RevenueCatService) finishPremium(ctx context.Context, tx pgx.Tx, userID string) (string, error) {
 	var transactionID string
-	err := s.db.QueryRow(ctx, `
+	err := tx.QueryRow(ctx, `
 		SELECT COALESCE(premium_id, '') FROM users 
 		WHERE firebase_id = $1
 	`, userID).Scan(&transactionID)
@@ -286,7 +474,7 @@ func (s *RevenueCatService) finishPremium(ctx context.Context, userID string) (s
 		return "", err
 	}
 	// Clear premium_id from user
-	_, err = s.db.Exec(ctx, `
+	_, err = tx.Exec(ctx, `
 		UPDATE users 
 		SET premium_id = NULL
 		WHERE firebase_id = $1
@@ -297,3 +485,25 @@ func (s *RevenueCatService) finishPremium(ctx context.Context, userID string) (s
 	}
 	return transactionID, nil
 }
+
+func premiumType(productID string) string {
+	if strings.Contains(productID, ":") {
+		return strings.Split(productID, ":")[0]
+	}
+	return productID
+}
+
+func nullIfEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func msToTime(ms int64) *time.Time {
+	if ms <= 0 {
+		return nil
+	}
+	t := time.UnixMilli(ms)
+	return &t
+}
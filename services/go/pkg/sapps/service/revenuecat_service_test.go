@@ -0,0 +1,419 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/lib/db/main/maindbtest"
+
+	"github.com/google/uuid"
+)
+
+type premiumState struct {
+	premiumID   *string
+	premiumType *string
+	expireDate  *time.Time
+}
+
+func loadPremiumState(t *testing.T, db *maindb.MainDB, firebaseID string) premiumState {
+	t.Helper()
+	var state premiumState
+	err := db.QueryRow(context.Background(), `
+		SELECT u.premium_id, pd.premium_type, pd.expire_date
+		FROM users u
+		LEFT JOIN premium_data pd ON pd.id = u.premium_id
+		WHERE u.firebase_id = $1
+	`, firebaseID).Scan(&state.premiumID, &state.premiumType, &state.expireDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func loadExpireDate(t *testing.T, db *maindb.MainDB, transactionID string) *time.Time {
+	t.Helper()
+	var expireDate *time.Time
+	err := db.QueryRow(context.Background(), `
+		SELECT expire_date FROM premium_data WHERE id = $1
+	`, transactionID).Scan(&expireDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expireDate
+}
+
+func newEvent(eventType string, appUserID string, transactionID string, productID string, expiration time.Time) RevenueCatEvent {
+	var event RevenueCatEvent
+	id := uuid.New().String()
+	event.Event.ID = &id
+	event.Event.Type = eventType
+	event.Event.AppUserID = appUserID
+	event.Event.TransactionID = transactionID
+	event.Event.ProductID = productID
+	event.Event.EventTimestampMs = time.Now().UnixMilli()
+	event.Event.PurchasedAtMs = time.Now().Add(-time.Hour).UnixMilli()
+	if !expiration.IsZero() {
+		event.Event.ExpirationAtMs = expiration.UnixMilli()
+	}
+	return event
+}
+
+func withinMinute(got *time.Time, want time.Time) bool {
+	return got != nil && got.Sub(want).Abs() < time.Minute
+}
+
+func TestRevenueCatHandleWebhook(t *testing.T) {
+	db := maindbtest.New(t)
+	ctx := context.Background()
+	s := NewRevenueCatService(db)
+
+	now := time.Now()
+	nextWeek := now.Add(7 * 24 * time.Hour)
+	yesterday := now.Add(-24 * time.Hour)
+	purchase := func() RevenueCatEvent {
+		return newEvent(EventInitialPurchase, "firebase-1", "tx-1", "sappsr_pro_a_1w:weekly", nextWeek)
+	}
+
+	tests := []struct {
+		name    string
+		setup   []RevenueCatEvent
+		event   func() RevenueCatEvent
+		wantErr error
+		check   func(t *testing.T)
+	}{
+		{
+			name:  "initial purchase grants premium",
+			event: purchase,
+			check: func(t *testing.T) {
+				state := loadPremiumState(t, db, "firebase-1")
+				if state.premiumID == nil || *state.premiumID != "tx-1" {
+					t.Fatalf("premium_id = %v, want tx-1", state.premiumID)
+				}
+				if state.premiumType == nil || *state.premiumType != "sappsr_pro_a_1w" {
+					t.Errorf("premium_type = %v, want sappsr_pro_a_1w", state.premiumType)
+				}
+				if !withinMinute(state.expireDate, nextWeek) {
+					t.Errorf("expire_date = %v, want %v", state.expireDate, nextWeek)
+				}
+			},
+		},
+		{
+			name:  "renewal moves the user to the new transaction",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				return newEvent(EventRenewal, "firebase-1", "tx-2", "sappsr_pro_a_1w", nextWeek.Add(7*24*time.Hour))
+			},
+			check: func(t *testing.T) {
+				state := loadPremiumState(t, db, "firebase-1")
+				if state.premiumID == nil || *state.premiumID != "tx-2" {
+					t.Fatalf("premium_id = %v, want tx-2", state.premiumID)
+				}
+				if !withinMinute(state.expireDate, nextWeek.Add(7*24*time.Hour)) {
+					t.Errorf("expire_date = %v", state.expireDate)
+				}
+			},
+		},
+		{
+			name:  "cancellation keeps access until expiration",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				return newEvent(EventCancellation, "firebase-1", "tx-1", "sappsr_pro_a_1w", now.Add(48*time.Hour))
+			},
+			check: func(t *testing.T) {
+				state := loadPremiumState(t, db, "firebase-1")
+				if state.premiumID == nil || *state.premiumID != "tx-1" {
+					t.Fatalf("premium_id = %v, want tx-1", state.premiumID)
+				}
+				if !withinMinute(state.expireDate, now.Add(48*time.Hour)) {
+					t.Errorf("expire_date = %v", state.expireDate)
+				}
+			},
+		},
+		{
+			name:  "cancellation without expiration keeps the expire date",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				return newEvent(EventCancellation, "firebase-1", "tx-1", "sappsr_pro_a_1w", time.Time{})
+			},
+			check: func(t *testing.T) {
+				if expireDate := loadExpireDate(t, db, "tx-1"); !withinMinute(expireDate, nextWeek) {
+					t.Fatalf("expire_date = %v, want %v", expireDate, nextWeek)
+				}
+			},
+		},
+		{
+			name:  "pause without expiration keeps the expire date",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				return newEvent(EventSubscriptionPaused, "firebase-1", "tx-1", "sappsr_pro_a_1w", time.Time{})
+			},
+			check: func(t *testing.T) {
+				if expireDate := loadExpireDate(t, db, "tx-1"); !withinMinute(expireDate, nextWeek) {
+					t.Fatalf("expire_date = %v, want %v", expireDate, nextWeek)
+				}
+			},
+		},
+		{
+			name:  "customer support cancellation revokes access",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				event := newEvent(EventCancellation, "firebase-1", "tx-1", "sappsr_pro_a_1w", nextWeek)
+				event.Event.CancelReason = cancelReasonCustomerSupport
+				return event
+			},
+			check: func(t *testing.T) {
+				state := loadPremiumState(t, db, "firebase-1")
+				if state.premiumID != nil {
+					t.Fatalf("premium_id = %v, want nil", *state.premiumID)
+				}
+				if expireDate := loadExpireDate(t, db, "tx-1"); expireDate == nil || expireDate.After(time.Now()) {
+					t.Errorf("expire_date = %v, want past", expireDate)
+				}
+			},
+		},
+		{
+			name:  "refund revokes access",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				return newEvent(EventRefund, "firebase-1", "tx-1", "sappsr_pro_a_1w", time.Time{})
+			},
+			check: func(t *testing.T) {
+				state := loadPremiumState(t, db, "firebase-1")
+				if state.premiumID != nil {
+					t.Fatalf("premium_id = %v, want nil", *state.premiumID)
+				}
+			},
+		},
+		{
+			name: "uncancellation restores the expiration",
+			setup: []RevenueCatEvent{
+				purchase(),
+				newEvent(EventCancellation, "firebase-1", "tx-1", "sappsr_pro_a_1w", now.Add(time.Hour)),
+			},
+			event: func() RevenueCatEvent {
+				return newEvent(EventUncancellation, "firebase-1", "tx-1", "sappsr_pro_a_1w", nextWeek)
+			},
+			check: func(t *testing.T) {
+				state := loadPremiumState(t, db, "firebase-1")
+				if state.premiumID == nil || !withinMinute(state.expireDate, nextWeek) {
+					t.Fatalf("state = %+v, want tx-1 until next week", state)
+				}
+			},
+		},
+		{
+			name:  "billing issue keeps access for the grace period",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				event := newEvent(EventBillingIssue, "firebase-1", "tx-1", "sappsr_pro_a_1w", yesterday)
+				event.Event.GracePeriodExpirationAtMs = now.Add(16 * 24 * time.Hour).UnixMilli()
+				return event
+			},
+			check: func(t *testing.T) {
+				state := loadPremiumState(t, db, "firebase-1")
+				if state.premiumID == nil || !withinMinute(state.expireDate, now.Add(16*24*time.Hour)) {
+					t.Fatalf("state = %+v, want grace period end", state)
+				}
+			},
+		},
+		{
+			name:  "billing issue without grace period ends access",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				return newEvent(EventBillingIssue, "firebase-1", "tx-1", "sappsr_pro_a_1w", yesterday)
+			},
+			check: func(t *testing.T) {
+				if expireDate := loadExpireDate(t, db, "tx-1"); !withinMinute(expireDate, yesterday) {
+					t.Fatalf("expire_date = %v, want %v", expireDate, yesterday)
+				}
+			},
+		},
+		{
+			name: "billing issue grace period grants an unknown transaction",
+			event: func() RevenueCatEvent {
+				event := newEvent(EventBillingIssue, "firebase-1", "tx-1", "sappsr_pro_a_1w", yesterday)
+				event.Event.GracePeriodExpirationAtMs = now.Add(16 * 24 * time.Hour).UnixMilli()
+				return event
+			},
+			check: func(t *testing.T) {
+				state := loadPremiumState(t, db, "firebase-1")
+				if state.premiumID == nil || !withinMinute(state.expireDate, now.Add(16*24*time.Hour)) {
+					t.Fatalf("state = %+v, want grace period end", state)
+				}
+			},
+		},
+		{
+			name: "cancellation of an unknown transaction grants nothing",
+			event: func() RevenueCatEvent {
+				return newEvent(EventCancellation, "firebase-1", "tx-1", "sappsr_pro_a_1w", nextWeek)
+			},
+			check: func(t *testing.T) {
+				if state := loadPremiumState(t, db, "firebase-1"); state.premiumID != nil {
+					t.Fatalf("premium_id = %v, want nil", *state.premiumID)
+				}
+			},
+		},
+		{
+			name:  "paused subscription keeps access until expiration",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				return newEvent(EventSubscriptionPaused, "firebase-1", "tx-1", "sappsr_pro_a_1w", now.Add(72*time.Hour))
+			},
+			check: func(t *testing.T) {
+				state := loadPremiumState(t, db, "firebase-1")
+				if state.premiumID == nil || !withinMinute(state.expireDate, now.Add(72*time.Hour)) {
+					t.Fatalf("state = %+v", state)
+				}
+			},
+		},
+		{
+			name:  "expiration sets expire_date",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				return newEvent(EventExpiration, "firebase-1", "tx-1", "sappsr_pro_a_1w", yesterday)
+			},
+			check: func(t *testing.T) {
+				if expireDate := loadExpireDate(t, db, "tx-1"); !withinMinute(expireDate, yesterday) {
+					t.Fatalf("expire_date = %v, want %v", expireDate, yesterday)
+				}
+			},
+		},
+		{
+			name:  "product change updates the premium type",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				event := newEvent(EventProductChange, "firebase-1", "tx-1", "sappsr_pro_a_1w", nextWeek)
+				event.Event.NewProductID = "sappsr_pro_a_1m:monthly"
+				return event
+			},
+			check: func(t *testing.T) {
+				state := loadPremiumState(t, db, "firebase-1")
+				if state.premiumType == nil || *state.premiumType != "sappsr_pro_a_1m" {
+					t.Fatalf("premium_type = %v, want sappsr_pro_a_1m", state.premiumType)
+				}
+			},
+		},
+		{
+			name:  "transfer moves premium to the other user",
+			setup: []RevenueCatEvent{purchase()},
+			event: func() RevenueCatEvent {
+				event := newEvent(EventTransfer, "firebase-2", "", "", time.Time{})
+				event.Event.TransferredFrom = []string{"firebase-1"}
+				event.Event.TransferredTo = []string{"firebase-2"}
+				return event
+			},
+			check: func(t *testing.T) {
+				if state := loadPremiumState(t, db, "firebase-1"); state.premiumID != nil {
+					t.Errorf("old user premium_id = %v, want nil", *state.premiumID)
+				}
+				if state := loadPremiumState(t, db, "firebase-2"); state.premiumID == nil || *state.premiumID != "tx-1" {
+					t.Errorf("new user premium_id = %v, want tx-1", state.premiumID)
+				}
+			},
+		},
+		{
+			name: "duplicate event is not applied twice",
+			setup: []RevenueCatEvent{
+				purchase(),
+				newEvent(EventRefund, "firebase-1", "tx-1", "", time.Time{}),
+			},
+			event: nil,
+			check: func(t *testing.T) {
+				if state := loadPremiumState(t, db, "firebase-1"); state.premiumID != nil {
+					t.Fatalf("premium_id = %v, replayed purchase must not grant again", *state.premiumID)
+				}
+			},
+		},
+		{
+			name: "sandbox purchase is not applied for non debug users",
+			event: func() RevenueCatEvent {
+				event := purchase()
+				event.Event.Environment = EnvironmentSandbox
+				return event
+			},
+			check: func(t *testing.T) {
+				if state := loadPremiumState(t, db, "firebase-1"); state.premiumID != nil {
+					t.Fatalf("premium_id = %v, want nil", *state.premiumID)
+				}
+				var sandbox bool
+				var skipReason *string
+				err := db.QueryRow(ctx, `SELECT sandbox, skip_reason FROM revenuecat_logs`).Scan(&sandbox, &skipReason)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !sandbox || skipReason == nil || *skipReason != skipReasonSandboxNonDebugUser {
+					t.Errorf("sandbox = %v, skip_reason = %v", sandbox, skipReason)
+				}
+			},
+		},
+		{
+			name: "sandbox purchase is applied for debug users",
+			event: func() RevenueCatEvent {
+				event := newEvent(EventInitialPurchase, "firebase-2", "tx-1", "sappsr_pro_a_1w", nextWeek)
+				event.Event.Environment = EnvironmentSandbox
+				return event
+			},
+			check: func(t *testing.T) {
+				if state := loadPremiumState(t, db, "firebase-2"); state.premiumID == nil || *state.premiumID != "tx-1" {
+					t.Fatalf("premium_id = %v, want tx-1", state.premiumID)
+				}
+			},
+		},
+		{
+			name: "stale event is rejected",
+			event: func() RevenueCatEvent {
+				event := purchase()
+				event.Event.EventTimestampMs = now.Add(-30 * 24 * time.Hour).UnixMilli()
+				return event
+			},
+			wantErr: ErrStaleEvent,
+			check: func(t *testing.T) {
+				if state := loadPremiumState(t, db, "firebase-1"); state.premiumID != nil {
+					t.Fatalf("premium_id = %v, want nil", *state.premiumID)
+				}
+			},
+		},
+		{
+			name: "event without id is rejected",
+			event: func() RevenueCatEvent {
+				event := purchase()
+				event.Event.ID = nil
+				return event
+			},
+			wantErr: ErrMissingEventID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maindbtest.Truncate(t, db, "users", "premium_data", "revenuecat_logs")
+			_, err := db.Exec(ctx, `INSERT INTO users (id, firebase_id, debug) VALUES ('user-1', 'firebase-1', false), ('user-2', 'firebase-2', true)`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range tt.setup {
+				if err := s.HandleWebhook(ctx, &tt.setup[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			event := RevenueCatEvent{}
+			if tt.event != nil {
+				event = tt.event()
+			} else {
+				// Redeliver the first setup event
+				event = tt.setup[0]
+			}
+			err = s.HandleWebhook(ctx, &event)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t)
+			}
+		})
+	}
+}
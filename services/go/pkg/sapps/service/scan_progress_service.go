@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"sapps/lib/util"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	TrendUp   = "up"
+	TrendDown = "down"
+	TrendFlat = "flat"
+)
+
+var (
+	ErrScanNotFound     = errors.New("scan not found")
+	ErrScanNotCompleted = errors.New("scan is not completed")
+	ErrScanUnrated      = errors.New("scan has no ratings")
+)
+
+// ScanPoint is a completed scan of a user.
+type ScanPoint struct {
+	ScanID    string
+	CreatedAt time.Time
+	Data      model.ScanData
+}
+
+type RatingPoint struct {
+	ScanID    string `json:"scan_id"`
+	CreatedAt int64  `json:"created_at"`
+	Value     int    `json:"value"`
+}
+
+// RatingTrend summarizes a rating from the first scan to the latest one.
+type RatingTrend struct {
+	First     int     `json:"first"`
+	Latest    int     `json:"latest"`
+	Change    int     `json:"change"`
+	Best      int     `json:"best"`
+	Worst     int     `json:"worst"`
+	Average   float64 `json:"average"`
+	Direction string  `json:"direction"`
+}
+
+type RatingSeries struct {
+	Rating string        `json:"rating"`
+	Points []RatingPoint `json:"points"`
+	Trend  RatingTrend   `json:"trend"`
+}
+
+type ScanProgress struct {
+	Scans        int            `json:"scans"`
+	FirstScanAt  *int64         `json:"first_scan_at"`
+	LatestScanAt *int64         `json:"latest_scan_at"`
+	MostImproved *string        `json:"most_improved"`
+	MostDeclined *string        `json:"most_declined"`
+	Ratings      []RatingSeries `json:"ratings"`
+}
+
+type RatingDelta struct {
+	Rating string `json:"rating"`
+	A      int    `json:"a"`
+	B      int    `json:"b"`
+	Delta  int    `json:"delta"`
+}
+
+type ScanRef struct {
+	ScanID    string `json:"scan_id"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type ScanComparison struct {
+	A      ScanRef       `json:"a"`
+	B      ScanRef       `json:"b"`
+	Days   float64       `json:"days"`
+	Deltas []RatingDelta `json:"deltas"`
+}
+
+type ScanProgressService struct {
+	db *maindb.MainDB
+}
+
+func NewScanProgressService(db *maindb.MainDB) *ScanProgressService {
+	return &ScanProgressService{db: db}
+}
+
+// Progress returns the rating history of the user's completed scans.
+func (s *ScanProgressService) Progress(ctx context.Context, userID string) (*ScanProgress, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT scan_id, created_at, data FROM scans
+		WHERE user_id = $1 AND status = $2 AND data IS NOT NULL
+		ORDER BY created_at
+	`, userID, ScanStatusCompleted)
+	if err != nil {
+		return nil, err
+	}
+	points, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ScanPoint, error) {
+		var point ScanPoint
+		var data []byte
+		if err := row.Scan(&point.ScanID, &point.CreatedAt, &data); err != nil {
+			return point, err
+		}
+		return point, json.Unmarshal(data, &point.Data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return BuildScanProgress(points), nil
+}
+
+// Compare returns how every rating changed from scan a to scan b.
+func (s *ScanProgressService) Compare(ctx context.Context, userID string, a string, b string) (*ScanComparison, error) {
+	pointA, err := s.point(ctx, userID, a)
+	if err != nil {
+		return nil, err
+	}
+	pointB, err := s.point(ctx, userID, b)
+	if err != nil {
+		return nil, err
+	}
+	return CompareScans(pointA, pointB), nil
+}
+
+func (s *ScanProgressService) point(ctx context.Context, userID string, scanID string) (ScanPoint, error) {
+	point := ScanPoint{ScanID: scanID}
+	var status string
+	var data []byte
+	err := s.db.QueryRow(ctx, `
+		SELECT status, created_at, data FROM scans WHERE scan_id = $1 AND user_id = $2
+	`, scanID, userID).Scan(&status, &point.CreatedAt, &data)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return point, ErrScanNotFound
+		}
+		return point, err
+	}
+	if status != ScanStatusCompleted || data == nil {
+		return point, ErrScanNotCompleted
+	}
+	if err := json.Unmarshal(data, &point.Data); err != nil {
+		return point, err
+	}
+	if !scanRated(point.Data) {
+		return point, ErrScanUnrated
+	}
+	return point, nil
+}
+
+// BuildScanProgress turns scans ordered by time into a series per rating.
+// Scans without every rating are left out.
+func BuildScanProgress(points []ScanPoint) *ScanProgress {
+	points = slices.DeleteFunc(slices.Clone(points), func(point ScanPoint) bool {
+		return !scanRated(point.Data)
+	})
+	progress := &ScanProgress{Scans: len(points), Ratings: []RatingSeries{}}
+	if len(points) == 0 {
+		return progress
+	}
+	first, latest := points[0].CreatedAt.Unix(), points[len(points)-1].CreatedAt.Unix()
+	progress.FirstScanAt, progress.LatestScanAt = &first, &latest
+
+	values := make([]map[string]int, len(points))
+	for i, point := range points {
+		values[i] = scanRatingValues(point.Data)
+	}
+	for _, rating := range scanRatings {
+		series := RatingSeries{Rating: rating}
+		sum := 0
+		for i, point := range points {
+			value := values[i][rating]
+			series.Points = append(series.Points, RatingPoint{
+				ScanID:    point.ScanID,
+				CreatedAt: point.CreatedAt.Unix(),
+				Value:     value,
+			})
+			sum += value
+			if i == 0 || value > series.Trend.Best {
+				series.Trend.Best = value
+			}
+			if i == 0 || value < series.Trend.Worst {
+				series.Trend.Worst = value
+			}
+		}
+		series.Trend.First = series.Points[0].Value
+		series.Trend.Latest = series.Points[len(series.Points)-1].Value
+		series.Trend.Change = series.Trend.Latest - series.Trend.First
+		series.Trend.Average = util.Round(float64(sum)/float64(len(points)), 2)
+		series.Trend.Direction = trendDirection(series.Trend.Change)
+		progress.Ratings = append(progress.Ratings, series)
+	}
+
+	// Only ratings that actually moved are called out
+	var improved, declined *RatingSeries
+	for i := range progress.Ratings {
+		series := &progress.Ratings[i]
+		if series.Trend.Change > 0 && (improved == nil || series.Trend.Change > improved.Trend.Change) {
+			improved = series
+		}
+		if series.Trend.Change < 0 && (declined == nil || series.Trend.Change < declined.Trend.Change) {
+			declined = series
+		}
+	}
+	if improved != nil {
+		progress.MostImproved = &improved.Rating
+	}
+	if declined != nil {
+		progress.MostDeclined = &declined.Rating
+	}
+	return progress
+}
+
+// CompareScans returns the change of every rating from a to b.
+func CompareScans(a ScanPoint, b ScanPoint) *ScanComparison {
+	valuesA, valuesB := scanRatingValues(a.Data), scanRatingValues(b.Data)
+	comparison := &ScanComparison{
+		A:    ScanRef{ScanID: a.ScanID, CreatedAt: a.CreatedAt.Unix()},
+		B:    ScanRef{ScanID: b.ScanID, CreatedAt: b.CreatedAt.Unix()},
+		Days: util.Round(b.CreatedAt.Sub(a.CreatedAt).Hours()/24, 2),
+	}
+	for _, rating := range scanRatings {
+		comparison.Deltas = append(comparison.Deltas, RatingDelta{
+			Rating: rating,
+			A:      valuesA[rating],
+			B:      valuesB[rating],
+			Delta:  valuesB[rating] - valuesA[rating],
+		})
+	}
+	return comparison
+}
+
+func scanRatingValues(data model.ScanData) map[string]int {
+	return map[string]int{
+		"face_overall_rating": data.FaceOverallRating,
+		"femininity_rating":   data.FemininityRating,
+		"masculinity_rating":  data.MasculinityRating,
+		"face_rating":         data.FaceRating,
+		"eyes_rating":         data.EyesRating,
+		"jawline_rating":      data.JawlineRating,
+		"skin_rating":         data.SkinRating,
+	}
+}
+
+// scanRated reports whether every rating is in range. Scans parsed from the
+// old TOML responses stored 0 for ratings that were not found.
+func scanRated(data model.ScanData) bool {
+	for _, value := range scanRatingValues(data) {
+		if value < minScanRating || value > maxScanRating {
+			return false
+		}
+	}
+	return true
+}
+
+func trendDirection(change int) string {
+	switch {
+	case change > 0:
+		return TrendUp
+	case change < 0:
+		return TrendDown
+	}
+	return TrendFlat
+}
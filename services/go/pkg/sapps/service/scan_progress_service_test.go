@@ -0,0 +1,78 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"sapps/pkg/sapps/model"
+)
+
+// ratedScan has every rating at 5 except the given ones.
+func ratedScan(overall, skin, eyes int) model.ScanData {
+	return model.ScanData{
+		FaceOverallRating: overall,
+		FemininityRating:  5,
+		MasculinityRating: 5,
+		FaceRating:        5,
+		EyesRating:        eyes,
+		JawlineRating:     5,
+		SkinRating:        skin,
+	}
+}
+
+func TestBuildScanProgress(t *testing.T) {
+	if progress := BuildScanProgress(nil); progress.Scans != 0 || progress.FirstScanAt != nil || len(progress.Ratings) != 0 {
+		t.Fatalf("progress without scans %+v", progress)
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := []ScanPoint{
+		// Parsed from an old TOML response that had no skin rating
+		{ScanID: "0", CreatedAt: start.AddDate(0, 0, -7), Data: ratedScan(9, 0, 7)},
+		{ScanID: "1", CreatedAt: start, Data: ratedScan(5, 6, 7)},
+		{ScanID: "2", CreatedAt: start.AddDate(0, 0, 7), Data: ratedScan(7, 4, 7)},
+		{ScanID: "3", CreatedAt: start.AddDate(0, 0, 14), Data: ratedScan(6, 5, 7)},
+	}
+	progress := BuildScanProgress(points)
+	if progress.Scans != 3 || *progress.FirstScanAt != start.Unix() || *progress.LatestScanAt != start.AddDate(0, 0, 14).Unix() {
+		t.Fatalf("progress %+v", progress)
+	}
+	if len(progress.Ratings) != len(scanRatings) {
+		t.Fatalf("%d series", len(progress.Ratings))
+	}
+	trends := map[string]RatingTrend{}
+	for _, series := range progress.Ratings {
+		if len(series.Points) != 3 {
+			t.Fatalf("%s has %d points", series.Rating, len(series.Points))
+		}
+		trends[series.Rating] = series.Trend
+	}
+	overall := trends["face_overall_rating"]
+	if overall != (RatingTrend{First: 5, Latest: 6, Change: 1, Best: 7, Worst: 5, Average: 6, Direction: TrendUp}) {
+		t.Fatalf("overall trend %+v", overall)
+	}
+	if trends["skin_rating"].Direction != TrendDown || trends["eyes_rating"].Direction != TrendFlat {
+		t.Fatalf("trends %+v", trends)
+	}
+	if *progress.MostImproved != "face_overall_rating" || *progress.MostDeclined != "skin_rating" {
+		t.Fatalf("most improved %s, most declined %s", *progress.MostImproved, *progress.MostDeclined)
+	}
+}
+
+func TestCompareScans(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	comparison := CompareScans(
+		ScanPoint{ScanID: "a", CreatedAt: start, Data: model.ScanData{FaceOverallRating: 5, JawlineRating: 8}},
+		ScanPoint{ScanID: "b", CreatedAt: start.Add(36 * time.Hour), Data: model.ScanData{FaceOverallRating: 7, JawlineRating: 6}},
+	)
+	if comparison.Days != 1.5 {
+		t.Fatalf("days = %v", comparison.Days)
+	}
+	deltas := map[string]int{}
+	for _, delta := range comparison.Deltas {
+		deltas[delta.Rating] = delta.Delta
+	}
+	if deltas["face_overall_rating"] != 2 || deltas["jawline_rating"] != -2 || deltas["skin_rating"] != 0 {
+		t.Fatalf("deltas %+v", comparison.Deltas)
+	}
+}
@@ -0,0 +1,410 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"sapps/lib/util"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/model"
+	"sapps/pkg/sapps/queue"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/jackc/pgx/v5"
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/shared"
+)
+
+const (
+	ScanStatusProcessing = "processing"
+	ScanStatusCompleted  = "completed"
+	ScanStatusFailed     = "failed"
+)
+
+const (
+	JobProcessScan = "process_scan"
+	// Failed and malformed responses are retried by the job, the usage of
+	// every attempt is billed
+	scanMaxAttempts     = 3
+	scanTimeout         = 3 * time.Minute
+	scanPushRetries     = 3
+	scanNotificationKey = "scan_finished"
+)
+
+type processScanPayload struct {
+	ID string `json:"id"`
+}
+
+const (
+	minScanRating = 1
+	maxScanRating = 10
+)
+
+var ErrInvalidScanResponse = errors.New("invalid scan response")
+
+const (
+	defaultScanLanguage = "en"
+	minScanTips         = 3
+	maxScanTips         = 6
+)
+
+const scanSystemPrompt = `You are an expert facial analysis AI. Analyze the provided face image and rate the following features on a scale of 1-10.
+
+- face_overall_rating: the face as a whole
+- femininity_rating and masculinity_rating: how feminine and how masculine the face looks
+- face_rating: the shape and proportions of the face
+- eyes_rating, jawline_rating and skin_rating: the eyes, the jawline and the skin
+
+Every rating is a whole number between 1 and 10. Be honest and objective in your assessment.
+
+For every rating add a feedback entry with the rating's name as feature, one or two sentences explaining what in the image led to the rating, and the potential_rating the feature could realistically reach with grooming, skincare, fitness, hairstyle or photo improvements. The potential rating is never lower than the rating.
+
+Finally give %d to %d short, actionable tips that would improve the ratings the most, without recommending surgery.
+
+Write every explanation and tip in the language with the code %q.`
+
+// scanRatings lists the ratings of a scan in the order of the schema.
+var scanRatings = []string{
+	"face_overall_rating",
+	"femininity_rating",
+	"masculinity_rating",
+	"face_rating",
+	"eyes_rating",
+	"jawline_rating",
+	"skin_rating",
+}
+
+// languagePattern matches language codes such as en or pt-BR, the header is
+// put into the prompt so nothing else is let through.
+var languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+
+var scanResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+	OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+		JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+			Name:   "face_scan",
+			Strict: openai.Bool(true),
+			Schema: scanSchema(),
+		},
+	},
+}
+
+func scanSchema() map[string]any {
+	rating := map[string]any{
+		"type":    "integer",
+		"minimum": minScanRating,
+		"maximum": maxScanRating,
+	}
+	properties := map[string]any{}
+	for _, name := range scanRatings {
+		properties[name] = rating
+	}
+	properties["feedback"] = map[string]any{
+		"type": "array",
+		"items": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"feature":          map[string]any{"type": "string", "enum": scanRatings},
+				"explanation":      map[string]any{"type": "string"},
+				"potential_rating": rating,
+			},
+			"required":             []string{"feature", "explanation", "potential_rating"},
+			"additionalProperties": false,
+		},
+	}
+	properties["tips"] = map[string]any{
+		"type":  "array",
+		"items": map[string]any{"type": "string"},
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             append(slices.Clone(scanRatings), "feedback", "tips"),
+		"additionalProperties": false,
+	}
+}
+
+// ScanLanguage returns the language scan feedback is written in for the
+// language header.
+func ScanLanguage(language *string) string {
+	if language == nil || !languagePattern.MatchString(*language) {
+		return defaultScanLanguage
+	}
+	return *language
+}
+
+// ImageCompleter is the part of connection.ChatGPT scans use.
+type ImageCompleter interface {
+	GenerateCompletionWithImage(ctx context.Context, model shared.ChatModel, systemPrompt string, url string, responseFormat openai.ChatCompletionNewParamsResponseFormatUnion, temperature float64) (string, openai.CompletionUsage, error)
+}
+
+// Pusher sends push notifications, connection.FirebaseApp is one.
+type Pusher interface {
+	SendWithRetry(ctx context.Context, msg *messaging.Message, retryAttempts int) (*messaging.BatchResponse, error)
+}
+
+type ScanService struct {
+	db       *maindb.MainDB
+	chatGPT  ImageCompleter
+	firebase Pusher
+}
+
+// NewScanService returns the service processing scans. firebase may be nil,
+// finished scans are then not pushed to the user.
+func NewScanService(db *maindb.MainDB, chatGPT ImageCompleter, firebase Pusher) *ScanService {
+	return &ScanService{
+		db:       db,
+		chatGPT:  chatGPT,
+		firebase: firebase,
+	}
+}
+
+// Register adds the scan job handlers to the queue.
+func (s *ScanService) Register(q *queue.Queue) {
+	q.Register(JobProcessScan, s.process, s.processFailed)
+}
+
+// EnqueueProcessScan schedules analyzing the processing scans row.
+func EnqueueProcessScan(ctx context.Context, db queue.Execer, scanID string) error {
+	return queue.Enqueue(ctx, db, JobProcessScan, processScanPayload{ID: scanID}, scanMaxAttempts)
+}
+
+func (s *ScanService) process(ctx context.Context, job queue.Job) error {
+	var payload processScanPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return queue.Permanent(err)
+	}
+
+	var userID, imageID, language, ipAddress, status string
+	err := s.db.QueryRow(ctx, `
+		SELECT user_id, image_id, COALESCE(language, ''), COALESCE(ip_address, ''), status FROM scans WHERE scan_id = $1
+	`, payload.ID).Scan(&userID, &imageID, &language, &ipAddress, &status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// Deleted by the user before it was processed
+			return nil
+		}
+		return err
+	}
+	if status != ScanStatusProcessing {
+		return nil
+	}
+
+	analyzeCtx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+	imageURL := ImageURL(imageID)
+	data, usage, err := s.Analyze(analyzeCtx, imageURL, ScanLanguage(&language))
+	if usage.TotalTokens > 0 {
+		if err := maindb.InsertCost(ctx, s.db, userID, OpenAICost(usage), maindb.CostReasonScan, payload.ID, ipAddress); err != nil {
+			util.LogErr(err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return queue.Permanent(err)
+	}
+	return s.finish(ctx, payload.ID, ScanStatusCompleted, dataJSON)
+}
+
+func (s *ScanService) processFailed(ctx context.Context, job queue.Job, jobErr error) {
+	var payload processScanPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		util.LogErr(err)
+		return
+	}
+	if err := s.finish(ctx, payload.ID, ScanStatusFailed, nil); err != nil {
+		util.LogErr(err)
+	}
+}
+
+// finish moves the scan out of processing and refunds the coins and quota use
+// spent on it when it failed, in one transaction so a scan is never refunded
+// twice. The user is notified once the scan is finished.
+func (s *ScanService) finish(ctx context.Context, scanID string, status string, data []byte) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID string
+	err = tx.QueryRow(ctx, `
+		UPDATE scans SET status = $1, data = $2, completed_at = NOW() WHERE scan_id = $3 AND status = $4 RETURNING user_id
+	`, status, data, scanID, ScanStatusProcessing).Scan(&userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if status != ScanStatusCompleted {
+		if err := maindb.RefundCoins(ctx, tx, maindb.CoinReasonScan, scanID, maindb.CoinReasonScanRefund); err != nil {
+			return err
+		}
+		if err := maindb.ReleaseUsage(ctx, tx, scanID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if err := s.notify(ctx, userID, scanID, status); err != nil {
+		util.LogErr(err)
+	}
+	return nil
+}
+
+// notify pushes the finished scan to the user's device, unless they turned
+// notifications off.
+func (s *ScanService) notify(ctx context.Context, userID string, scanID string, status string) error {
+	if s.firebase == nil {
+		return nil
+	}
+	var token, language string
+	err := s.db.QueryRow(ctx, `
+		SELECT COALESCE(firebase_token, ''), COALESCE(language, '') FROM users
+		WHERE id = $1 AND notification_permission IS NOT FALSE
+	`, userID).Scan(&token, &language)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if token == "" {
+		return nil
+	}
+
+	key := scanNotificationKey + "_" + status
+	_, err = s.firebase.SendWithRetry(ctx, &messaging.Message{
+		Token: token,
+		Notification: &messaging.Notification{
+			Title: util.GetTranslation(language, key+"_title"),
+			Body:  util.GetTranslation(language, key+"_body"),
+		},
+		Data: map[string]string{
+			"not_type": scanNotificationKey,
+			"scan_id":  scanID,
+			"status":   status,
+		},
+	}, scanPushRetries)
+	return err
+}
+
+// Analyze rates the face at imageURL and explains the ratings in language,
+// see ScanLanguage. The usage is returned for malformed responses too, so it
+// can be billed either way.
+func (s *ScanService) Analyze(ctx context.Context, imageURL string, language string) (model.ScanData, openai.CompletionUsage, error) {
+	response, usage, err := s.chatGPT.GenerateCompletionWithImage(
+		ctx,
+		shared.ChatModelGPT5,
+		fmt.Sprintf(scanSystemPrompt, minScanTips, maxScanTips, language),
+		imageURL,
+		scanResponseFormat,
+		1,
+	)
+	if err != nil {
+		return model.ScanData{}, usage, err
+	}
+	data, err := ParseScanResponse(response)
+	if err != nil {
+		return model.ScanData{}, usage, fmt.Errorf("%w: %s", err, response)
+	}
+	data.Language = language
+	return data, usage, nil
+}
+
+// ParseScanResponse decodes a scan response. Every rating must be present and
+// within range. A potential below its rating is raised to the rating.
+func ParseScanResponse(response string) (model.ScanData, error) {
+	var parsed struct {
+		FaceOverallRating *int                 `json:"face_overall_rating"`
+		FemininityRating  *int                 `json:"femininity_rating"`
+		MasculinityRating *int                 `json:"masculinity_rating"`
+		FaceRating        *int                 `json:"face_rating"`
+		EyesRating        *int                 `json:"eyes_rating"`
+		JawlineRating     *int                 `json:"jawline_rating"`
+		SkinRating        *int                 `json:"skin_rating"`
+		Feedback          []model.ScanFeedback `json:"feedback"`
+		Tips              []string             `json:"tips"`
+	}
+	if err := json.Unmarshal([]byte(response), &parsed); err != nil {
+		return model.ScanData{}, fmt.Errorf("%w: %v", ErrInvalidScanResponse, err)
+	}
+	ratings := map[string]*int{
+		"face_overall_rating": parsed.FaceOverallRating,
+		"femininity_rating":   parsed.FemininityRating,
+		"masculinity_rating":  parsed.MasculinityRating,
+		"face_rating":         parsed.FaceRating,
+		"eyes_rating":         parsed.EyesRating,
+		"jawline_rating":      parsed.JawlineRating,
+		"skin_rating":         parsed.SkinRating,
+	}
+	for _, name := range scanRatings {
+		rating := ratings[name]
+		if rating == nil {
+			return model.ScanData{}, fmt.Errorf("%w: %s is missing", ErrInvalidScanResponse, name)
+		}
+		if *rating < minScanRating || *rating > maxScanRating {
+			return model.ScanData{}, fmt.Errorf("%w: %s is %d", ErrInvalidScanResponse, name, *rating)
+		}
+	}
+
+	feedback := map[string]model.ScanFeedback{}
+	for _, entry := range parsed.Feedback {
+		rating, ok := ratings[entry.Feature]
+		if !ok {
+			return model.ScanData{}, fmt.Errorf("%w: feedback for unknown feature %s", ErrInvalidScanResponse, entry.Feature)
+		}
+		if strings.TrimSpace(entry.Explanation) == "" {
+			return model.ScanData{}, fmt.Errorf("%w: %s has no explanation", ErrInvalidScanResponse, entry.Feature)
+		}
+		if entry.PotentialRating > maxScanRating {
+			return model.ScanData{}, fmt.Errorf("%w: %s has potential %d", ErrInvalidScanResponse, entry.Feature, entry.PotentialRating)
+		}
+		// A feature can always keep its rating, a lower potential is a slip
+		// not worth failing the scan for
+		entry.PotentialRating = max(entry.PotentialRating, *rating)
+		entry.Explanation = strings.TrimSpace(entry.Explanation)
+		feedback[entry.Feature] = entry
+	}
+	data := model.ScanData{
+		FaceOverallRating: *parsed.FaceOverallRating,
+		FemininityRating:  *parsed.FemininityRating,
+		MasculinityRating: *parsed.MasculinityRating,
+		FaceRating:        *parsed.FaceRating,
+		EyesRating:        *parsed.EyesRating,
+		JawlineRating:     *parsed.JawlineRating,
+		SkinRating:        *parsed.SkinRating,
+	}
+	for _, name := range scanRatings {
+		entry, ok := feedback[name]
+		if !ok {
+			return model.ScanData{}, fmt.Errorf("%w: %s has no feedback", ErrInvalidScanResponse, name)
+		}
+		data.Feedback = append(data.Feedback, entry)
+	}
+
+	for _, tip := range parsed.Tips {
+		if tip = strings.TrimSpace(tip); tip != "" {
+			data.Tips = append(data.Tips, tip)
+		}
+	}
+	if len(data.Tips) < minScanTips {
+		return model.ScanData{}, fmt.Errorf("%w: %d tips", ErrInvalidScanResponse, len(data.Tips))
+	}
+	if len(data.Tips) > maxScanTips {
+		data.Tips = data.Tips[:maxScanTips]
+	}
+	return data, nil
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"sapps/pkg/sapps/constant"
+	maindb "sapps/pkg/sapps/lib/db/main"
+	"sapps/pkg/sapps/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const shareTokenLength = 32
+
+var ErrShareNotFound = errors.New("share not found")
+
+type ScanShare struct {
+	Token   string `json:"token"`
+	URL     string `json:"url"`
+	CardURL string `json:"card_url"`
+}
+
+type SharedRating struct {
+	Label  string `json:"label"`
+	Rating int    `json:"rating"`
+}
+
+// SharedScan is what the public share page shows. It leaves out the image,
+// the feedback and anything identifying the user.
+type SharedScan struct {
+	Share     ScanShare      `json:"share"`
+	Overall   int            `json:"overall"`
+	Ratings   []SharedRating `json:"ratings"`
+	CreatedAt int64          `json:"created_at"`
+}
+
+type ScanShareService struct {
+	db *maindb.MainDB
+}
+
+func NewScanShareService(db *maindb.MainDB) *ScanShareService {
+	return &ScanShareService{db: db}
+}
+
+// Share returns the share link of the user's completed scan, creating it on
+// the first call.
+func (s *ScanShareService) Share(ctx context.Context, userID string, scanID string) (*ScanShare, error) {
+	var status string
+	var token *string
+	err := s.db.QueryRow(ctx, `
+		SELECT s.status, sh.token FROM scans s
+		LEFT JOIN scan_shares sh ON sh.scan_id = s.scan_id
+		WHERE s.scan_id = $1 AND s.user_id = $2
+	`, scanID, userID).Scan(&status, &token)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrScanNotFound
+		}
+		return nil, err
+	}
+	if status != ScanStatusCompleted {
+		return nil, ErrScanNotCompleted
+	}
+	if token != nil {
+		return newScanShare(*token), nil
+	}
+
+	newToken, err := shareToken()
+	if err != nil {
+		return nil, err
+	}
+	// A concurrent request may have shared the scan in the meantime, its
+	// token wins
+	var shared string
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO scan_shares (token, scan_id, user_id) VALUES ($1, $2, $3)
+		ON CONFLICT (scan_id) DO UPDATE SET scan_id = EXCLUDED.scan_id
+		RETURNING token
+	`, newToken, scanID, userID).Scan(&shared)
+	if err != nil {
+		return nil, err
+	}
+	return newScanShare(shared), nil
+}
+
+// Shared returns the scan shared under token.
+func (s *ScanShareService) Shared(ctx context.Context, token string) (*SharedScan, error) {
+	var data []byte
+	var createdAt time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT s.data, s.created_at FROM scan_shares sh
+		JOIN scans s ON s.scan_id = sh.scan_id
+		WHERE sh.token = $1 AND s.status = $2 AND s.data IS NOT NULL
+	`, token, ScanStatusCompleted).Scan(&data, &createdAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	var scanData model.ScanData
+	if err := json.Unmarshal(data, &scanData); err != nil {
+		return nil, err
+	}
+	return NewSharedScan(token, scanData, createdAt), nil
+}
+
+func NewSharedScan(token string, data model.ScanData, createdAt time.Time) *SharedScan {
+	return &SharedScan{
+		Share:   *newScanShare(token),
+		Overall: data.FaceOverallRating,
+		Ratings: []SharedRating{
+			{Label: "Face", Rating: data.FaceRating},
+			{Label: "Eyes", Rating: data.EyesRating},
+			{Label: "Jawline", Rating: data.JawlineRating},
+			{Label: "Skin", Rating: data.SkinRating},
+			{Label: "Femininity", Rating: data.FemininityRating},
+			{Label: "Masculinity", Rating: data.MasculinityRating},
+		},
+		CreatedAt: createdAt.Unix(),
+	}
+}
+
+func newScanShare(token string) *ScanShare {
+	return &ScanShare{
+		Token:   token,
+		URL:     fmt.Sprintf("%s/share/%s", constant.API_URL, token),
+		CardURL: fmt.Sprintf("%s/share/%s/card.png", constant.API_URL, token),
+	}
+}
+
+// ValidShareToken reports whether token has the form of a share token.
+func ValidShareToken(token string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	return len(token) == shareTokenLength && err == nil && len(decoded) == 24
+}
+
+// shareToken returns 192 random bits, share links cannot be guessed.
+func shareToken() (string, error) {
+	tokenBytes := make([]byte, 24)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
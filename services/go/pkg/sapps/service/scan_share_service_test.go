@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"sapps/pkg/sapps/model"
+)
+
+func TestShareToken(t *testing.T) {
+	token, err := shareToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidShareToken(token) {
+		t.Fatalf("generated token %q is not valid", token)
+	}
+	if other, _ := shareToken(); other == token {
+		t.Fatal("tokens repeat")
+	}
+	for _, token := range []string{"", "abc", "../../etc/passwd", token + "a", token[:31] + "!"} {
+		if ValidShareToken(token) {
+			t.Errorf("%q is valid", token)
+		}
+	}
+}
+
+func TestRenderShareCard(t *testing.T) {
+	shared := NewSharedScan("token", model.ScanData{FaceOverallRating: 7, SkinRating: 12, EyesRating: 5}, time.Now())
+	if shared.Overall != 7 || len(shared.Ratings) != 6 {
+		t.Fatalf("shared %+v", shared)
+	}
+	card, err := RenderShareCard(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := card.Bounds(); bounds.Dx() != ShareCardWidth || bounds.Dy() != ShareCardHeight {
+		t.Fatalf("card is %v", bounds)
+	}
+}
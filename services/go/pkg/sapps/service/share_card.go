@@ -0,0 +1,90 @@
+package service
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Share cards use the size link previews expect.
+const (
+	ShareCardWidth  = 1200
+	ShareCardHeight = 630
+)
+
+var (
+	shareCardBackground = color.RGBA{R: 0x14, G: 0x16, B: 0x1f, A: 0xff}
+	shareCardText       = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	shareCardMuted      = color.RGBA{R: 0x9a, G: 0xa0, B: 0xb4, A: 0xff}
+	shareCardTrack      = color.RGBA{R: 0x2a, G: 0x2e, B: 0x3d, A: 0xff}
+	shareCardAccent     = color.RGBA{R: 0x5b, G: 0x8d, B: 0xff, A: 0xff}
+)
+
+var shareCardBoldFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(gobold.TTF)
+})
+
+var shareCardRegularFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+// RenderShareCard draws the ratings of a shared scan. The face itself is left
+// out, the card is shown to anyone the link is sent to.
+func RenderShareCard(shared *SharedScan) (image.Image, error) {
+	bold, err := shareCardBoldFont()
+	if err != nil {
+		return nil, err
+	}
+	regular, err := shareCardRegularFont()
+	if err != nil {
+		return nil, err
+	}
+	face := func(f *opentype.Font, size float64) (font.Face, error) {
+		return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	}
+	titleFace, err := face(bold, 44)
+	if err != nil {
+		return nil, err
+	}
+	scoreFace, err := face(bold, 160)
+	if err != nil {
+		return nil, err
+	}
+	labelFace, err := face(regular, 30)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, ShareCardWidth, ShareCardHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(shareCardBackground), image.Point{}, draw.Src)
+	text := func(f font.Face, c color.Color, x, y int, s string) {
+		d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: f, Dot: fixed.P(x, y)}
+		d.DrawString(s)
+	}
+	rect := func(c color.Color, r image.Rectangle) {
+		draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	text(titleFace, shareCardText, 80, 130, "My face scan")
+	text(scoreFace, shareCardText, 80, 380, fmt.Sprint(shared.Overall))
+	text(labelFace, shareCardMuted, 80, 440, "overall out of 10")
+
+	const barX, barWidth, firstRow, rowHeight = 560, 480, 110, 80
+	for i, entry := range shared.Ratings {
+		rating := min(max(entry.Rating, 0), maxScanRating)
+		y := firstRow + i*rowHeight
+		text(labelFace, shareCardText, barX, y, entry.Label)
+		text(labelFace, shareCardMuted, barX+barWidth+20, y+38, fmt.Sprint(rating))
+		rect(shareCardTrack, image.Rect(barX, y+18, barX+barWidth, y+38))
+		rect(shareCardAccent, image.Rect(barX, y+18, barX+barWidth*rating/maxScanRating, y+38))
+	}
+	return img, nil
+}